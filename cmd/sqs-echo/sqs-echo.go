@@ -1,20 +1,122 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
+var (
+	sleep         = flag.Int("sleep", 0, "sleep seconds after output (default: 0)")
+	jitter        = flag.Duration("jitter", 0, "add random duration in [0, jitter) to sleep")
+	exitCode      = flag.Int("exit", 0, "exit code")
+	exitRandom    = flag.String("exit-random", "", "comma separated exit codes to choose one randomly (ex. \"0,1,75\")")
+	exitFromBody  = flag.Bool("exit-from-body", false, "use exit code in body: \"exit\" field of JSON or \"exit=N\"")
+	failRate      = flag.Float64("fail-rate", 0, "probability to fail with -fail-code (0.0-1.0)")
+	failCode      = flag.Int("fail-code", 1, "exit code for -fail-rate")
+	ignoreSIGTERM = flag.Bool("ignore-sigterm", false, "ignore SIGTERM")
+	showEnv       = flag.Bool("env", false, "show SQS_* environment variables")
+	jsonOut       = flag.Bool("json", false, "output in JSON")
+)
+
+var exitInBody = regexp.MustCompile(`\bexit=(\d+)\b`)
+
+// decideExit decides an exit code.  Priority: -fail-rate, -exit-from-body,
+// -exit-random and -exit.
+func decideExit(b []byte) (int, error) {
+	if *failRate > 0 && rand.Float64() < *failRate {
+		return *failCode, nil
+	}
+	if *exitFromBody {
+		var v struct {
+			Exit *int `json:"exit"`
+		}
+		if json.Unmarshal(b, &v) == nil && v.Exit != nil {
+			return *v.Exit, nil
+		}
+		if m := exitInBody.FindSubmatch(b); m != nil {
+			return strconv.Atoi(string(m[1]))
+		}
+	}
+	if *exitRandom != "" {
+		codes := strings.Split(*exitRandom, ",")
+		return strconv.Atoi(strings.TrimSpace(codes[rand.Intn(len(codes))]))
+	}
+	return *exitCode, nil
+}
+
+func sqsEnv() map[string]string {
+	env := map[string]string{}
+	for _, s := range os.Environ() {
+		if k, v, ok := strings.Cut(s, "="); ok && strings.HasPrefix(k, "SQS_") {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+func output(b []byte, code int) {
+	var env map[string]string
+	if *showEnv {
+		env = sqsEnv()
+	}
+	if *jsonOut {
+		json.NewEncoder(os.Stderr).Encode(struct {
+			Time time.Time         `json:"time"`
+			Size int               `json:"size"`
+			Body string            `json:"body"`
+			Env  map[string]string `json:"env,omitempty"`
+			Exit int               `json:"exit"`
+		}{time.Now(), len(b), string(b), env, code})
+		return
+	}
+	log.Printf("(%d) %q", len(b), b)
+	if len(env) > 0 {
+		keys := make([]string, 0, len(env))
+		for k := range env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			log.Printf("  %s=%s", k, env[k])
+		}
+	}
+	if code != 0 {
+		log.Printf("  exit=%d", code)
+	}
+}
+
 func main() {
-	sleep := flag.Int("sleep", 0, "sleep seconds after output (default: 0)")
 	flag.Parse()
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	if *ignoreSIGTERM {
+		signal.Ignore(syscall.SIGTERM)
+	}
 	b, err := io.ReadAll(os.Stdin)
 	if err != nil {
 		panic(err)
 	}
-	log.Printf("(%d) %q", len(b), b)
-	time.Sleep(time.Duration(*sleep) * time.Second)
+	code, err := decideExit(b)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to decide exit code: %s\n", err)
+		os.Exit(2)
+	}
+	output(b, code)
+	d := time.Duration(*sleep) * time.Second
+	if *jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(*jitter)))
+	}
+	time.Sleep(d)
+	os.Exit(code)
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// newAdminHandler creates a handler of the admin API.
+//
+//   - POST /pause  : stop receiving messages
+//   - POST /resume : restart receiving messages
+//   - POST /drain  : stop receiving messages, and exit after messages in
+//     flight finished
+//   - GET /status  : state and messages in flight
+//
+// All of them respond the status in JSON.
+func newAdminHandler(ctl controller) http.Handler {
+	mux := http.NewServeMux()
+	action := func(name, done string, fn func() bool) {
+		mux.HandleFunc("/"+name, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				http.Error(w, "use POST", http.StatusMethodNotAllowed)
+				return
+			}
+			if fn() {
+				log.Printf("%s by admin API", done)
+			}
+			writeStatus(w, ctl)
+		})
+	}
+	action("pause", "paused", ctl.Pause)
+	action("resume", "resumed", ctl.Resume)
+	action("drain", "draining", ctl.Drain)
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		writeStatus(w, ctl)
+	})
+	return mux
+}
+
+func writeStatus(w http.ResponseWriter, ctl controller) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(ctl.Status())
+}
+
+// checkLoopback checks addr is "{HOST}:{PORT}" and HOST is a loopback
+// address.  The admin API has no authentication, so it mustn't be reached
+// from other hosts.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("admin API has no authentication, listen on a loopback address (localhost, 127.0.0.1 or [::1]) or a Unix domain socket: %s", addr)
+}
+
+// serveAdmin starts the admin API on addr.  addr is "{HOST}:{PORT}" with
+// a loopback HOST, or "unix:{PATH}" for a Unix domain socket.  Call returned
+// func to stop it.
+func serveAdmin(addr string, ctl controller) (func(), error) {
+	var l net.Listener
+	var err error
+	network := "tcp"
+	if p, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", p
+		// remove a socket left by previous process.
+		if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(addr)
+		}
+		// only the owner can control.
+		l, err = listenUnix(addr)
+	} else {
+		if err := checkLoopback(addr); err != nil {
+			return nil, err
+		}
+		l, err = net.Listen(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("admin API is listening on %s:%s", network, l.Addr())
+	srv := &http.Server{Handler: newAdminHandler(ctl)}
+	go srv.Serve(l)
+	return func() {
+		srv.Close()
+	}, nil
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenUnix listens on a Unix domain socket, which only the owner can
+// connect to.  The socket is created with umask 0177, so it isn't opened to
+// others even for a moment.
+func listenUnix(addr string) (net.Listener, error) {
+	old := syscall.Umask(0177)
+	l, err := net.Listen("unix", addr)
+	syscall.Umask(old)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(addr, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to restrict permission of admin socket: %s", err)
+	}
+	return l, nil
+}
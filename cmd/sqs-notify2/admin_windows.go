@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "net"
+
+// listenUnix listens on a Unix domain socket.  Windows doesn't have
+// permissions of it.
+func listenUnix(addr string) (net.Listener, error) {
+	return net.Listen("unix", addr)
+}
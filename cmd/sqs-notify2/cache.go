@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/koron/sqs-notify/sqsnotify2"
+	"github.com/koron/sqs-notify/sqsnotify2/stage"
+)
+
+const cacheCmdUsage = `Usage: sqs-notify2 cache {SUBCOMMAND} -cache {URL} [OPTIONS] [ID...]
+
+SUBCOMMANDS:
+  list    list entries with stage, age and TTL
+  get     show entries for IDs
+  delete  delete entries for IDs
+  purge   delete all entries (or entries in a stage with -stage)
+
+Memory caches are not supported, because they live only in a process of
+sqs-notify2.  Use a shared cache like redis.  purge requires "prefix" of the
+cache, or -all to scan whole DB.
+
+OPTIONS:
+`
+
+func cacheMain(args []string) error {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, cacheCmdUsage)
+		return errors.New("need a subcommand for cache")
+	}
+	sub := args[0]
+
+	var (
+		cacheName string
+		stgName   string
+		all       bool
+	)
+	fs := flag.NewFlagSet("cache "+sub, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), cacheCmdUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cacheName, "cache", "", cacheUsage)
+	fs.StringVar(&stgName, "stage", "", `filter entries by stage for list and purge (ex. "Exec")`)
+	fs.BoolVar(&all, "all", false, "purge entries in whole DB when the cache has no prefix, it may delete keys of other applications")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if cacheName == "" {
+		return errors.New("need -cache option")
+	}
+	u, err := url.Parse(cacheName)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Scheme == "memory" {
+		return errors.New("memory cache can't be inspected from other processes, use shared cache like redis")
+	}
+	if sub == "purge" && u.Query().Get("prefix") == "" && !all {
+		return errors.New("purge without prefix of the cache deletes entries in whole DB, add prefix to -cache or give -all")
+	}
+	match := func(*sqsnotify2.CacheItem) bool { return true }
+	if stgName != "" {
+		stg, err := stage.Parse(stgName)
+		if err != nil {
+			return err
+		}
+		match = func(item *sqsnotify2.CacheItem) bool { return item.Stage == stg }
+	}
+
+	cache, err := sqsnotify2.NewCache(context.Background(), cacheName)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	switch sub {
+	case "list":
+		return cacheList(cache, match)
+	case "get":
+		return cacheGet(cache, fs.Args())
+	case "delete":
+		return cacheDelete(cache, fs.Args())
+	case "purge":
+		return cachePurge(cache, match)
+	}
+	fs.Usage()
+	return fmt.Errorf("unknown subcommand for cache: %s", sub)
+}
+
+func newItemWriter() *tabwriter.Writer {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTAGE\tAGE\tTTL\tOWNER\tLEASE")
+	return w
+}
+
+func writeItem(w *tabwriter.Writer, item *sqsnotify2.CacheItem) {
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", item.ID, item.Stage,
+		fmtDuration(item.Age), fmtDuration(item.TTL), orDash(item.Owner),
+		fmtLease(item.Lease))
+}
+
+func fmtDuration(d time.Duration) string {
+	if d < 0 {
+		return "-"
+	}
+	return d.Truncate(time.Second).String()
+}
+
+func fmtLease(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func cacheList(cache sqsnotify2.Cache, match func(*sqsnotify2.CacheItem) bool) error {
+	w := newItemWriter()
+	defer w.Flush()
+	return cache.Walk(func(item *sqsnotify2.CacheItem) error {
+		if match(item) {
+			writeItem(w, item)
+		}
+		return nil
+	})
+}
+
+func cacheGet(cache sqsnotify2.Cache, ids []string) error {
+	if len(ids) == 0 {
+		return errors.New("need IDs to get")
+	}
+	w := newItemWriter()
+	defer w.Flush()
+	for _, id := range ids {
+		item, err := cache.Get(id)
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			fmt.Fprintf(os.Stderr, "not found: %s\n", id)
+			continue
+		}
+		writeItem(w, item)
+	}
+	return nil
+}
+
+func cacheDelete(cache sqsnotify2.Cache, ids []string) error {
+	if len(ids) == 0 {
+		return errors.New("need IDs to delete")
+	}
+	for _, id := range ids {
+		err := cache.Delete(id)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("deleted: %s\n", id)
+	}
+	return nil
+}
+
+func cachePurge(cache sqsnotify2.Cache, match func(*sqsnotify2.CacheItem) bool) error {
+	n := 0
+	err := cache.Walk(func(item *sqsnotify2.CacheItem) error {
+		if !match(item) {
+			return nil
+		}
+		err := cache.Delete(item.ID)
+		if err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	fmt.Printf("purged %d entries\n", n)
+	return err
+}
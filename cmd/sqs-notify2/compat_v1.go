@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/koron/sqs-notify/sqsnotify2"
+	"github.com/koron/sqs-notify/sqsnotify2/logfile"
+)
+
+const compatV1Usage = `Usage: sqs-notify2 -compat v1 [OPTIONS] {queue name} {command and args...}
+
+Run sqs-notify2 with options of sqs-notify (v1).  Options are mapped to
+sqs-notify2 equivalents.
+
+OPTIONS:
+`
+
+// v1RedisOptions is a JSON file for -redis option of v1.
+type v1RedisOptions struct {
+	Addr       string `json:"addr"`
+	Password   string `json:"password"`
+	DB         int    `json:"db"`
+	KeyPrefix  string `json:"keyPrefix"`
+	Expiration string `json:"expiration"`
+}
+
+// toCacheName converts options of v1 redis to a cache name of sqsnotify2.
+func (o *v1RedisOptions) toCacheName() (string, error) {
+	u := &url.URL{Scheme: "redis", Host: o.Addr, Path: "/" + strconv.Itoa(o.DB)}
+	if o.Password != "" {
+		u.User = url.UserPassword("", o.Password)
+	}
+	q := url.Values{}
+	if o.KeyPrefix != "" {
+		q.Set("prefix", o.KeyPrefix)
+	}
+	if o.Expiration != "" {
+		if _, err := time.ParseDuration(o.Expiration); err != nil {
+			return "", fmt.Errorf("failed to parse expiration: %s", err)
+		}
+		q.Set("lifetime", o.Expiration)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func loadV1Redis(name string) (string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	var o v1RedisOptions
+	if err := json.Unmarshal(b, &o); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %s", name, err)
+	}
+	return o.toCacheName()
+}
+
+// isCompatV1 checks arguments start with "-compat v1".  Other values of
+// -compat are rejected.
+func isCompatV1(args []string) (bool, []string, error) {
+	if len(args) == 0 {
+		return false, nil, nil
+	}
+	var v string
+	switch {
+	case args[0] == "-compat" || args[0] == "--compat":
+		if len(args) < 2 {
+			return false, nil, errors.New("-compat needs a value")
+		}
+		v, args = args[1], args[2:]
+	case strings.HasPrefix(args[0], "-compat="), strings.HasPrefix(args[0], "--compat="):
+		_, v, _ = strings.Cut(args[0], "=")
+		args = args[1:]
+	default:
+		return false, nil, nil
+	}
+	if v != "v1" {
+		return false, nil, fmt.Errorf("unsupported -compat: %s", v)
+	}
+	return true, args, nil
+}
+
+// compatV1Main runs sqs-notify2 with arguments of sqs-notify (v1).
+func compatV1Main(args []string) error {
+	var (
+		cfg = sqsnotify2.NewConfig()
+
+		version       bool
+		daemon        bool
+		nowait        bool
+		ignoreFailure bool
+		msgcache      int
+		redis         string
+		logname       string
+		pidfile       string
+		mode          string
+	)
+	fs := flag.NewFlagSet("sqs-notify2 -compat v1", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), compatV1Usage)
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&version, "version", false, "show version")
+	fs.BoolVar(&daemon, "daemon", false, "run as a daemon")
+	fs.StringVar(&cfg.Region, "region", "us-east-1", "AWS Region for queue")
+	fs.IntVar(&cfg.Workers, "worker", 4, "Num of workers")
+	fs.BoolVar(&nowait, "nowait", false, "Don't wait end of command (-remove-policy before_execution)")
+	fs.BoolVar(&ignoreFailure, "ignorefailure", false, "Don't care command failures (-remove-policy ignore_failure)")
+	fs.IntVar(&cfg.MaxMessages, "messagecount", 10, "retrieve multiple messages at once")
+	fs.IntVar(&cfg.MaxRetries, "retrymax", 4, "Num of retry count (-max-retries)")
+	fs.IntVar(&msgcache, "msgcache", 0, "Num of last messages in cache (-cache memory://?capacity={N})")
+	fs.StringVar(&redis, "redis", "", "Use redis as messages cache, JSON file of options (-cache redis://...)")
+	fs.StringVar(&logname, "logfile", "", "Log file path")
+	fs.StringVar(&pidfile, "pidfile", "", "PID file path (require -logfile)")
+	fs.StringVar(&mode, "mode", "", "pre-defined set of options for specific usecases")
+	// options which v1 doesn't have.
+	fs.StringVar(&cfg.Profile, "profile", "", "AWS profile name (sqs-notify2 only)")
+	fs.StringVar(&cfg.Endpoint, "endpoint", "", "Endpoint of SQS (sqs-notify2 only)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if version {
+		fmt.Println("sqs-notify2 version:", sqsnotify2.Version)
+		os.Exit(1)
+	}
+
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errors.New("need a queue name and a notification command")
+	}
+	if cfg.MaxMessages <= 0 || cfg.MaxMessages > 10 {
+		return errors.New("`-messagecount` should be in 1..10")
+	}
+
+	switch strings.ToLower(mode) {
+	case "":
+	case "at-most-once":
+		if nowait {
+			return errors.New("`-nowait' conflicts with at-most-once")
+		}
+		if msgcache == 0 && redis == "" {
+			return errors.New("`-msgcache' or `-redis' is required for at-most-once")
+		}
+		ignoreFailure = true
+	default:
+		return fmt.Errorf("unknown mode: %s", mode)
+	}
+
+	// v1 deletes duplicated messages which have been done, and skips ones
+	// being executed.
+	cfg.DuplicatePolicy = sqsnotify2.ByStage
+	cfg.DuplicateVisibility = 30 * time.Second
+	switch {
+	case nowait:
+		cfg.RemovePolicy = sqsnotify2.BeforeExecution
+	case ignoreFailure:
+		cfg.RemovePolicy = sqsnotify2.IgnoreFailure
+	}
+
+	switch {
+	case redis != "":
+		name, err := loadV1Redis(redis)
+		if err != nil {
+			return err
+		}
+		cfg.CacheName = name
+	case msgcache > 0:
+		// sqsnotify2 requires 10 or more capacity.
+		if msgcache < 10 {
+			msgcache = 10
+		}
+		cfg.CacheName = "memory://?capacity=" + strconv.Itoa(msgcache)
+	}
+
+	args = fs.Args()
+	cfg.QueueName = args[0]
+	cfg.CmdName = args[1]
+	cfg.CmdArgs = args[2:]
+	if cfg.Workers < 1 {
+		return errors.New("\"-worker\" should be greater than 0")
+	}
+	// sqsnotify2 runs up to 10 commands for each instance.
+	multiplier := (cfg.Workers + 9) / 10
+	if cfg.Workers > 10 {
+		cfg.Workers = 10
+	}
+
+	if daemon {
+		makeDaemon()
+	}
+
+	if err := setupLogger(cfg, logname, pidfile, logfile.Options{}); err != nil {
+		return err
+	}
+	return runNotify(cfg, runOptions{multiplier: multiplier})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/koron/sqs-notify/sqsnotify2"
+)
+
+// fileConfig is a configuration file given by -config, which is reloaded by
+// SIGHUP.  Its fields override options, and omitted fields keep them.
+type fileConfig struct {
+	Queues    []string  `json:"queues"`
+	Workers   *int      `json:"workers"`
+	Timeout   *duration `json:"timeout"`
+	KillGrace *duration `json:"killGrace"`
+	Rate      *string   `json:"rate"`
+	Filter    *string   `json:"filter"`
+	Command   []string  `json:"command"`
+}
+
+// duration is time.Duration in JSON string like "30s".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// loadConfigFile loads and validates a configuration file.
+func loadConfigFile(name string) (*fileConfig, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var fc fileConfig
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&fc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", name, err)
+	}
+	if fc.Queues != nil && len(fc.Queues) == 0 {
+		return nil, fmt.Errorf("%s: queues should not be empty", name)
+	}
+	if fc.Command != nil && len(fc.Command) == 0 {
+		return nil, fmt.Errorf("%s: command should not be empty", name)
+	}
+	if fc.Workers != nil && (*fc.Workers < 1 || *fc.Workers > 10) {
+		return nil, fmt.Errorf("%s: workers should be 1 to 10", name)
+	}
+	if fc.Rate != nil {
+		if _, err := parseRate(*fc.Rate); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	if fc.Filter != nil {
+		if err := sqsnotify2.ValidateFilter(*fc.Filter); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return &fc, nil
+}
+
+// apply overrides cfg by the file, and returns names of queues.
+func (fc *fileConfig) apply(cfg *sqsnotify2.Config, multiplier int) []string {
+	if fc.Workers != nil {
+		cfg.Workers = *fc.Workers
+	}
+	if fc.Timeout != nil {
+		cfg.Timeout = time.Duration(*fc.Timeout)
+	}
+	if fc.KillGrace != nil {
+		cfg.KillGrace = time.Duration(*fc.KillGrace)
+	}
+	if fc.Rate != nil {
+		d, _ := parseRate(*fc.Rate)
+		cfg.RateInterval = d * time.Duration(multiplier)
+	}
+	if fc.Filter != nil {
+		cfg.Filter = *fc.Filter
+	}
+	if len(fc.Command) > 0 {
+		cfg.CmdName = fc.Command[0]
+		cfg.CmdArgs = fc.Command[1:]
+	}
+	if len(fc.Queues) > 0 {
+		return fc.Queues
+	}
+	return []string{cfg.QueueName}
+}
+
+// loadConfig returns a configuration and names of queues, which are made of
+// base and a file.  name is optional.
+func loadConfig(base *sqsnotify2.Config, multiplier int, name string) (*sqsnotify2.Config, []string, error) {
+	cfg := *base
+	if name == "" {
+		return &cfg, []string{cfg.QueueName}, nil
+	}
+	fc, err := loadConfigFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	queues := fc.apply(&cfg, multiplier)
+	if len(queues) == 1 && queues[0] == "" {
+		return nil, nil, errors.New("need -queue option or queues in -config")
+	}
+	if cfg.CmdName == "" {
+		return nil, nil, errors.New("need a notification command or command in -config")
+	}
+	return &cfg, queues, nil
+}
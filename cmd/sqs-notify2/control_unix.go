@@ -0,0 +1,42 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyControl pauses by SIGUSR1, and resumes by SIGUSR2.  Call returned
+// func to stop it.
+func notifyControl(ctl controller) func() {
+	sig := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sig, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case s := <-sig:
+				switch s {
+				case syscall.SIGUSR1:
+					if ctl.Pause() {
+						log.Print("paused by SIGUSR1")
+					}
+				case syscall.SIGUSR2:
+					if ctl.Resume() {
+						log.Print("resumed by SIGUSR2")
+					}
+				}
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}
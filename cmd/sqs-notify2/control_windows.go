@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package main
+
+// notifyControl does nothing on Windows, which doesn't have SIGUSR1 and
+// SIGUSR2.
+func notifyControl(ctl controller) func() {
+	return func() {}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "log"
+
+func makeDaemon() {
+	log.Fatalln("sqs-notify2:", "windows doesn't support daemon")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/koron/sqs-notify/sqsnotify2"
+)
+
+// envFlag is a repeatable KEY=VALUE flag, which appends to environment
+// variables of commands.
+type envFlag struct {
+	env *[]string
+}
+
+func (f envFlag) String() string {
+	if f.env == nil {
+		return ""
+	}
+	return strings.Join(*f.env, ",")
+}
+
+func (f envFlag) Set(s string) error {
+	k, _, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("env should be KEY=VALUE: %s", s)
+	}
+	*f.env = append(*f.env, s)
+	return nil
+}
+
+// envFileFlag is a repeatable flag to read environment variables from a
+// file.
+type envFileFlag struct {
+	env *[]string
+}
+
+func (f envFileFlag) String() string {
+	return ""
+}
+
+func (f envFileFlag) Set(name string) error {
+	env, err := readEnvFile(name)
+	if err != nil {
+		return err
+	}
+	*f.env = append(*f.env, env...)
+	return nil
+}
+
+// readEnvFile reads a file of environment variables.  Each line is
+// KEY=VALUE, empty lines and lines which start with "#" are ignored.  Quotes
+// around values are removed.
+func readEnvFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var env []string
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		k, v, ok := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("%s:%d: should be KEY=VALUE", name, n)
+		}
+		v = strings.TrimSpace(v)
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		env = append(env, k+"="+v)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// sizeFlag is a size in bytes, which accepts suffixes "K", "M" and "G".
+type sizeFlag struct {
+	p *int64
+}
+
+func (f sizeFlag) String() string {
+	if f.p == nil || *f.p == 0 {
+		return ""
+	}
+	return strconv.FormatInt(*f.p, 10)
+}
+
+func (f sizeFlag) Set(s string) error {
+	n := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		n = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		n = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		n = 1 << 30
+	}
+	if n != 1 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid size: %s", s)
+	}
+	*f.p = v * n
+	return nil
+}
+
+// registerExecFlags registers flags to control environment of commands.
+func registerExecFlags(fs *flag.FlagSet, cfg *sqsnotify2.Config) {
+	fs.StringVar(&cfg.Dir, "dir", "", "working directory of command")
+	fs.BoolVar(&cfg.CleanEnv, "clean-env", false, "run command without environment variables of sqs-notify2, only with -env and -env-file")
+	fs.Var(envFlag{&cfg.Env}, "env", "environment variable of command in KEY=VALUE (repeatable)")
+	fs.Var(envFileFlag{&cfg.Env}, "env-file", "file of environment variables of command, KEY=VALUE for each line (repeatable)")
+	fs.StringVar(&cfg.User, "user", "", "run command as the user, name or ID (Unix only)")
+	fs.StringVar(&cfg.Group, "group", "", "run command as the group, name or ID (Unix only, default: primary group of -user)")
+	fs.DurationVar(&cfg.Rlimits.CPU, "rlimit-cpu", 0, "limit of CPU time of command (ex. \"30s\", Linux only)")
+	fs.Var(sizeFlag{&cfg.Rlimits.AddressSpace}, "rlimit-as", "limit of address space (virtual memory) of command in bytes (ex. \"512M\", Linux only)")
+	fs.Int64Var(&cfg.Rlimits.OpenFiles, "rlimit-nofile", 0, "limit of open files of command (Linux only)")
+}
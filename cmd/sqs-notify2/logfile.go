@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/koron/hupwriter"
+	"github.com/koron/sqs-notify/sqsnotify2/logfile"
+)
+
+// registerLogfileFlags registers flags to rotate the log file.
+func registerLogfileFlags(fs *flag.FlagSet, opts *logfile.Options) {
+	fs.Var(sizeFlag{&opts.MaxSize}, "logfile-max-size", `rotate the log file before it exceeds the size in bytes (ex. "100M", with -logfile)`)
+	fs.DurationVar(&opts.MaxAge, "logfile-max-age", 0, `rotate the log file when it gets older than the duration (ex. "24h", with -logfile)`)
+	fs.IntVar(&opts.MaxBackups, "logfile-max-backups", 0, "max number of rotated log files to keep (with -logfile, default 0 - keep all)")
+	fs.BoolVar(&opts.Compress, "logfile-compress", false, "compress rotated log files by gzip (with -logfile)")
+}
+
+func rotates(opts logfile.Options) bool {
+	return opts.MaxSize > 0 || opts.MaxAge > 0
+}
+
+// openLogfile opens a log file, which is reopened by SIGHUP.  It rotates
+// itself when rotation is configured by opts.
+func openLogfile(name, pidfile string, opts logfile.Options) (io.Writer, error) {
+	if !rotates(opts) {
+		return hupwriter.New(name, pidfile)
+	}
+	opts.OnError = func(err error) {
+		log.Printf("failed to maintain rotated log files: %s", err)
+	}
+	w, err := logfile.Open(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	if pidfile != "" {
+		err := os.WriteFile(pidfile, []byte(strconv.Itoa(os.Getpid())), 0666)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	go func() {
+		for range sig {
+			if err := w.Reopen(); err != nil {
+				log.Printf("failed to reopen %s: %s", name, err)
+			}
+		}
+	}()
+	return w, nil
+}
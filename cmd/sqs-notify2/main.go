@@ -8,12 +8,13 @@ import (
 	"log"
 	"os"
 	"os/signal"
-	"sync"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	valid "github.com/koron/go-valid"
-	"github.com/koron/hupwriter"
 	"github.com/koron/sqs-notify/sqsnotify2"
+	"github.com/koron/sqs-notify/sqsnotify2/logfile"
 )
 
 const (
@@ -22,6 +23,72 @@ const (
 	rpBeforeExecution = "before_execution"
 )
 
+const (
+	dpKeep    = "keep"
+	dpByStage = "by_stage"
+)
+
+const cacheUsage = `cache name or connection URL
+ * memory://?capacity=1000[&lifetime={DURATION}][&lease={DURATION}]
+
+   capacity: max num of entries, requires 10 or more
+   lifetime: lifetime of entries (ex. "10s", "2m", "3h").  no limits of
+             capacity when capacity is omitted.
+   lease:    lease of entries being processed (ex. "1m").  an entry which
+             lease has been expired is taken over by redelivered message.
+
+ * redis://[{USER}:{PASS}@]{HOST}/[{DBNUM}]?[{OPTIONS}]
+
+   DBNUM: redis DB number (default 0)
+   OPTIONS:
+	* lifetime : lifetime of cachetime (ex. "10s", "2m", "3h")
+	* prefix   : prefix of keys
+	* lease    : lease of entries being processed (ex. "1m")
+	* owner    : owner ID of entries (default "{HOSTNAME}:{PID}")
+
+   Example to connect the redis on localhost: "redis://:6379"`
+
+const (
+	imStdin = "stdin"
+	imFile  = "file"
+	imArg   = "arg"
+	imEnv   = "env"
+)
+
+const (
+	faLeave  = "leave"
+	faDelete = "delete"
+	faMove   = "move"
+)
+
+func toFA(s string) sqsnotify2.FilterAction {
+	switch s {
+	default:
+		fallthrough
+	case faLeave:
+		return sqsnotify2.FilterLeave
+	case faDelete:
+		return sqsnotify2.FilterDelete
+	case faMove:
+		return sqsnotify2.FilterMove
+	}
+}
+
+func toIM(s string) sqsnotify2.InputMode {
+	switch s {
+	default:
+		fallthrough
+	case imStdin:
+		return sqsnotify2.InputStdin
+	case imFile:
+		return sqsnotify2.InputFile
+	case imArg:
+		return sqsnotify2.InputArg
+	case imEnv:
+		return sqsnotify2.InputEnv
+	}
+}
+
 func toRP(s string) sqsnotify2.RemovePolicy {
 	switch s {
 	default:
@@ -35,50 +102,149 @@ func toRP(s string) sqsnotify2.RemovePolicy {
 	}
 }
 
+func toDP(s string) sqsnotify2.DuplicatePolicy {
+	switch s {
+	default:
+		fallthrough
+	case dpKeep:
+		return sqsnotify2.KeepDuplicate
+	case dpByStage:
+		return sqsnotify2.ByStage
+	}
+}
+
+// registerBreakerFlags registers flags of the circuit breaker.  rate is in
+// percent.
+func registerBreakerFlags(fs *flag.FlagSet, cb *sqsnotify2.CircuitBreaker, rate *float64) {
+	fs.IntVar(&cb.Failures, "breaker-failures", 0, "stop receiving messages after the number of consecutive failures of command (default 0 - disabled)")
+	fs.Float64Var(rate, "breaker-rate", 0, "stop receiving messages when failures of command exceed the percentage within -breaker-window (default 0 - disabled)")
+	fs.DurationVar(&cb.Window, "breaker-window", cb.Window, "window to count failures for -breaker-rate")
+	fs.Var(valid.Int(&cb.MinRequests, cb.MinRequests).Min(1), "breaker-min-requests", "min num of executions within -breaker-window to check -breaker-rate")
+	fs.DurationVar(&cb.Cooldown, "breaker-cooldown", cb.Cooldown, "period to stop receiving, before a probe message")
+	fs.IntVar(&cb.TempFailCode, "breaker-tempfail-code", cb.TempFailCode, "exit code of command to stop receiving immediately, 0 for none (EX_TEMPFAIL by default, with -breaker-failures or -breaker-rate)")
+}
+
+// checkBreakerFlags returns an error when -breaker-tempfail-code is given
+// without -breaker-failures or -breaker-rate, because it doesn't enable the
+// breaker by itself.
+func checkBreakerFlags(fs *flag.FlagSet, cb sqsnotify2.CircuitBreaker) error {
+	if cb.Failures > 0 || cb.Rate > 0 || cb.TempFailCode == 0 {
+		return nil
+	}
+	given := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "breaker-tempfail-code" {
+			given = true
+		}
+	})
+	if given {
+		return errors.New("-breaker-tempfail-code requires -breaker-failures or -breaker-rate")
+	}
+	return nil
+}
+
+// registerRoleFlags registers flags to assume a role.
+func registerRoleFlags(fs *flag.FlagSet, cfg *sqsnotify2.Config) {
+	fs.StringVar(&cfg.RoleARN, "role-arn", "", "ARN of a role to assume, to read queues in other accounts")
+	fs.StringVar(&cfg.ExternalID, "external-id", "", "external ID to assume the role (with -role-arn)")
+	fs.StringVar(&cfg.RoleSessionName, "role-session-name", "", `session name of the assumed role (default "sqs-notify2-{HOSTNAME}-{PID}")`)
+	fs.StringVar(&cfg.WebIdentityTokenFile, "web-identity-token-file", "", "file of a web identity (OIDC) token to assume the role (with -role-arn)")
+	fs.StringVar(&cfg.STSEndpoint, "sts-endpoint", "", "Endpoint of STS to assume the role")
+}
+
 func main2() error {
 	var (
 		cfg     = sqsnotify2.NewConfig()
 		version bool
-		logfile string
+		logname string
 		pidfile string
+		logrot  logfile.Options
+		archive string
+		trace   string
+		admin   string
+		config  string
+		rate    string
 
 		waitTimeSec  int64
 		removePolicy string
+		dupPolicy    string
+		filterAction string
+		inputMode    string
+		breakerRate  float64
 		multiplier   int
 	)
 
 	flag.StringVar(&cfg.Profile, "profile", "", "AWS profile name")
 	flag.StringVar(&cfg.Region, "region", "us-east-1", "AWS region")
 	flag.StringVar(&cfg.Endpoint, "endpoint", "", "Endpoint of SQS")
-	flag.Var(valid.String(&cfg.QueueName, "").MustSet(), "queue", "SQS queue name")
-	flag.BoolVar(&cfg.CreateQueue, "createqueue", false, "create queue if not exists")
+	registerRoleFlags(flag.CommandLine, cfg)
+	flag.StringVar(&cfg.QueueName, "queue", "", "SQS queue name (required without queues in -config)")
+	flag.StringVar(&config, "config", "", "configuration file in JSON, which is reloaded by SIGHUP (see README)")
+	flag.BoolVar(&cfg.CreateQueue, "createqueue", false, "create queue if not exists (with -queue-* options)")
+	cfg.QueueOptions = &sqsnotify2.QueueOptions{}
+	registerQueueFlags(flag.CommandLine, cfg.QueueOptions)
 	flag.IntVar(&cfg.MaxRetries, "max-retries", cfg.MaxRetries, "max retries for AWS")
 	flag.Int64Var(&waitTimeSec, "wait-time-seconds", -1, `wait time in seconds for next polling. (default -1, disabled, use queue default)`)
 
-	flag.StringVar(&cfg.CacheName, "cache", cfg.CacheName,
-		`cache name or connection URL
- * memory://?capacity=1000
- * redis://[{USER}:{PASS}@]{HOST}/[{DBNUM}]?[{OPTIONS}]
+	flag.StringVar(&cfg.Filter, "filter", "", `expression to choose messages to execute, like 'attr.type == "invoice" && body.amount > 0' (see README)`)
+	flag.Var(valid.String(&filterAction, faLeave).
+		OneOf(faLeave, faDelete, faMove), "filter-action",
+		`action for messages which don't match -filter
+ * leave  : leave messages in SQS for other consumers (default)
+ * delete : delete messages
+ * move   : send messages to -filter-queue, and delete them`)
+	flag.StringVar(&cfg.FilterQueue, "filter-queue", "", "queue to move messages which don't match -filter (with -filter-action move)")
+	flag.DurationVar(&cfg.FilterVisibility, "filter-visibility", 0, "visibility timeout for messages which don't match -filter, 0 makes them visible immediately (with -filter-action leave)")
 
-   DBNUM: redis DB number (default 0)
-   OPTIONS:
-	* lifetime : lifetime of cachetime (ex. "10s", "2m", "3h")
-	* prefix   : prefix of keys
+	flag.StringVar(&cfg.CacheName, "cache", cfg.CacheName, cacheUsage)
 
-   Example to connect the redis on localhost: "redis://:6379"`)
+	flag.StringVar(&cfg.DedupKey, "dedup-key", "message-id",
+		`key of cache to detect duplicated messages
+ * message-id   : ID of message (default)
+ * body-sha256  : SHA256 hash of body
+ * json:{PATH}  : a field in JSON body, PATH is dot separated (ex. "json:order.id")
+ * attr:{NAME}  : a message attribute (ex. "attr:IdempotencyKey")
+messages without the key fall back to message-id`)
 
 	flag.IntVar(&cfg.Workers, "workers", cfg.Workers, "num of workers")
+	flag.IntVar(&cfg.MaxMessages, "max-messages", 10, "max num of messages to receive at once (1-10)")
 	flag.Var(valid.Int(&multiplier, 1).Min(1), "multiplier", `pooling the SQS in multiple runner`)
 	flag.DurationVar(&cfg.Timeout, "timeout", 0, "timeout for command execution (default 0 - no timeout)")
+	flag.StringVar(&rate, "rate", "0", `max rate of command executions for each queue, like "10/s" or "600/m", "0" means no limits`)
+	flag.Var(valid.String(&inputMode, imStdin).
+		OneOf(imStdin, imFile, imArg, imEnv), "input",
+		`how to pass a body of message to command
+ * stdin : as STDIN (default)
+ * file  : as path of a temporary file, replaces "{file}" in arguments or
+           is appended as the last argument.  removed after execution.
+ * arg   : as the last argument (up to 128KiB)
+ * env   : as SQS_BODY environment variable (up to 128KiB)`)
+	flag.BoolVar(&cfg.Coprocess, "coprocess", false, "start long-lived copies of command for workers, and pass messages by JSON lines (see README)")
+	flag.IntVar(&cfg.CoprocessRecycle, "coprocess-recycle", 0, "restart a copy of command after it processed the number of messages (with -coprocess, default 0 - never)")
+	registerExecFlags(flag.CommandLine, cfg)
+	flag.DurationVar(&cfg.KillGrace, "kill-grace", cfg.KillGrace, "grace period to kill a process group of command after SIGTERM, on timeout or shutdown")
 	flag.Var(valid.String(&removePolicy, rpSucceed).
 		OneOf(rpSucceed, rpIgnoreFailure, rpBeforeExecution), "remove-policy",
 		`policy to remove messages from SQS
  * succeed          : after execution, succeeded (default)
  * ignore_failure   : after execution, ignore its result
  * before_execution : before execution`)
+	flag.Var(valid.String(&dupPolicy, dpKeep).
+		OneOf(dpKeep, dpByStage), "duplicate-policy",
+		`policy to handle duplicated messages found in cache
+ * keep     : leave messages in SQS (default)
+ * by_stage : by stage of cache entry
+              Done: delete, Exec: extend visibility, Recv/Lock: leave`)
+	flag.DurationVar(&cfg.DuplicateVisibility, "duplicate-visibility", 30*time.Second, "visibility timeout for duplicated messages in Exec stage (with -duplicate-policy by_stage)")
+	registerBreakerFlags(flag.CommandLine, &cfg.Breaker, &breakerRate)
+	flag.BoolVar(&cfg.DryRun, "dry-run", false, "receive messages once, show what would run for them, and make them visible again without execution")
+	flag.StringVar(&archive, "archive", "", "directory to archive received messages and their outcomes in JSON Lines, rotated daily")
+	flag.StringVar(&trace, "trace-output", "", `file or URL of OTLP/HTTP collector to export spans in OTLP JSON (ex. "http://localhost:4318")`)
+	flag.StringVar(&admin, "admin-addr", "", `address of admin API to pause, resume and drain, "{HOST}:{PORT}" with loopback HOST or "unix:{PATH}" (default "" - disabled)`)
 	flag.BoolVar(&version, "version", false, "show version")
-	flag.StringVar(&logfile, "logfile", "", "log file path")
+	flag.StringVar(&logname, "logfile", "", "log file path")
 	flag.StringVar(&pidfile, "pidfile", "", "PID file path (require -logfile)")
+	registerLogfileFlags(flag.CommandLine, &logrot)
 	if err := valid.Parse(flag.CommandLine, os.Args[1:]); err != nil {
 		return err
 	}
@@ -88,13 +254,45 @@ func main2() error {
 		os.Exit(1)
 	}
 
-	if flag.NArg() < 1 {
+	if flag.NArg() < 1 && config == "" {
 		return errors.New("need a notification command")
 	}
+	if cfg.QueueName == "" && config == "" {
+		return errors.New("need -queue option")
+	}
+	if err := checkQueueFlags(flag.CommandLine, cfg.CreateQueue); err != nil {
+		return err
+	}
 	args := flag.Args()
 	cfg.RemovePolicy = toRP(removePolicy)
-	cfg.CmdName = args[0]
-	cfg.CmdArgs = args[1:]
+	cfg.DuplicatePolicy = toDP(dupPolicy)
+	cfg.InputMode = toIM(inputMode)
+	cfg.FilterAction = toFA(filterAction)
+	if err := sqsnotify2.ValidateFilter(cfg.Filter); err != nil {
+		return err
+	}
+	if cfg.FilterAction == sqsnotify2.FilterMove && cfg.FilterQueue == "" {
+		return errors.New("-filter-action move requires -filter-queue")
+	}
+	if cfg.FilterVisibility < 0 {
+		return errors.New("-filter-visibility should be 0 or more")
+	}
+	cfg.Breaker.Rate = breakerRate / 100
+	if err := checkBreakerFlags(flag.CommandLine, cfg.Breaker); err != nil {
+		return err
+	}
+	if cfg.Coprocess && cfg.InputMode != sqsnotify2.InputStdin {
+		return errors.New("-input can't be used with -coprocess")
+	}
+	if len(args) > 0 {
+		cfg.CmdName = args[0]
+		cfg.CmdArgs = args[1:]
+	}
+	interval, err := parseRate(rate)
+	if err != nil {
+		return err
+	}
+	cfg.RateInterval = interval * time.Duration(multiplier)
 	if waitTimeSec >= 0 {
 		cfg.WaitTime = &waitTimeSec
 	}
@@ -105,30 +303,66 @@ func main2() error {
 	if cfg.Workers > 10 {
 		log.Print("\"WARN: -worker 10+\" doesn't have any effects, check \"-multiplier\"")
 	}
+	if cfg.MaxMessages < 1 || cfg.MaxMessages > 10 {
+		return errors.New("-max-messages should be in 1-10")
+	}
 
-	// Setup logger.
+	if err := setupLogger(cfg, logname, pidfile, logrot); err != nil {
+		return err
+	}
+	return runNotify(cfg, runOptions{
+		multiplier: multiplier,
+		archive:    archive,
+		trace:      trace,
+		admin:      admin,
+		config:     config,
+	})
+}
+
+// setupLogger sets up a logger of cfg by -logfile, -pidfile and
+// -logfile-max-* options.
+func setupLogger(cfg *sqsnotify2.Config, logname, pidfile string, rotation logfile.Options) error {
 	// FIXME: test logging features.
-	if pidfile != "" && logfile == "" {
+	if pidfile != "" && logname == "" {
 		return errors.New("pidfile option requires logfile option")
 	}
-	if logfile != "" {
-		if logfile == "-" {
+	if rotates(rotation) && (logname == "" || logname == "-") {
+		return errors.New("logfile-max-* options require logfile option")
+	}
+	if logname != "" {
+		if logname == "-" {
 			cfg.Logger = log.New(os.Stdout, "", log.LstdFlags)
 		} else {
-			w, err := hupwriter.New(logfile, pidfile)
+			w, err := openLogfile(logname, pidfile, rotation)
 			if err != nil {
 				return err
 			}
 			cfg.Logger = log.New(w, "", 0)
 		}
 	}
+	return nil
+}
 
+// runOptions is options to run sqsnotify2, besides Config.
+type runOptions struct {
+	multiplier int
+	archive    string
+	trace      string
+	admin      string
+	config     string
+}
+
+// runNotify runs multiplier instances of sqsnotify2 with cfg for each queue,
+// until interrupted or drained.  They are paused by SIGUSR1 and resumed by
+// SIGUSR2, and controlled by the admin API if enabled.  The configuration
+// file is reloaded by SIGHUP.
+func runNotify(cfg *sqsnotify2.Config, opts runOptions) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	sig := make(chan os.Signal, 1)
 	go func() {
 		for {
 			s := <-sig
-			if s == os.Interrupt {
+			if s == os.Interrupt || s == syscall.SIGTERM {
 				cancel()
 				signal.Stop(sig)
 				close(sig)
@@ -136,7 +370,24 @@ func main2() error {
 			}
 		}
 	}()
-	signal.Notify(sig, os.Interrupt)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	if opts.archive != "" && !cfg.DryRun {
+		a, err := sqsnotify2.NewArchive(opts.archive)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		cfg.Archive = a
+	}
+	if opts.trace != "" && !cfg.DryRun {
+		t, err := sqsnotify2.NewTracer(opts.trace, log.Printf)
+		if err != nil {
+			return err
+		}
+		defer t.Close()
+		cfg.Tracer = t
+	}
 
 	cache, err := sqsnotify2.NewCache(ctx, cfg.CacheName)
 	if err != nil {
@@ -144,31 +395,52 @@ func main2() error {
 	}
 	defer cache.Close()
 
-	var mu sync.Mutex
-	var errs []error
+	qcfg, queues, err := loadConfig(cfg, opts.multiplier, opts.config)
+	if err != nil {
+		return err
+	}
+	s := newSupervisor(ctx, cache, opts.multiplier)
+	defer notifyControl(s.ctl)()
+	if opts.admin != "" {
+		stop, err := serveAdmin(opts.admin, s.ctl)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+	s.apply(qcfg, queues)
+	if opts.config != "" {
+		defer reloadOnHUP(s, cfg, opts)()
+	}
+	return s.wait()
+}
 
-	var sg sync.WaitGroup
-	sg.Add(multiplier)
-	for i := 0; i < multiplier; i++ {
-		go func(id int) {
-			defer sg.Done()
-			err := sqsnotify2.New(cfg).Run(ctx, cache)
-			if err == nil || isCancel(err) {
+// reloadOnHUP reloads the configuration file by SIGHUP.  The old one is kept
+// when the file is invalid.  Call returned func to stop it.
+func reloadOnHUP(s *supervisor, base *sqsnotify2.Config, opts runOptions) func() {
+	hup := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-done:
 				return
+			case <-hup:
+				cfg, queues, err := loadConfig(base, opts.multiplier, opts.config)
+				if err != nil {
+					log.Printf("failed to reload %s, keep the old one: %s", opts.config, err)
+					continue
+				}
+				log.Printf("reloading %s: queues=%q", opts.config, queues)
+				s.apply(cfg, queues)
 			}
-			mu.Lock()
-			errs = append(errs, err)
-			mu.Unlock()
-			log.Printf("inner process #%d is terminated by error: %s", id, err)
-		}(i)
-	}
-	sg.Wait()
-
-	if len(errs) > 0 {
-		return errs[0]
+		}
+	}()
+	return func() {
+		signal.Stop(hup)
+		close(done)
 	}
-
-	return nil
 }
 
 func isCancel(err error) bool {
@@ -181,8 +453,37 @@ func isCancel(err error) bool {
 	return false
 }
 
+// subcommands is a table of subcommands, which are given as the first
+// argument.
+var subcommands = map[string]func(args []string) error{
+	"cache":       cacheMain,
+	"queue":       queueMain,
+	"replay":      replayMain,
+	"serve-local": serveMain,
+}
+
 func main() {
-	err := main2()
+	v1, args, err := isCompatV1(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if v1 {
+		err := compatV1Main(args)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) >= 2 {
+		if fn, ok := subcommands[os.Args[1]]; ok {
+			err := fn(os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+	err = main2()
 	if err != nil {
 		log.Fatal(err)
 	}
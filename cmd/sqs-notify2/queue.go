@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/koron/sqs-notify/sqsnotify2"
+)
+
+const queueCmdUsage = `Usage: sqs-notify2 queue {SUBCOMMAND} -queue {NAME} [OPTIONS]
+
+SUBCOMMANDS:
+  create  create a queue with attributes and tags
+  attrs   update attributes and tags (if given), and show all attributes
+  purge   delete all messages in a queue
+  delete  delete a queue
+  stats   show approximate numbers of messages
+
+OPTIONS:
+`
+
+// secondsFlag is a duration flag which is stored as seconds, and keeps nil
+// when it is not set.  It accepts only whole seconds which are 0 or more.
+type secondsFlag struct {
+	p **int64
+}
+
+func (f secondsFlag) String() string {
+	if f.p == nil || *f.p == nil {
+		return ""
+	}
+	return (time.Duration(**f.p) * time.Second).String()
+}
+
+func (f secondsFlag) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if d < 0 {
+		return fmt.Errorf("should be 0 or more: %s", s)
+	}
+	if d%time.Second != 0 {
+		return fmt.Errorf("should be whole seconds: %s", s)
+	}
+	*f.p = aws.Int64(int64(d / time.Second))
+	return nil
+}
+
+// tagsFlag is a repeatable KEY=VALUE flag.
+type tagsFlag map[string]string
+
+func (f tagsFlag) String() string {
+	var s []string
+	for k, v := range f {
+		s = append(s, k+"="+v)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (f tagsFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("tag should be KEY=VALUE: %s", s)
+	}
+	f[k] = v
+	return nil
+}
+
+// registerQueueFlags registers flags to configure attributes and tags of
+// queue.
+func registerQueueFlags(fs *flag.FlagSet, qo *sqsnotify2.QueueOptions) {
+	qo.Tags = map[string]string{}
+	fs.Var(secondsFlag{&qo.VisibilityTimeout}, "queue-visibility-timeout", "VisibilityTimeout of queue (ex. \"30s\")")
+	fs.Var(secondsFlag{&qo.MessageRetentionPeriod}, "queue-retention-period", "MessageRetentionPeriod of queue (ex. \"96h\")")
+	fs.Var(secondsFlag{&qo.ReceiveMessageWaitTimeSeconds}, "queue-receive-wait-time", "ReceiveMessageWaitTimeSeconds of queue (ex. \"20s\")")
+	fs.BoolVar(&qo.FifoQueue, "queue-fifo", false, "create a FIFO queue, its name should end with \".fifo\"")
+	fs.StringVar(&qo.DeadLetterQueue, "queue-dlq", "", "name or ARN of dead-letter queue for RedrivePolicy")
+	fs.Int64Var(&qo.MaxReceiveCount, "queue-max-receive-count", 5, "maxReceiveCount for RedrivePolicy (with -queue-dlq)")
+	fs.Var(tagsFlag(qo.Tags), "queue-tag", "tag of queue in KEY=VALUE (repeatable)")
+}
+
+// checkQueueFlags returns an error when -queue-* flags are given without
+// -createqueue, because they are used only to create a queue.
+func checkQueueFlags(fs *flag.FlagSet, create bool) error {
+	if create {
+		return nil
+	}
+	var names []string
+	fs.Visit(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "queue-") {
+			names = append(names, "-"+f.Name)
+		}
+	})
+	if len(names) > 0 {
+		return fmt.Errorf("%s require -createqueue option", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+func queueMain(args []string) error {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, queueCmdUsage)
+		return errors.New("need a subcommand for queue")
+	}
+	sub := args[0]
+
+	var (
+		cfg = sqsnotify2.NewConfig()
+		qo  = &sqsnotify2.QueueOptions{}
+	)
+	fs := flag.NewFlagSet("queue "+sub, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), queueCmdUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.Profile, "profile", "", "AWS profile name")
+	fs.StringVar(&cfg.Region, "region", cfg.Region, "AWS region")
+	fs.StringVar(&cfg.Endpoint, "endpoint", "", "Endpoint of SQS")
+	registerRoleFlags(fs, cfg)
+	fs.StringVar(&cfg.QueueName, "queue", "", "SQS queue name")
+	registerQueueFlags(fs, qo)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if cfg.QueueName == "" {
+		return errors.New("need -queue option")
+	}
+
+	ctx := context.Background()
+	api, err := sqsnotify2.NewSQS(cfg)
+	if err != nil {
+		return err
+	}
+
+	if sub == "create" {
+		qu, err := sqsnotify2.CreateQueue(ctx, api, cfg.QueueName, qo)
+		if err != nil {
+			return err
+		}
+		fmt.Println(*qu)
+		return nil
+	}
+
+	qu, err := sqsnotify2.GetQueueURL(ctx, api, cfg.QueueName)
+	if err != nil {
+		return err
+	}
+	switch sub {
+	case "attrs":
+		err := sqsnotify2.SetQueueOptions(ctx, api, qu, qo)
+		if err != nil {
+			return err
+		}
+		return showQueueAttributes(ctx, api, qu, sqs.QueueAttributeNameAll)
+	case "purge":
+		_, err := api.PurgeQueueWithContext(ctx, &sqs.PurgeQueueInput{QueueUrl: qu})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("purged: %s\n", *qu)
+		return nil
+	case "delete":
+		_, err := api.DeleteQueueWithContext(ctx, &sqs.DeleteQueueInput{QueueUrl: qu})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("deleted: %s\n", *qu)
+		return nil
+	case "stats":
+		return showQueueAttributes(ctx, api, qu,
+			sqs.QueueAttributeNameApproximateNumberOfMessages,
+			sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+			sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed)
+	}
+	fs.Usage()
+	return fmt.Errorf("unknown subcommand for queue: %s", sub)
+}
+
+func showQueueAttributes(ctx context.Context, api *sqs.SQS, queueURL *string, names ...string) error {
+	out, err := api.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       queueURL,
+		AttributeNames: aws.StringSlice(names),
+	})
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(out.Attributes))
+	for k := range out.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("%s: %s\n", k, *out.Attributes[k])
+	}
+	return nil
+}
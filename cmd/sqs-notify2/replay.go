@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/koron/sqs-notify/sqsnotify2"
+)
+
+const replayCmdUsage = `Usage: sqs-notify2 replay -from {FILE} -queue {NAME} [OPTIONS]
+
+Send messages in archive (written by -archive) to a queue again.  Records are
+selected by time range of receive, outcome and body match.
+
+OPTIONS:
+`
+
+// replayFilter selects records of archive.
+type replayFilter struct {
+	since    time.Time
+	until    time.Time
+	outcomes map[string]bool
+	match    *regexp.Regexp
+}
+
+func (f *replayFilter) accept(rec *sqsnotify2.ArchiveRecord) bool {
+	if !f.since.IsZero() && rec.ReceivedAt.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && !rec.ReceivedAt.Before(f.until) {
+		return false
+	}
+	if len(f.outcomes) > 0 && !f.outcomes[rec.Outcome] {
+		return false
+	}
+	if f.match != nil && !f.match.MatchString(rec.Body) {
+		return false
+	}
+	return true
+}
+
+// parseRate parses a rate like "10/s", "600/m" or "10" (per second), and
+// returns an interval between messages.  "0" means no limits.
+func parseRate(s string) (time.Duration, error) {
+	n, unit, ok := strings.Cut(s, "/")
+	per := time.Second
+	if ok {
+		switch unit {
+		case "s":
+		case "m":
+			per = time.Minute
+		case "h":
+			per = time.Hour
+		default:
+			return 0, fmt.Errorf("unknown unit of rate: %s", s)
+		}
+	}
+	v, err := strconv.ParseFloat(n, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid rate: %s", s)
+	}
+	if v == 0 {
+		return 0, nil
+	}
+	return time.Duration(float64(per) / v), nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func replayMain(args []string) error {
+	var (
+		cfg      = sqsnotify2.NewConfig()
+		from     string
+		rate     string
+		since    string
+		until    string
+		outcomes string
+		match    string
+		dryRun   bool
+	)
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), replayCmdUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.Profile, "profile", "", "AWS profile name")
+	fs.StringVar(&cfg.Region, "region", cfg.Region, "AWS region")
+	fs.StringVar(&cfg.Endpoint, "endpoint", "", "Endpoint of SQS")
+	registerRoleFlags(fs, cfg)
+	fs.StringVar(&cfg.QueueName, "queue", "", "SQS queue name to send")
+	fs.StringVar(&from, "from", "", "archive file, or glob pattern of files (ex. \"archive/*.jsonl\")")
+	fs.StringVar(&rate, "rate", "10/s", "rate to send messages (ex. \"10/s\", \"600/m\", \"0\" - no limits)")
+	fs.StringVar(&since, "since", "", "select records received at or after this time (RFC3339)")
+	fs.StringVar(&until, "until", "", "select records received before this time (RFC3339)")
+	fs.StringVar(&outcomes, "outcome", "", "select records by comma separated outcomes: succeeded, failed, error, skipped")
+	fs.StringVar(&match, "match", "", "select records which body matches with this regexp")
+	fs.BoolVar(&dryRun, "dry-run", false, "show selected records without sending")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if from == "" {
+		return errors.New("need -from option")
+	}
+	if cfg.QueueName == "" && !dryRun {
+		return errors.New("need -queue option")
+	}
+
+	var (
+		f   replayFilter
+		err error
+	)
+	if f.since, err = parseTime(since); err != nil {
+		return err
+	}
+	if f.until, err = parseTime(until); err != nil {
+		return err
+	}
+	if outcomes != "" {
+		f.outcomes = map[string]bool{}
+		for _, s := range strings.Split(outcomes, ",") {
+			f.outcomes[strings.TrimSpace(s)] = true
+		}
+	}
+	if match != "" {
+		if f.match, err = regexp.Compile(match); err != nil {
+			return err
+		}
+	}
+	interval, err := parseRate(rate)
+	if err != nil {
+		return err
+	}
+	files, err := filepath.Glob(from)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no archive files: %s", from)
+	}
+
+	ctx := context.Background()
+	var (
+		api *sqs.SQS
+		qu  *string
+	)
+	if !dryRun {
+		api, err = sqsnotify2.NewSQS(cfg)
+		if err != nil {
+			return err
+		}
+		qu, err = sqsnotify2.GetQueueURL(ctx, api, cfg.QueueName)
+		if err != nil {
+			return err
+		}
+	}
+
+	var last time.Time
+	var count int
+	send := func(rec *sqsnotify2.ArchiveRecord) error {
+		if !f.accept(rec) {
+			return nil
+		}
+		count++
+		if dryRun {
+			fmt.Printf("%s\t%s\t%s\t%q\n", rec.ReceivedAt.Format(time.RFC3339), rec.ID, rec.Outcome, rec.Body)
+			return nil
+		}
+		if d := interval - time.Since(last); !last.IsZero() && d > 0 {
+			time.Sleep(d)
+		}
+		last = time.Now()
+		in := &sqs.SendMessageInput{
+			QueueUrl:          qu,
+			MessageBody:       aws.String(rec.Body),
+			MessageAttributes: rec.MessageAttributes(),
+		}
+		if g, ok := rec.SystemAttributes[sqs.MessageSystemAttributeNameMessageGroupId]; ok {
+			in.MessageGroupId = aws.String(g)
+			in.MessageDeduplicationId = aws.String("replay-" + rec.ID)
+		}
+		out, err := api.SendMessageWithContext(ctx, in)
+		if err != nil {
+			return fmt.Errorf("failed to send %s: %s", rec.ID, err)
+		}
+		fmt.Printf("replayed %s as %s\n", rec.ID, *out.MessageId)
+		return nil
+	}
+	for _, name := range files {
+		err := replayFile(name, send)
+		if err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		fmt.Printf("%d records selected\n", count)
+	} else {
+		fmt.Printf("%d messages replayed\n", count)
+	}
+	return nil
+}
+
+func replayFile(name string, fn func(*sqsnotify2.ArchiveRecord) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = sqsnotify2.ReadArchive(f, fn)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	return nil
+}
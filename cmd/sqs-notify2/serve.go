@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/koron/sqs-notify/sqsnotify2/sqstest"
+)
+
+const serveCmdUsage = `Usage: sqs-notify2 serve-local [OPTIONS]
+
+Run an in-memory SQS compatible endpoint for local development.  Queues and
+messages are lost when it stops.  Use it with "-endpoint http://{ADDR}".
+
+OPTIONS:
+`
+
+func serveMain(args []string) error {
+	var (
+		addr    string
+		baseURL string
+	)
+	fs := flag.NewFlagSet("serve-local", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), serveCmdUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&addr, "addr", ":9324", "address to listen")
+	fs.StringVar(&baseURL, "base-url", "", `prefix of queue URLs (default "http://localhost:{PORT}/000000000000/")`)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return errors.New("serve-local takes no arguments")
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if baseURL == "" {
+		_, port, err := net.SplitHostPort(l.Addr().String())
+		if err != nil {
+			return err
+		}
+		baseURL = "http://localhost:" + port + "/000000000000/"
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+
+	s := sqstest.New()
+	s.BaseURL = baseURL
+	log.Printf("serving local SQS on %s (queue URL: %s{NAME})", l.Addr(), baseURL)
+	return http.Serve(l, sqstest.NewServer(s))
+}
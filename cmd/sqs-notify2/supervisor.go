@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/koron/sqs-notify/sqsnotify2"
+)
+
+// controller controls running instances, by signals and the admin API.
+type controller interface {
+	Pause() bool
+	Resume() bool
+	Drain() bool
+	Status() *sqsnotify2.Status
+}
+
+// controlGroup is a controller of Control for each queue.  Controls which
+// are added later follow the state of the group.
+type controlGroup struct {
+	mu       sync.Mutex
+	paused   bool
+	draining bool
+	ctls     map[*sqsnotify2.Control]struct{}
+}
+
+func newControlGroup() *controlGroup {
+	return &controlGroup{ctls: map[*sqsnotify2.Control]struct{}{}}
+}
+
+func (g *controlGroup) add(c *sqsnotify2.Control) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		c.Pause()
+	}
+	if g.draining {
+		c.Drain()
+	}
+	g.ctls[c] = struct{}{}
+}
+
+func (g *controlGroup) remove(c *sqsnotify2.Control) {
+	g.mu.Lock()
+	delete(g.ctls, c)
+	g.mu.Unlock()
+}
+
+// set changes the state of the group, and applies it to all Controls.
+func (g *controlGroup) set(flag *bool, v bool, fn func(*sqsnotify2.Control) bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if *flag == v {
+		return false
+	}
+	*flag = v
+	for c := range g.ctls {
+		fn(c)
+	}
+	return true
+}
+
+func (g *controlGroup) Pause() bool {
+	return g.set(&g.paused, true, (*sqsnotify2.Control).Pause)
+}
+
+func (g *controlGroup) Resume() bool {
+	return g.set(&g.paused, false, (*sqsnotify2.Control).Resume)
+}
+
+func (g *controlGroup) Drain() bool {
+	return g.set(&g.draining, true, (*sqsnotify2.Control).Drain)
+}
+
+func (g *controlGroup) Status() *sqsnotify2.Status {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	st := &sqsnotify2.Status{State: sqsnotify2.StateRunning, InFlight: []*sqsnotify2.InFlight{}}
+	switch {
+	case g.draining:
+		st.State = sqsnotify2.StateDraining
+	case g.paused:
+		st.State = sqsnotify2.StatePaused
+	}
+	for c := range g.ctls {
+		st.InFlight = append(st.InFlight, c.Status().InFlight...)
+	}
+	sort.Slice(st.InFlight, func(i, j int) bool {
+		a, b := st.InFlight[i], st.InFlight[j]
+		if !a.ReceivedAt.Equal(b.ReceivedAt) {
+			return a.ReceivedAt.Before(b.ReceivedAt)
+		}
+		return a.ID < b.ID
+	})
+	return st
+}
+
+// queueRunner is multiplier instances of sqsnotify2 for a queue.
+type queueRunner struct {
+	ctl      *sqsnotify2.Control
+	sns      []*sqsnotify2.SQSNotify
+	draining bool
+}
+
+// supervisor runs instances of sqsnotify2 for each queue, and reloads them.
+type supervisor struct {
+	ctx        context.Context
+	cache      sqsnotify2.Cache
+	multiplier int
+	ctl        *controlGroup
+
+	mu      sync.Mutex
+	queues  map[string]*queueRunner
+	errs    []error
+	running int
+	done    chan struct{}
+}
+
+func newSupervisor(ctx context.Context, cache sqsnotify2.Cache, multiplier int) *supervisor {
+	return &supervisor{
+		ctx:        ctx,
+		cache:      cache,
+		multiplier: multiplier,
+		ctl:        newControlGroup(),
+		queues:     map[string]*queueRunner{},
+		done:       make(chan struct{}),
+	}
+}
+
+// start starts instances for a queue.  It should be called with lock.
+func (s *supervisor) start(cfg *sqsnotify2.Config, name string) {
+	qr := &queueRunner{ctl: sqsnotify2.NewControl()}
+	s.ctl.add(qr.ctl)
+	qcfg := *cfg
+	qcfg.QueueName = name
+	qcfg.Control = qr.ctl
+	var wg sync.WaitGroup
+	for i := 0; i < s.multiplier; i++ {
+		sn := sqsnotify2.New(&qcfg)
+		qr.sns = append(qr.sns, sn)
+		wg.Add(1)
+		s.running++
+		go func(id int) {
+			defer wg.Done()
+			err := sn.Run(s.ctx, s.cache)
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.running--
+			if s.running == 0 {
+				close(s.done)
+			}
+			if err == nil || isCancel(err) {
+				return
+			}
+			s.errs = append(s.errs, err)
+			log.Printf("inner process #%d for %s is terminated by error: %s", id, name, err)
+		}(i)
+	}
+	s.queues[name] = qr
+	go func() {
+		wg.Wait()
+		s.ctl.remove(qr.ctl)
+		s.mu.Lock()
+		if s.queues[name] == qr {
+			delete(s.queues, name)
+		}
+		s.mu.Unlock()
+	}()
+}
+
+// apply starts instances for new queues, reloads running ones, and drains
+// ones for removed queues.
+func (s *supervisor) apply(cfg *sqsnotify2.Config, queues []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		// all instances have been finished.
+		return
+	default:
+	}
+	keep := map[string]bool{}
+	for _, name := range queues {
+		if keep[name] {
+			continue
+		}
+		keep[name] = true
+		qr, ok := s.queues[name]
+		if !ok || qr.draining {
+			s.start(cfg, name)
+			continue
+		}
+		qcfg := *cfg
+		qcfg.QueueName = name
+		for _, sn := range qr.sns {
+			sn.Reload(&qcfg)
+		}
+	}
+	for name, qr := range s.queues {
+		if keep[name] || qr.draining {
+			continue
+		}
+		log.Printf("draining removed queue: %s", name)
+		qr.draining = true
+		qr.ctl.Drain()
+	}
+}
+
+// wait waits all instances finished, and returns the first error.
+func (s *supervisor) wait() error {
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.errs) > 0 {
+		return s.errs[0]
+	}
+	return nil
+}
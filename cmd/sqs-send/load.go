@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const defaultTemplate = `{"seq":{seq},"sent":"{sent}","pad":"{pad}"}`
+
+var (
+	loadMode     bool
+	loadRate     float64
+	loadConc     int
+	loadDuration time.Duration
+	loadSize     int
+	loadBatch    int
+	loadTemplate string
+
+	e2eFile string
+)
+
+func registerLoadFlags() {
+	flag.BoolVar(&loadMode, "load", false, "run as load generator")
+	flag.Float64Var(&loadRate, "rate", 10, "target rate of messages per second for -load (0: unlimited)")
+	flag.IntVar(&loadConc, "concurrency", 1, "num of concurrent senders for -load")
+	flag.DurationVar(&loadDuration, "duration", 10*time.Second, "duration to send for -load")
+	flag.IntVar(&loadSize, "size", 256, "size of a message body in bytes for -load")
+	flag.IntVar(&loadBatch, "batch", maxSend, "num of messages in a batch for -load (1-10)")
+	flag.StringVar(&loadTemplate, "template", defaultTemplate, "template of body for -load, {seq}, {sent} and {pad} are replaced")
+	flag.StringVar(&e2eFile, "e2e", "", "report end-to-end latency from sqs-echo output file (\"-\" for STDIN), instead of sending")
+}
+
+// bodyTemplate generates bodies of load messages.
+type bodyTemplate struct {
+	s    string
+	size int
+}
+
+func (bt *bodyTemplate) body(seq int64, sent time.Time) string {
+	s := strings.NewReplacer(
+		"{seq}", strconv.FormatInt(seq, 10),
+		"{sent}", sent.Format(time.RFC3339Nano),
+	).Replace(bt.s)
+	if !strings.Contains(s, "{pad}") {
+		return s
+	}
+	n := bt.size - (len(s) - len("{pad}"))
+	if n < 0 {
+		n = 0
+	}
+	return strings.Replace(s, "{pad}", strings.Repeat("x", n), 1)
+}
+
+// loadStats collects results of load.
+type loadStats struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	sent      int
+	failed    int
+	errors    int
+}
+
+func (st *loadStats) add(lat time.Duration, sent, failed int) {
+	st.mu.Lock()
+	st.latencies = append(st.latencies, lat)
+	st.sent += sent
+	st.failed += failed
+	st.mu.Unlock()
+}
+
+func (st *loadStats) addError() {
+	st.mu.Lock()
+	st.errors++
+	st.mu.Unlock()
+}
+
+func (st *loadStats) report(w io.Writer, elapsed time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	fmt.Fprintf(w, "duration: %s\n", elapsed.Truncate(time.Millisecond))
+	fmt.Fprintf(w, "sent:     %d messages (%.1f msg/s)\n", st.sent, float64(st.sent)/elapsed.Seconds())
+	fmt.Fprintf(w, "failed:   %d messages\n", st.failed)
+	fmt.Fprintf(w, "errors:   %d requests\n", st.errors)
+	fmt.Fprintf(w, "latency:  %s (%d requests)\n", percentiles(st.latencies), len(st.latencies))
+}
+
+// percentiles formats percentiles of durations.
+func percentiles(d []time.Duration) string {
+	if len(d) == 0 {
+		return "-"
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	p := func(q float64) time.Duration {
+		return d[int(q*float64(len(d)-1))]
+	}
+	return fmt.Sprintf("p50=%s p90=%s p99=%s max=%s", p(0.5), p(0.9), p(0.99), d[len(d)-1])
+}
+
+// loadInterval returns an interval to send a batch at the rate.  Zero means
+// unlimited, also for too high rates to make an interval.
+func loadInterval(rate float64, batch int) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) * float64(batch) / rate)
+}
+
+// runLoad sends messages at the target rate with concurrency for duration.
+func runLoad(ctx context.Context, q *sqs.SQS, qurl *string) error {
+	if loadBatch < 1 || loadBatch > maxSend {
+		return fmt.Errorf("-batch should be in 1-%d", maxSend)
+	}
+	if loadConc < 1 {
+		return fmt.Errorf("-concurrency should be greater than 0")
+	}
+	if !(loadRate >= 0) {
+		return fmt.Errorf("-rate should be 0 or more")
+	}
+	ctx, cancel := context.WithTimeout(ctx, loadDuration)
+	defer cancel()
+
+	var tick <-chan time.Time
+	if d := loadInterval(loadRate, loadBatch); d > 0 {
+		// a tick permits to send a batch.
+		tk := time.NewTicker(d)
+		defer tk.Stop()
+		tick = tk.C
+	}
+
+	var (
+		seq   int64
+		st    = &loadStats{}
+		bt    = &bodyTemplate{s: loadTemplate, size: loadSize}
+		wg    sync.WaitGroup
+		start = time.Now()
+	)
+	for i := 0; i < loadConc; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if tick != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-tick:
+					}
+				} else if ctx.Err() != nil {
+					return
+				}
+				entries := make([]*sqs.SendMessageBatchRequestEntry, 0, loadBatch)
+				now := time.Now()
+				for j := 0; j < loadBatch; j++ {
+					entries = append(entries, &sqs.SendMessageBatchRequestEntry{
+						Id:          aws.String(strconv.Itoa(j)),
+						MessageBody: aws.String(bt.body(atomic.AddInt64(&seq, 1), now)),
+					})
+				}
+				out, err := q.SendMessageBatchWithContext(ctx, &sqs.SendMessageBatchInput{
+					Entries:  entries,
+					QueueUrl: qurl,
+				})
+				lat := time.Since(now)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					log.Printf("failed to send: %s", err)
+					st.addError()
+					continue
+				}
+				st.add(lat, len(out.Successful), len(out.Failed))
+			}
+		}()
+	}
+	wg.Wait()
+	st.report(os.Stdout, time.Since(start))
+	return nil
+}
+
+// echoLine matches a line of sqs-echo output.
+var echoLine = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?) \(\d+\) (".*")$`)
+
+// reportE2E reports end-to-end latency from sqs-echo output, both of text
+// and JSON.  Latency is difference between "sent" in body and the time of the
+// line.
+func reportE2E(name string) error {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var (
+		lats    []time.Duration
+		skipped int
+	)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for sc.Scan() {
+		recv, body, ok := parseEchoLine(sc.Text())
+		if !ok {
+			skipped++
+			continue
+		}
+		var v struct {
+			Sent time.Time `json:"sent"`
+		}
+		if err := json.Unmarshal([]byte(body), &v); err != nil || v.Sent.IsZero() {
+			skipped++
+			continue
+		}
+		lats = append(lats, recv.Sub(v.Sent))
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	fmt.Printf("messages: %d (skipped %d lines)\n", len(lats), skipped)
+	fmt.Printf("latency:  %s\n", percentiles(lats))
+	return nil
+}
+
+func parseEchoLine(s string) (time.Time, string, bool) {
+	if strings.HasPrefix(s, "{") {
+		// JSON output of sqs-echo.
+		var v struct {
+			Time time.Time `json:"time"`
+			Body *string   `json:"body"`
+		}
+		if err := json.Unmarshal([]byte(s), &v); err != nil || v.Body == nil {
+			return time.Time{}, "", false
+		}
+		return v.Time, *v.Body, true
+	}
+	m := echoLine.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}, "", false
+	}
+	t, err := time.ParseInLocation("2006/01/02 15:04:05", m[1], time.Local)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	body, err := strconv.Unquote(m[2])
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return t, body, true
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLoadInterval(t *testing.T) {
+	for _, c := range []struct {
+		rate  float64
+		batch int
+		want  time.Duration
+	}{
+		{0, 10, 0},
+		{10, 1, 100 * time.Millisecond},
+		{10, 10, time.Second},
+		{0.5, 1, 2 * time.Second},
+		// too high rates are unlimited.
+		{1e10, 1, 0},
+		{math.Inf(1), 10, 0},
+	} {
+		if got := loadInterval(c.rate, c.batch); got != c.want {
+			t.Errorf("unexpected interval for rate=%g batch=%d: got=%s want=%s", c.rate, c.batch, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/koron/sqs-notify/sqsnotify2"
+)
+
+// maxLineSize is max size of a line in JSON Lines, which is larger than max
+// size of a SQS message.
+const maxLineSize = 1024 * 1024
+
+// record is a message to send.
+type record struct {
+	Body                   string                     `json:"body"`
+	Attributes             map[string]json.RawMessage `json:"attributes,omitempty"`
+	DelaySeconds           *int64                     `json:"delaySeconds,omitempty"`
+	MessageGroupID         string                     `json:"messageGroupId,omitempty"`
+	MessageDeduplicationID string                     `json:"messageDeduplicationId,omitempty"`
+
+	// src describes where the record comes from.
+	src   string
+	attrs map[string]*sqs.MessageAttributeValue
+}
+
+func (r *record) parseAttributes() error {
+	if len(r.Attributes) == 0 {
+		return nil
+	}
+	r.attrs = make(map[string]*sqs.MessageAttributeValue, len(r.Attributes))
+	for k, raw := range r.Attributes {
+		// a JSON string is accepted as String type.
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			r.attrs[k] = stringAttr(s)
+			continue
+		}
+		// otherwise same as attributes in archive of sqs-notify2.
+		var v sqsnotify2.AttributeValue
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return fmt.Errorf("invalid attribute %q: %s", k, err)
+		}
+		if v.DataType == "" {
+			return fmt.Errorf("no dataType for attribute %q", k)
+		}
+		r.attrs[k] = v.MessageAttributeValue()
+	}
+	return nil
+}
+
+func stringAttr(s string) *sqs.MessageAttributeValue {
+	return &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(s),
+	}
+}
+
+// applyDefaults applies options for all messages.
+func (r *record) applyDefaults() {
+	for k, v := range attrs {
+		if _, ok := r.attrs[k]; ok {
+			continue
+		}
+		if r.attrs == nil {
+			r.attrs = map[string]*sqs.MessageAttributeValue{}
+		}
+		r.attrs[k] = stringAttr(v)
+	}
+	if r.DelaySeconds == nil && delay >= 0 {
+		r.DelaySeconds = aws.Int64(delay)
+	}
+	if r.MessageGroupID == "" {
+		r.MessageGroupID = groupID
+	}
+}
+
+// size returns approximate size of payload.
+func (r *record) size() int {
+	n := len(r.Body)
+	for k, v := range r.attrs {
+		n += len(k) + len(aws.StringValue(v.DataType)) + len(aws.StringValue(v.StringValue)) + len(v.BinaryValue)
+	}
+	return n
+}
+
+func (r *record) label() string {
+	if r.src != "" {
+		return r.src
+	}
+	return fmt.Sprintf("%q", r.Body)
+}
+
+func (r *record) entry(id string) *sqs.SendMessageBatchRequestEntry {
+	e := &sqs.SendMessageBatchRequestEntry{
+		Id:                aws.String(id),
+		MessageBody:       aws.String(r.Body),
+		MessageAttributes: r.attrs,
+		DelaySeconds:      r.DelaySeconds,
+	}
+	if r.MessageGroupID != "" {
+		e.MessageGroupId = aws.String(r.MessageGroupID)
+	}
+	if r.MessageDeduplicationID != "" {
+		e.MessageDeduplicationId = aws.String(r.MessageDeduplicationID)
+	}
+	return e
+}
+
+// readRecords reads records from STDIN, files or generates them by options.
+func readRecords(files []string, fn func(*record) error) error {
+	if useStdin && len(files) > 0 {
+		return errors.New("-stdin can't be used with FILEs")
+	}
+	if useJSONL {
+		if len(files) == 0 {
+			return readJSONL(os.Stdin, "stdin", fn)
+		}
+		for _, name := range files {
+			err := readJSONLFile(name, fn)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if useStdin {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		return fn(&record{Body: string(b), src: "stdin"})
+	}
+	if len(files) > 0 {
+		for _, name := range files {
+			b, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			err = fn(&record{Body: string(b), src: name})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := 0; i < msgnum; i++ {
+		err := fn(&record{Body: fmt.Sprintf("%s%d", prefix, i+1)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readJSONLFile(name string, fn func(*record) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readJSONL(f, name, fn)
+}
+
+func readJSONL(r io.Reader, name string, fn func(*record) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		rec := &record{}
+		if err := json.Unmarshal(line, rec); err != nil {
+			return fmt.Errorf("%s:%d: %s", name, n, err)
+		}
+		if err := rec.parseAttributes(); err != nil {
+			return fmt.Errorf("%s:%d: %s", name, n, err)
+		}
+		rec.src = fmt.Sprintf("%s:%d", name, n)
+		err := fn(rec)
+		if err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
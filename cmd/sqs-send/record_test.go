@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestParseAttributes(t *testing.T) {
+	rec := &record{}
+	err := json.Unmarshal([]byte(`{"body":"hello","attributes":{`+
+		`"type":"greeting",`+
+		`"n":{"dataType":"Number","stringValue":"1"},`+
+		`"bin":{"dataType":"Binary","binaryValue":"AQI="},`+
+		`"custom":{"dataType":"String.custom","stringValue":"x"}}}`), rec)
+	if err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	if err := rec.parseAttributes(); err != nil {
+		t.Fatalf("failed to parse attributes: %v", err)
+	}
+	if len(rec.attrs) != 4 {
+		t.Fatalf("unexpected attributes: %v", rec.attrs)
+	}
+	for _, d := range []struct {
+		name, dataType, value string
+	}{
+		{"type", "String", "greeting"},
+		{"n", "Number", "1"},
+		{"custom", "String.custom", "x"},
+	} {
+		v := rec.attrs[d.name]
+		if aws.StringValue(v.DataType) != d.dataType || aws.StringValue(v.StringValue) != d.value || v.BinaryValue != nil {
+			t.Errorf("unexpected attribute %s: %v", d.name, v)
+		}
+	}
+	if v := rec.attrs["bin"]; aws.StringValue(v.DataType) != "Binary" || string(v.BinaryValue) != "\x01\x02" || v.StringValue != nil {
+		t.Errorf("unexpected attribute bin: %v", v)
+	}
+
+	// no attributes.
+	rec = &record{Body: "hello"}
+	if err := rec.parseAttributes(); err != nil || rec.attrs != nil {
+		t.Errorf("unexpected result without attributes: %v %v", rec.attrs, err)
+	}
+}
+
+func TestParseAttributesError(t *testing.T) {
+	for _, s := range []string{
+		`{"a":1}`,
+		`{"a":{"stringValue":"x"}}`,
+		`{"a":{"dataType":"Binary","binaryValue":"not base64"}}`,
+	} {
+		rec := &record{}
+		if err := json.Unmarshal([]byte(`{"body":"x","attributes":`+s+`}`), rec); err != nil {
+			t.Fatalf("failed to unmarshal %s: %v", s, err)
+		}
+		if err := rec.parseAttributes(); err == nil {
+			t.Errorf("parse should fail: %s", s)
+		}
+	}
+}
+
+func TestReadRecordsStdinWithFiles(t *testing.T) {
+	defer func(s, j bool) { useStdin, useJSONL = s, j }(useStdin, useJSONL)
+	useStdin = true
+	for _, j := range []bool{false, true} {
+		useJSONL = j
+		err := readRecords([]string{"a.jsonl"}, func(*record) error {
+			t.Fatal("no records should be read")
+			return nil
+		})
+		if err == nil {
+			t.Errorf("-stdin with FILEs should fail (jsonl=%t)", j)
+		}
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -14,7 +16,11 @@ import (
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
-const maxSend = 10
+const (
+	maxSend = 10
+	// maxBatchSize is max total size of payloads in a SendMessageBatch.
+	maxBatchSize = 256 * 1024
+)
 
 var (
 	endpoint string
@@ -23,13 +29,56 @@ var (
 
 	msgnum int
 	prefix string
+
+	useStdin bool
+	useJSONL bool
+	attrs    = attrsFlag{}
+	delay    int64
+	groupID  string
 )
 
-func min(a, b int) int {
-	if a < b {
-		return a
+// attrsFlag is a repeatable KEY=VALUE flag for message attributes.
+type attrsFlag map[string]string
+
+func (f attrsFlag) String() string {
+	var s []string
+	for k, v := range f {
+		s = append(s, k+"="+v)
 	}
-	return b
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (f attrsFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("attribute should be KEY=VALUE: %s", s)
+	}
+	f[k] = v
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: sqs-send -q {queue name} [OPTIONS] [FILE...]
+
+Send messages to SQS.  Bodies of messages are chosen by OPTIONS and FILEs:
+
+  (no FILEs)      generate bodies "{prefix}{N}" (-p and -n)
+  -stdin          send whole STDIN as a message
+  FILE...         send each file as a message
+  -jsonl          read JSON Lines records from FILEs or STDIN, a record is:
+                  {"body":"...", "attributes":{"key":"value", ...},
+                   "delaySeconds":0, "messageGroupId":"...",
+                   "messageDeduplicationId":"..."}
+
+  -load           send generated bodies at -rate with -concurrency for
+                  -duration, and report throughput, errors and latency
+  -e2e FILE       report end-to-end latency from output of sqs-echo, which
+                  received messages sent by -load
+
+OPTIONS:
+`)
+	flag.PrintDefaults()
 }
 
 func main() {
@@ -38,7 +87,21 @@ func main() {
 	flag.StringVar(&qname, "q", "", "queue name to send")
 	flag.IntVar(&msgnum, "n", 1, "number of message to send")
 	flag.StringVar(&prefix, "p", "", "prefix for messages")
+	flag.BoolVar(&useStdin, "stdin", false, "read a message (or JSON Lines with -jsonl) from STDIN")
+	flag.BoolVar(&useJSONL, "jsonl", false, "read JSON Lines records from FILEs or STDIN")
+	flag.Var(attrs, "attr", "message attribute in KEY=VALUE for all messages (repeatable)")
+	flag.Int64Var(&delay, "delay", -1, "DelaySeconds for all messages (-1: use queue default)")
+	flag.StringVar(&groupID, "group-id", "", "MessageGroupId for all messages (FIFO queue)")
+	registerLoadFlags()
+	flag.Usage = usage
 	flag.Parse()
+	if e2eFile != "" {
+		err := reportE2E(e2eFile)
+		if err != nil {
+			log.Fatalf("fail to report: %s", err)
+		}
+		return
+	}
 	if qname == "" {
 		flag.Usage()
 		fmt.Fprintf(os.Stderr, "need to specify queue name")
@@ -47,6 +110,7 @@ func main() {
 	err := sendMessages(context.Background())
 	if err != nil {
 		log.Printf("fail to send: %s", err)
+		os.Exit(1)
 	}
 }
 
@@ -77,24 +141,6 @@ func isQueueDoesNotExist(err0 error) bool {
 	return err.Code() == sqs.ErrCodeQueueDoesNotExist
 }
 
-func sendQueue(ctx context.Context, q *sqs.SQS, qurl *string, msgs []string) error {
-	entries := make([]*sqs.SendMessageBatchRequestEntry, 0, len(msgs))
-	for i, m := range msgs {
-		entries = append(entries, &sqs.SendMessageBatchRequestEntry{
-			Id:          aws.String(strconv.Itoa(i)),
-			MessageBody: aws.String(m),
-		})
-	}
-	_, err := q.SendMessageBatchWithContext(ctx, &sqs.SendMessageBatchInput{
-		Entries:  entries,
-		QueueUrl: qurl,
-	})
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func newSQS() (*sqs.SQS, error) {
 	cfg := aws.NewConfig()
 	if endpoint != "" {
@@ -110,6 +156,67 @@ func newSQS() (*sqs.SQS, error) {
 	return sqs.New(ses), nil
 }
 
+// sender sends records in batches.
+type sender struct {
+	q    *sqs.SQS
+	qurl *string
+
+	recs []*record
+	size int
+
+	sent   int
+	failed int
+}
+
+func (s *sender) add(ctx context.Context, r *record) error {
+	if len(s.recs) >= maxSend || (len(s.recs) > 0 && s.size+r.size() > maxBatchSize) {
+		err := s.flush(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	s.recs = append(s.recs, r)
+	s.size += r.size()
+	return nil
+}
+
+func (s *sender) flush(ctx context.Context) error {
+	if len(s.recs) == 0 {
+		return nil
+	}
+	entries := make([]*sqs.SendMessageBatchRequestEntry, 0, len(s.recs))
+	for i, r := range s.recs {
+		entries = append(entries, r.entry(strconv.Itoa(i)))
+	}
+	out, err := s.q.SendMessageBatchWithContext(ctx, &sqs.SendMessageBatchInput{
+		Entries:  entries,
+		QueueUrl: s.qurl,
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range out.Successful {
+		r := s.recs[atoi(e.Id)]
+		log.Printf("sent %s id=%s", r.label(), *e.MessageId)
+		s.sent++
+	}
+	for _, e := range out.Failed {
+		r := s.recs[atoi(e.Id)]
+		log.Printf("failed %s code=%s sender_fault=%t message=%s",
+			r.label(), aws.StringValue(e.Code), aws.BoolValue(e.SenderFault),
+			aws.StringValue(e.Message))
+		s.failed++
+	}
+	s.recs = s.recs[:0]
+	s.size = 0
+	return nil
+}
+
+func atoi(s *string) int {
+	n, _ := strconv.Atoi(aws.StringValue(s))
+	return n
+}
+
 func sendMessages(ctx context.Context) error {
 	q, err := newSQS()
 	if err != nil {
@@ -121,21 +228,24 @@ func sendMessages(ctx context.Context) error {
 		return err
 	}
 
-	msgs := make([]string, 0, maxSend)
-	for i := 0; i < msgnum; {
-		msgs = msgs[:0]
-		n := min(msgnum-i, maxSend)
-		for j := i; j < i+n; j++ {
-			msgs = append(msgs, fmt.Sprintf("%s%d", prefix, j+1))
-		}
-		err := sendQueue(ctx, q, qurl, msgs)
-		if err != nil {
-			return err
-		}
-		for _, m := range msgs {
-			log.Printf("sent %q", m)
-		}
-		i += n
+	if loadMode {
+		return runLoad(ctx, q, qurl)
+	}
+
+	s := &sender{q: q, qurl: qurl}
+	err = readRecords(flag.Args(), func(r *record) error {
+		r.applyDefaults()
+		return s.add(ctx, r)
+	})
+	if err != nil {
+		return err
+	}
+	err = s.flush(ctx)
+	if err != nil {
+		return err
+	}
+	if s.failed > 0 {
+		return fmt.Errorf("%d of %d messages failed", s.failed, s.sent+s.failed)
 	}
 	return nil
 }
@@ -0,0 +1,254 @@
+package sqsnotify2
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/koron/sqs-notify/sqsnotify2/stage"
+)
+
+// outcomes of messages in archive.
+const (
+	// OutcomeSucceeded means "the command succeeded"
+	OutcomeSucceeded = "succeeded"
+	// OutcomeFailed means "the command failed"
+	OutcomeFailed = "failed"
+	// OutcomeError means "the command was not executed by an error"
+	OutcomeError = "error"
+	// OutcomeSkipped means "the message was skipped as a duplicate"
+	OutcomeSkipped = "skipped"
+)
+
+// maxArchiveLine is max size of a line in archive.
+const maxArchiveLine = 1024 * 1024
+
+// ArchiveRecord is a record of a received message in archive.  Body and
+// attributes are compatible with JSON Lines of sqs-send.
+type ArchiveRecord struct {
+	ID               string                     `json:"id"`
+	Queue            string                     `json:"queue"`
+	ReceivedAt       time.Time                  `json:"receivedAt"`
+	SystemAttributes map[string]string          `json:"systemAttributes,omitempty"`
+	Attributes       map[string]*AttributeValue `json:"attributes,omitempty"`
+	Body             string                     `json:"body"`
+
+	Outcome string      `json:"outcome"`
+	Stage   stage.Stage `json:"stage"`
+	Error   string      `json:"error,omitempty"`
+	Skip    string      `json:"skip,omitempty"`
+	Deleted bool        `json:"deleted"`
+}
+
+// AttributeValue is a message attribute in JSON.  It is used by archive,
+// co-process and JSON Lines of sqs-send.
+type AttributeValue struct {
+	DataType    string `json:"dataType"`
+	StringValue string `json:"stringValue,omitempty"`
+	BinaryValue []byte `json:"binaryValue,omitempty"`
+}
+
+// NewAttributeValue creates an AttributeValue from a message attribute.
+func NewAttributeValue(v *sqs.MessageAttributeValue) *AttributeValue {
+	return &AttributeValue{
+		DataType:    aws.StringValue(v.DataType),
+		StringValue: aws.StringValue(v.StringValue),
+		BinaryValue: v.BinaryValue,
+	}
+}
+
+// MessageAttributeValue returns a message attribute to send.
+func (v *AttributeValue) MessageAttributeValue() *sqs.MessageAttributeValue {
+	mav := &sqs.MessageAttributeValue{DataType: aws.String(v.DataType)}
+	if v.BinaryValue != nil {
+		mav.BinaryValue = v.BinaryValue
+	} else {
+		mav.StringValue = aws.String(v.StringValue)
+	}
+	return mav
+}
+
+// MessageAttributes returns message attributes to send the record again.
+func (rec *ArchiveRecord) MessageAttributes() map[string]*sqs.MessageAttributeValue {
+	if len(rec.Attributes) == 0 {
+		return nil
+	}
+	m := make(map[string]*sqs.MessageAttributeValue, len(rec.Attributes))
+	for k, v := range rec.Attributes {
+		m[k] = v.MessageAttributeValue()
+	}
+	return m
+}
+
+func newArchiveRecord(queue string, r *result, deleted bool) *ArchiveRecord {
+	rec := &ArchiveRecord{
+		ID:         aws.StringValue(r.msg.MessageId),
+		Queue:      queue,
+		ReceivedAt: r.recv,
+		Body:       aws.StringValue(r.msg.Body),
+		Stage:      r.stg,
+		Skip:       r.skip,
+		Deleted:    deleted,
+	}
+	switch {
+	case r.skip != "":
+		rec.Outcome = OutcomeSkipped
+	case r.err == nil:
+		rec.Outcome = OutcomeSucceeded
+	case r.stg == stage.Exec:
+		rec.Outcome = OutcomeFailed
+	default:
+		rec.Outcome = OutcomeError
+	}
+	if r.err != nil {
+		rec.Error = r.err.Error()
+	}
+	if len(r.msg.Attributes) > 0 {
+		rec.SystemAttributes = aws.StringValueMap(r.msg.Attributes)
+	}
+	for k, v := range r.msg.MessageAttributes {
+		if rec.Attributes == nil {
+			rec.Attributes = map[string]*AttributeValue{}
+		}
+		rec.Attributes[k] = NewAttributeValue(v)
+	}
+	return rec
+}
+
+// Archive writes received messages into JSON Lines files in a directory,
+// rotated daily.  Names of files are "archive-{YYYY-MM-DD}.jsonl".
+type Archive struct {
+	dir string
+	now func() time.Time
+
+	mu   sync.Mutex
+	f    *os.File
+	date string
+}
+
+// NewArchive creates an Archive which writes files into dir.
+func NewArchive(dir string) (*Archive, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{dir: dir, now: time.Now}, nil
+}
+
+// ArchiveName returns name of archive file for a date.
+func ArchiveName(t time.Time) string {
+	return "archive-" + t.Format("2006-01-02") + ".jsonl"
+}
+
+// Write writes a record to the file of today.
+func (a *Archive) Write(rec *ArchiveRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.rotate(); err != nil {
+		return err
+	}
+	_, err = a.f.Write(b)
+	return err
+}
+
+// rotate opens a file of today if needed.  It should be called with lock.
+func (a *Archive) rotate() error {
+	now := a.now()
+	date := now.Format("2006-01-02")
+	if a.f != nil && a.date == date {
+		return nil
+	}
+	f, err := os.OpenFile(filepath.Join(a.dir, ArchiveName(now)), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if a.f != nil {
+		a.f.Close()
+	}
+	a.f, a.date = f, date
+	return nil
+}
+
+// Close closes the current file.
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return nil
+	}
+	err := a.f.Close()
+	a.f = nil
+	return err
+}
+
+// ReadArchive reads records from archive.
+func ReadArchive(r io.Reader, fn func(*ArchiveRecord) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), maxArchiveLine)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		rec := &ArchiveRecord{}
+		if err := json.Unmarshal(line, rec); err != nil {
+			return fmt.Errorf("line %d: %s", n, err)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// archiveResults writes results of a round to archive, after messages in
+// deleted have been deleted.  err is an error of the deletion, messages are
+// recorded as not deleted when they failed to be deleted.  Messages deleted
+// before execution are recorded as deleted too.
+func (sn *SQSNotify) archiveResults(deleted []*result, err error) {
+	if sn.Archive == nil {
+		return
+	}
+	ids := make(map[string]bool, len(deleted))
+	var failed []*sqs.BatchResultErrorEntry
+	switch f := err.(type) {
+	case nil:
+	case *deleteFailure:
+		failed = f.failed
+	default:
+		// nothing was deleted.
+		deleted = nil
+	}
+	for _, r := range deleted {
+		ids[*r.msg.MessageId] = true
+	}
+	for _, e := range failed {
+		delete(ids, aws.StringValue(e.Id))
+	}
+	for _, r := range sn.results {
+		sn.archive(r, r.removed || ids[*r.msg.MessageId])
+	}
+}
+
+// archive writes a result to archive, if it is enabled.
+func (sn *SQSNotify) archive(r *result, deleted bool) {
+	if sn.Archive == nil {
+		return
+	}
+	err := sn.Archive.Write(newArchiveRecord(sn.QueueName, r, deleted))
+	if err != nil {
+		sn.log().Printf("failed to archive message: id=%s err=%s", *r.msg.MessageId, err)
+	}
+}
@@ -0,0 +1,164 @@
+package sqsnotify2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/koron/sqs-notify/sqsnotify2/sqstest"
+	"github.com/koron/sqs-notify/sqsnotify2/stage"
+)
+
+func readArchiveFile(t *testing.T, name string) map[string]*ArchiveRecord {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer f.Close()
+	recs := map[string]*ArchiveRecord{}
+	err = ReadArchive(f, func(rec *ArchiveRecord) error {
+		recs[rec.Body] = rec
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+	return recs
+}
+
+func TestRunArchive(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewArchive(dir)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	api := sqstest.New()
+	sendBodies(t, api, "ok", "fail")
+	sn := newTestSQSNotify(t, IgnoreFailure)
+	sn.Archive = a
+	runUntil(t, sn, api, func() bool {
+		return api.Stats("test") == sqstest.Stats{}
+	})
+	a.Close()
+
+	recs := readArchiveFile(t, filepath.Join(dir, ArchiveName(time.Now())))
+	if len(recs) != 2 {
+		t.Fatalf("unexpected number of records: %d", len(recs))
+	}
+	ok, fail := recs["ok"], recs["fail"]
+	if ok.Outcome != OutcomeSucceeded || ok.Stage != stage.Done || !ok.Deleted {
+		t.Errorf("unexpected record for succeeded: %+v", ok)
+	}
+	if fail.Outcome != OutcomeFailed || fail.Stage != stage.Exec || fail.Error == "" || !fail.Deleted {
+		t.Errorf("unexpected record for failed: %+v", fail)
+	}
+	if ok.Queue != "test" || ok.ReceivedAt.IsZero() || ok.SystemAttributes["ApproximateReceiveCount"] != "1" {
+		t.Errorf("unexpected record: %+v", ok)
+	}
+}
+
+func TestRunArchiveDeleteFailure(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewArchive(dir)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	api := sqstest.New()
+	api.FailDelete = func(_, id string) bool {
+		return id == "00000000-0000-0000-0000-000000000002"
+	}
+	sendBodies(t, api, "ok", "ng")
+	sn := newTestSQSNotify(t, Succeed)
+	sn.Archive = a
+	err = runError(t, sn, api)
+	if _, ok := err.(*deleteFailure); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a.Close()
+
+	recs := readArchiveFile(t, filepath.Join(dir, ArchiveName(time.Now())))
+	if len(recs) != 2 {
+		t.Fatalf("unexpected number of records: %d", len(recs))
+	}
+	if ok := recs["ok"]; !ok.Deleted {
+		t.Errorf("deleted message should be recorded as deleted: %+v", ok)
+	}
+	if ng := recs["ng"]; ng.Outcome != OutcomeSucceeded || ng.Deleted {
+		t.Errorf("message failed to delete should be recorded as not deleted: %+v", ng)
+	}
+}
+
+func TestRunArchiveBeforeExecution(t *testing.T) {
+	for _, d := range []struct {
+		name   string
+		action FilterAction
+		queue  string
+	}{
+		{"leave", FilterLeave, ""},
+		{"move_failure", FilterMove, "missing"},
+	} {
+		t.Run(d.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a, err := NewArchive(dir)
+			if err != nil {
+				t.Fatalf("failed to create archive: %v", err)
+			}
+			api := sqstest.New()
+			sendBodies(t, api)
+			sendTyped(t, api, "invoice", "invoice")
+			sendTyped(t, api, "order", "order")
+			sn := newTestSQSNotify(t, BeforeExecution)
+			sn.Archive = a
+			sn.Filter = `attr.type == "invoice"`
+			sn.FilterAction = d.action
+			sn.FilterQueue = d.queue
+			sn.FilterVisibility = time.Hour
+			runUntil(t, sn, api, func() bool {
+				return stageOf(t, sn.cache, "00000000-0000-0000-0000-000000000001") == stage.Done &&
+					api.Stats("test") == sqstest.Stats{InFlight: 1}
+			})
+			a.Close()
+
+			recs := readArchiveFile(t, filepath.Join(dir, ArchiveName(time.Now())))
+			if len(recs) != 2 {
+				t.Fatalf("unexpected number of records: %d", len(recs))
+			}
+			if inv := recs["invoice"]; inv.Outcome != OutcomeSucceeded || !inv.Deleted {
+				t.Errorf("unexpected record for matched: %+v", inv)
+			}
+			// the message which doesn't match is left in the queue.
+			if ord := recs["order"]; ord.Deleted {
+				t.Errorf("message left in the queue should be recorded as not deleted: %+v", ord)
+			}
+		})
+	}
+}
+
+func TestArchiveRotate(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewArchive(dir)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	now := time.Date(2024, 1, 1, 23, 59, 0, 0, time.Local)
+	a.now = func() time.Time { return now }
+	for _, body := range []string{"foo", "bar"} {
+		if err := a.Write(&ArchiveRecord{Body: body}); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		now = now.Add(time.Minute)
+	}
+	a.Close()
+
+	for _, d := range []struct{ name, body string }{
+		{"archive-2024-01-01.jsonl", "foo"},
+		{"archive-2024-01-02.jsonl", "bar"},
+	} {
+		recs := readArchiveFile(t, filepath.Join(dir, d.name))
+		if len(recs) != 1 || recs[d.body] == nil {
+			t.Errorf("unexpected records in %s: %v", d.name, recs)
+		}
+	}
+}
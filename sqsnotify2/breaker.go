@@ -0,0 +1,215 @@
+package sqsnotify2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker configures a circuit breaker, which stops receiving messages
+// when commands fail repeatedly.  After Cooldown, it receives one message as
+// a probe, and resumes when the probe succeeded.  It is enabled by Failures
+// or Rate.
+type CircuitBreaker struct {
+	// Failures opens the breaker after the number of consecutive failures.
+	Failures int
+	// Rate opens the breaker when rate of failures (0 to 1) within Window
+	// exceeds it, after MinRequests or more executions.  Zero Window means
+	// "last MinRequests executions".
+	Rate        float64
+	Window      time.Duration
+	MinRequests int
+	// Cooldown is a period to wait before a probe.
+	Cooldown time.Duration
+	// TempFailCode is an exit code of commands, which opens the breaker
+	// immediately.  Zero means "none".  It doesn't enable the breaker by
+	// itself.
+	TempFailCode int
+}
+
+func (cb CircuitBreaker) enabled() bool {
+	return cb.Failures > 0 || cb.Rate > 0
+}
+
+// EXTempFail is EX_TEMPFAIL of sysexits.h, a default of TempFailCode.
+const EXTempFail = 75
+
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type breakerSample struct {
+	t      time.Time
+	failed bool
+}
+
+// breaker is a circuit breaker.  A nil breaker is always closed.
+type breaker struct {
+	CircuitBreaker
+	log func(string, ...interface{})
+	now func() time.Time
+
+	mu          sync.Mutex
+	state       breakerState
+	consecutive int
+	samples     []breakerSample
+	openedAt    time.Time
+}
+
+func newBreaker(cb CircuitBreaker, log func(string, ...interface{})) *breaker {
+	if !cb.enabled() {
+		return nil
+	}
+	return &breaker{
+		CircuitBreaker: cb,
+		log:            log,
+		now:            time.Now,
+	}
+}
+
+func (b *breaker) currentState() breakerState {
+	if b == nil {
+		return breakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// wait waits while the breaker is open.  It returns true when a probe
+// should be sent.
+func (b *breaker) wait(ctx context.Context) (bool, error) {
+	if b == nil {
+		return false, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen {
+		d := b.openedAt.Add(b.Cooldown).Sub(b.now())
+		if d > 0 {
+			b.mu.Unlock()
+			tm := time.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				tm.Stop()
+				b.mu.Lock()
+				return false, ctx.Err()
+			case <-tm.C:
+			}
+			b.mu.Lock()
+		}
+		b.state = breakerHalfOpen
+		b.log("circuit breaker is half-open, sending a probe")
+	}
+	return b.state == breakerHalfOpen, nil
+}
+
+// blocks returns true when a received message shouldn't be executed.
+func (b *breaker) blocks() bool {
+	return b.currentState() == breakerOpen
+}
+
+// record records a result of a command.
+func (b *breaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	failed := err != nil
+	if b.state == breakerHalfOpen {
+		if failed {
+			b.open(now, fmt.Sprintf("probe failed: %s", err))
+			return
+		}
+		b.state = breakerClosed
+		b.consecutive = 0
+		b.samples = b.samples[:0]
+		b.log("circuit breaker is closed, probe succeeded")
+		return
+	}
+
+	if failed {
+		b.consecutive++
+	} else {
+		b.consecutive = 0
+	}
+	if b.Rate > 0 {
+		b.samples = append(b.samples, breakerSample{t: now, failed: failed})
+		b.prune(now)
+	}
+	if b.state == breakerOpen {
+		return
+	}
+
+	switch {
+	case b.TempFailCode != 0 && exitCode(err) == b.TempFailCode:
+		b.open(now, fmt.Sprintf("command exited with %d", b.TempFailCode))
+	case b.Failures > 0 && b.consecutive >= b.Failures:
+		b.open(now, fmt.Sprintf("%d consecutive failures", b.consecutive))
+	case b.Rate > 0 && len(b.samples) >= b.MinRequests:
+		n := 0
+		for _, s := range b.samples {
+			if s.failed {
+				n++
+			}
+		}
+		if r := float64(n) / float64(len(b.samples)); r > b.Rate {
+			b.open(now, fmt.Sprintf("%d of %d failed", n, len(b.samples)))
+		}
+	}
+}
+
+func (b *breaker) open(now time.Time, reason string) {
+	b.state = breakerOpen
+	b.openedAt = now
+	b.log("circuit breaker is open for %s: %s", b.Cooldown, reason)
+}
+
+// prune removes samples out of the window.  Without the window, last
+// MinRequests samples are kept.
+func (b *breaker) prune(now time.Time) {
+	i := 0
+	if b.Window <= 0 {
+		i = len(b.samples) - b.MinRequests
+		if i < 0 {
+			i = 0
+		}
+	}
+	for i < len(b.samples) && b.Window > 0 && now.Sub(b.samples[i].t) > b.Window {
+		i++
+	}
+	b.samples = b.samples[i:]
+}
+
+// exitCode returns an exit code of a command in err, or -1.
+func exitCode(err error) int {
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	return -1
+}
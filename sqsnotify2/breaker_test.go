@@ -0,0 +1,168 @@
+package sqsnotify2
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/koron/sqs-notify/sqsnotify2/sqstest"
+	"github.com/koron/sqs-notify/sqsnotify2/stage"
+)
+
+func newTestBreaker(t *testing.T, cb CircuitBreaker) (*breaker, *time.Time) {
+	t.Helper()
+	b := newBreaker(cb, t.Logf)
+	if b == nil {
+		t.Fatal("breaker should be enabled")
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(NewConfig().Breaker, t.Logf)
+	if b != nil {
+		t.Fatal("breaker should be disabled by default")
+	}
+	b.record(errors.New("failed"))
+	if b.blocks() {
+		t.Error("nil breaker should not block")
+	}
+	if probe, err := b.wait(context.Background()); probe || err != nil {
+		t.Errorf("nil breaker should not wait: %t %v", probe, err)
+	}
+}
+
+func TestBreakerFailures(t *testing.T) {
+	b, _ := newTestBreaker(t, CircuitBreaker{Failures: 3})
+	failed := errors.New("failed")
+	b.record(failed)
+	b.record(failed)
+	b.record(nil)
+	b.record(failed)
+	b.record(failed)
+	if b.blocks() {
+		t.Fatal("should be closed after a success")
+	}
+	b.record(failed)
+	if !b.blocks() {
+		t.Fatal("should be open after 3 consecutive failures")
+	}
+}
+
+func TestBreakerRate(t *testing.T) {
+	b, now := newTestBreaker(t, CircuitBreaker{Rate: 0.5, Window: time.Minute, MinRequests: 4})
+	failed := errors.New("failed")
+	// old failures are out of the window.
+	b.record(failed)
+	b.record(failed)
+	*now = now.Add(2 * time.Minute)
+	b.record(failed)
+	b.record(nil)
+	b.record(failed)
+	if b.blocks() {
+		t.Fatal("should be closed with less samples")
+	}
+	b.record(nil)
+	if b.blocks() {
+		t.Fatal("should be closed with 50% failures")
+	}
+	b.record(failed)
+	if !b.blocks() {
+		t.Fatal("should be open with 60% failures")
+	}
+}
+
+func TestBreakerTempFail(t *testing.T) {
+	t.Setenv("SQSNOTIFY2_HELPER_PROCESS", "1")
+	sn := newTestSQSNotify(t, Succeed)
+	err := execTestCmd(t, sn, "tempfail")
+	b, _ := newTestBreaker(t, CircuitBreaker{Failures: 10, TempFailCode: EXTempFail})
+	b.record(err)
+	if !b.blocks() {
+		t.Fatalf("should be open by EX_TEMPFAIL: %v", err)
+	}
+}
+
+func TestBreakerProbe(t *testing.T) {
+	b, _ := newTestBreaker(t, CircuitBreaker{Failures: 1, Cooldown: 10 * time.Millisecond})
+	b.record(errors.New("failed"))
+	for i, failed := range []bool{true, false} {
+		probe, err := b.wait(context.Background())
+		if err != nil || !probe {
+			t.Fatalf("#%d should send a probe: %t %v", i, probe, err)
+		}
+		if b.blocks() {
+			t.Fatalf("#%d shouldn't block the probe", i)
+		}
+		if failed {
+			b.record(errors.New("probe failed"))
+		} else {
+			b.record(nil)
+		}
+	}
+	if st := b.currentState(); st != breakerClosed {
+		t.Errorf("should be closed by succeeded probe: %s", st)
+	}
+	if probe, _ := b.wait(context.Background()); probe {
+		t.Error("closed breaker should not send probes")
+	}
+}
+
+func TestRunBreaker(t *testing.T) {
+	api := sqstest.New()
+	sendBodies(t, api, "tempfail")
+	sn := newTestSQSNotify(t, Succeed)
+	sn.Breaker.Failures = 5
+	sn.Breaker.Cooldown = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- sn.run(ctx, api)
+	}()
+	id := "00000000-0000-0000-0000-000000000001"
+	for i := 0; stageOf(t, sn.cache, id) != stage.Exec || sn.br.currentState() != breakerOpen; i++ {
+		if i >= 1000 {
+			t.Fatal("breaker isn't opened")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the message is visible again, but not received while open.
+	api.Advance(time.Minute)
+	time.Sleep(100 * time.Millisecond)
+	if counts := api.ReceiveCounts("test"); counts[id] != 1 {
+		t.Errorf("unexpected receive counts: %v", counts)
+	}
+	cancel()
+	if err := <-errCh; err != context.Canceled {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRunBreakerProbe(t *testing.T) {
+	api := sqstest.New()
+	sendBodies(t, api, "tempfail")
+	sn := newTestSQSNotify(t, Succeed)
+	sn.Breaker.Failures = 5
+	// long enough not to send the next probe, after the probe failed.
+	sn.Breaker.Cooldown = time.Second
+	total := func() int { return totalReceived(api, "test") }
+	opened := false
+	runUntil(t, sn, api, func() bool {
+		if !opened {
+			if total() != 1 || sn.br.currentState() != breakerOpen {
+				return false
+			}
+			// new messages, which are not in the cache, for the probe.
+			opened = true
+			sendBodies(t, api, "tempfail", "tempfail", "tempfail")
+		}
+		return total() > 1
+	})
+	// only a message is received as the probe.
+	if n := total(); n != 2 {
+		t.Errorf("unexpected receive counts by the probe: %d %v", n-1, api.ReceiveCounts("test"))
+	}
+}
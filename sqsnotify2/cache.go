@@ -8,11 +8,15 @@ import (
 	"net/url"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/koron/sqs-notify/sqsnotify2/stage"
 )
 
-const minCapacity = maxMsg
+const (
+	minCapacity      = maxMsg
+	minSweepInterval = time.Second
+)
 
 var (
 	errCacheFound    = errors.New("cache found")
@@ -25,25 +29,111 @@ type Cache interface {
 	Update(id string, stg stage.Stage) error
 	Delete(id string) error
 	Close() error
+
+	// Get gets an entry.  It returns nil when the entry is not found.
+	Get(id string) (*CacheItem, error)
+	// Walk calls fn for each entries.  fn can delete the entry.
+	Walk(fn func(*CacheItem) error) error
+}
+
+// CacheItem describes an entry of Cache.
+type CacheItem struct {
+	ID    string
+	Stage stage.Stage
+	Owner string
+	// Lease is time when the lease of owner expires.  Zero means no leases.
+	Lease time.Time
+	// Age is elapsed time since the entry is updated.  -1 means unknown.
+	Age time.Duration
+	// TTL is remaining time to live.  -1 means no expiration.
+	TTL time.Duration
 }
 
 type memoryCache struct {
 	c int
 	l sync.Mutex
-	m map[string]mcEntry
+	m map[string]*mcEntry
 	k *list.List
+
+	lifetime time.Duration
+	lease    time.Duration
+	now      func() time.Time
+	done     chan struct{}
 }
 
 type mcEntry struct {
-	el  *list.Element
-	stg stage.Stage
+	el    *list.Element
+	stg   stage.Stage
+	t     time.Time
+	lease time.Time
 }
 
 func newMemoryCache(capacity int) *memoryCache {
 	return &memoryCache{
-		c: capacity,
-		m: make(map[string]mcEntry),
-		k: list.New(),
+		c:   capacity,
+		m:   make(map[string]*mcEntry),
+		k:   list.New(),
+		now: time.Now,
+	}
+}
+
+// enabled checks the cache works or not.  A cache without enough capacity
+// works only when lifetime is given, it has no limits of capacity then.
+func (mc *memoryCache) enabled() bool {
+	return mc.c >= minCapacity || mc.lifetime > 0
+}
+
+func (mc *memoryCache) leaseTime() time.Duration {
+	return mc.lease
+}
+
+func (mc *memoryCache) setLease(v *mcEntry, now time.Time) {
+	if mc.lease > 0 && v.stg != stage.Done {
+		v.lease = now.Add(mc.lease)
+		return
+	}
+	v.lease = time.Time{}
+}
+
+func (mc *memoryCache) leaseExpired(v *mcEntry, now time.Time) bool {
+	return v.stg != stage.Done && !v.lease.IsZero() && !now.Before(v.lease)
+}
+
+func (mc *memoryCache) expired(v *mcEntry, now time.Time) bool {
+	return mc.lifetime > 0 && now.Sub(v.t) >= mc.lifetime
+}
+
+func (mc *memoryCache) remove(id string, v *mcEntry) {
+	delete(mc.m, id)
+	mc.k.Remove(v.el)
+}
+
+// get gets a valid entry.  An expired entry is removed.
+func (mc *memoryCache) get(id string, now time.Time) (*mcEntry, bool) {
+	v, ok := mc.m[id]
+	if !ok {
+		return nil, false
+	}
+	if mc.expired(v, now) {
+		mc.remove(id, v)
+		return nil, false
+	}
+	return v, true
+}
+
+// evict removes old entries to make a room for a new entry.  Entries in
+// stage.Exec are kept because their commands are still running.
+func (mc *memoryCache) evict() {
+	if mc.c < minCapacity {
+		return
+	}
+	for el := mc.k.Front(); el != nil && mc.k.Len() >= mc.c; {
+		next := el.Next()
+		id := el.Value.(string)
+		if v := mc.m[id]; v.stg != stage.Exec {
+			mc.remove(id, v)
+		}
+		el = next
 	}
 }
 
@@ -54,22 +144,25 @@ func (mc *memoryCache) Insert(id string, stg stage.Stage) error {
 	if stg == stage.None {
 		return nil
 	}
-	if mc.c < minCapacity {
+	if !mc.enabled() {
 		return nil
 	}
-	_, ok := mc.m[id]
+	now := mc.now()
+	v, ok := mc.get(id, now)
 	if ok {
-		return errCacheFound
+		if !mc.leaseExpired(v, now) {
+			return errCacheFound
+		}
+		// take over the entry which lease has been expired.
+		mc.remove(id, v)
 	}
 	// remove old entries.
-	for mc.k.Len() >= mc.c {
-		f := mc.k.Front()
-		delete(mc.m, f.Value.(string))
-		mc.k.Remove(f)
-	}
+	mc.evict()
 	// add an entry.
 	el := mc.k.PushBack(id)
-	mc.m[id] = mcEntry{el: el, stg: stg}
+	v = &mcEntry{el: el, stg: stg, t: now}
+	mc.setLease(v, now)
+	mc.m[id] = v
 	return nil
 }
 
@@ -80,14 +173,19 @@ func (mc *memoryCache) Update(id string, stg stage.Stage) error {
 	if stg == stage.None {
 		return nil
 	}
-	if mc.c < minCapacity {
+	if !mc.enabled() {
 		return nil
 	}
-	v, ok := mc.m[id]
+	now := mc.now()
+	v, ok := mc.get(id, now)
 	if !ok {
 		return errCacheNotFound
 	}
 	v.stg = stg
+	v.t = now
+	mc.setLease(v, now)
+	// keep the list ordered by age for sweep.
+	mc.k.MoveToBack(v.el)
 	return nil
 }
 
@@ -95,19 +193,119 @@ func (mc *memoryCache) Delete(id string) error {
 	mc.l.Lock()
 	defer mc.l.Unlock()
 
-	if mc.c < minCapacity {
+	if !mc.enabled() {
 		return nil
 	}
 	v, ok := mc.m[id]
 	if !ok {
 		return nil
 	}
-	delete(mc.m, id)
-	mc.k.Remove(v.el)
+	mc.remove(id, v)
 	return nil
 }
 
+func (mc *memoryCache) item(id string, v *mcEntry, now time.Time) *CacheItem {
+	item := &CacheItem{
+		ID:    id,
+		Stage: v.stg,
+		Lease: v.lease,
+		Age:   now.Sub(v.t),
+		TTL:   -1,
+	}
+	if mc.lifetime > 0 {
+		item.TTL = mc.lifetime - item.Age
+	}
+	return item
+}
+
+func (mc *memoryCache) Get(id string) (*CacheItem, error) {
+	mc.l.Lock()
+	defer mc.l.Unlock()
+
+	now := mc.now()
+	v, ok := mc.get(id, now)
+	if !ok {
+		return nil, nil
+	}
+	return mc.item(id, v, now), nil
+}
+
+func (mc *memoryCache) Walk(fn func(*CacheItem) error) error {
+	// take a snapshot to release the lock while calling fn.
+	mc.l.Lock()
+	now := mc.now()
+	items := make([]*CacheItem, 0, mc.k.Len())
+	for el := mc.k.Front(); el != nil; el = el.Next() {
+		id := el.Value.(string)
+		v := mc.m[id]
+		if mc.expired(v, now) {
+			continue
+		}
+		items = append(items, mc.item(id, v, now))
+	}
+	mc.l.Unlock()
+
+	for _, item := range items {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sweep removes all expired entries.
+func (mc *memoryCache) sweep() {
+	mc.l.Lock()
+	defer mc.l.Unlock()
+
+	now := mc.now()
+	for el := mc.k.Front(); el != nil; {
+		next := el.Next()
+		id := el.Value.(string)
+		v := mc.m[id]
+		if !mc.expired(v, now) {
+			// the list is ordered by age, rest entries are valid.
+			break
+		}
+		mc.remove(id, v)
+		el = next
+	}
+}
+
+// startSweeper starts a goroutine which removes expired entries
+// periodically.  It is stopped by ctx or Close().
+func (mc *memoryCache) startSweeper(ctx context.Context) {
+	if mc.lifetime <= 0 || mc.done != nil {
+		return
+	}
+	d := mc.lifetime / 2
+	if d < minSweepInterval {
+		d = minSweepInterval
+	}
+	mc.done = make(chan struct{})
+	go func() {
+		tk := time.NewTicker(d)
+		defer tk.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-mc.done:
+				return
+			case <-tk.C:
+				mc.sweep()
+			}
+		}
+	}()
+}
+
 func (mc *memoryCache) Close() error {
+	mc.l.Lock()
+	defer mc.l.Unlock()
+	if mc.done != nil {
+		close(mc.done)
+		mc.done = nil
+	}
 	return nil
 }
 
@@ -128,7 +326,21 @@ func NewCache(ctx context.Context, name string) (Cache, error) {
 				return nil, err
 			}
 		}
-		return newMemoryCache(capacity), nil
+		mc := newMemoryCache(capacity)
+		if s := q.Get("lifetime"); s != "" {
+			mc.lifetime, err = time.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse lifetime: %s", err)
+			}
+		}
+		if s := q.Get("lease"); s != "" {
+			mc.lease, err = parseLease(s)
+			if err != nil {
+				return nil, err
+			}
+		}
+		mc.startSweeper(ctx)
+		return mc, nil
 
 	case "redis":
 		return newRedisCache(ctx, u)
@@ -0,0 +1,115 @@
+package sqsnotify2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/koron/sqs-notify/sqsnotify2/stage"
+)
+
+var errCacheTakenOver = errors.New("cache taken over by other owner")
+
+// cacheEntry is a value of cache entry stored in external storages.
+type cacheEntry struct {
+	Stage stage.Stage `json:"stage"`
+	// Owner is an identifier of the process which processes the message.
+	Owner string `json:"owner,omitempty"`
+	// Lease is UNIX time in milliseconds when the lease of owner expires.
+	// Zero means no leases.
+	Lease int64 `json:"lease,omitempty"`
+	// Time is UNIX time in milliseconds when the entry is updated.
+	Time int64 `json:"time,omitempty"`
+}
+
+// newCacheEntry creates a cacheEntry with a lease.  Entries in stage.Done
+// don't have leases, because nobody should take over them.
+func newCacheEntry(stg stage.Stage, owner string, lease time.Duration, now time.Time) *cacheEntry {
+	e := &cacheEntry{Stage: stg, Owner: owner, Time: now.UnixMilli()}
+	if lease > 0 && stg != stage.Done {
+		e.Lease = now.Add(lease).UnixMilli()
+	}
+	return e
+}
+
+// leaseExpired checks the entry can be taken over or not.
+func (e *cacheEntry) leaseExpired(now time.Time) bool {
+	return e.Stage != stage.Done && e.Lease != 0 && now.UnixMilli() >= e.Lease
+}
+
+// MarshalBinary marshals cacheEntry into []byte.  used by go-redis/redis.
+func (e *cacheEntry) MarshalBinary() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// UnmarshalBinary unmarshals []byte into cacheEntry.  It accepts the legacy
+// format too, which is a single byte of stage.Stage.
+func (e *cacheEntry) UnmarshalBinary(b []byte) error {
+	if len(b) == 1 {
+		*e = cacheEntry{Stage: stage.Stage(b[0])}
+	} else if err := json.Unmarshal(b, e); err != nil {
+		return fmt.Errorf("failed to decode cache entry: %s", err)
+	}
+	// stored entries are never in stage.None.
+	if e.Stage <= stage.None || e.Stage > stage.Done {
+		return fmt.Errorf("invalid stage of cache entry: %d", e.Stage)
+	}
+	return nil
+}
+
+// item converts cacheEntry into CacheItem.
+func (e *cacheEntry) item(id string, ttl time.Duration, now time.Time) *CacheItem {
+	item := &CacheItem{
+		ID:    id,
+		Stage: e.Stage,
+		Owner: e.Owner,
+		Age:   -1,
+		TTL:   ttl,
+	}
+	if e.Time != 0 {
+		item.Age = now.Sub(time.UnixMilli(e.Time))
+	}
+	if e.Lease != 0 {
+		item.Lease = time.UnixMilli(e.Lease)
+	}
+	return item
+}
+
+// defaultOwner returns an owner identifier of this process.
+func defaultOwner() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// leaser is implemented by caches which support leases of entries.
+type leaser interface {
+	leaseTime() time.Duration
+}
+
+// minLease is min lease of entries.  Leases are renewed at a third of it.
+const minLease = 100 * time.Millisecond
+
+// checkLease checks a lease is zero (no leases) or at least minLease.
+func checkLease(d time.Duration) error {
+	if d != 0 && d < minLease {
+		return fmt.Errorf("lease should be 0 or %s or more: %s", minLease, d)
+	}
+	return nil
+}
+
+// parseLease parses "lease" option of caches.
+func parseLease(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse lease: %s", err)
+	}
+	if err := checkLease(d); err != nil {
+		return 0, err
+	}
+	return d, nil
+}
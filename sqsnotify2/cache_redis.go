@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -16,6 +17,9 @@ type redisCache struct {
 
 	prefix   string
 	lifetime time.Duration
+	lease    time.Duration
+	owner    string
+	now      func() time.Time
 }
 
 func newRedisCache(ctx context.Context, u *url.URL) (*redisCache, error) {
@@ -25,7 +29,7 @@ func newRedisCache(ctx context.Context, u *url.URL) (*redisCache, error) {
 	var (
 		err error
 		opt = &redis.Options{Addr: u.Host}
-		rc  = &redisCache{}
+		rc  = &redisCache{owner: defaultOwner(), now: time.Now}
 	)
 	if u.User != nil {
 		opt.Password, _ = u.User.Password()
@@ -46,6 +50,15 @@ func newRedisCache(ctx context.Context, u *url.URL) (*redisCache, error) {
 	if s := v.Get("prefix"); s != "" {
 		rc.prefix = s
 	}
+	if s := v.Get("lease"); s != "" {
+		rc.lease, err = parseLease(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s := v.Get("owner"); s != "" {
+		rc.owner = s
+	}
 	c := redis.NewClient(opt).WithContext(ctx)
 	if _, err := c.Ping().Result(); err != nil {
 		if err != nil {
@@ -61,32 +74,106 @@ func (rc *redisCache) key(id string) string {
 	return rc.prefix + id
 }
 
+func (rc *redisCache) leaseTime() time.Duration {
+	return rc.lease
+}
+
+func (rc *redisCache) newEntry(stg stage.Stage) *cacheEntry {
+	return newCacheEntry(stg, rc.owner, rc.lease, rc.now())
+}
+
+func (rc *redisCache) getEntry(tx *redis.Tx, key string) (*cacheEntry, error) {
+	b, err := tx.Get(key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	e := &cacheEntry{}
+	if err := e.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
 func (rc *redisCache) Insert(id string, stg stage.Stage) error {
 	if stg == stage.None {
 		return nil
 	}
-	b, err := rc.c.SetNX(rc.key(id), stg, rc.lifetime).Result()
+	k := rc.key(id)
+	e := rc.newEntry(stg)
+	b, err := rc.c.SetNX(k, e, rc.lifetime).Result()
 	if err != nil {
 		return err
 	}
-	if !b {
+	if b {
+		return nil
+	}
+	if rc.lease <= 0 {
 		return errCacheFound
 	}
-	return nil
+	return rc.takeOver(k, e)
+}
+
+// takeOver overwrites an entry which lease has been expired.
+func (rc *redisCache) takeOver(k string, e *cacheEntry) error {
+	err := rc.c.Watch(func(tx *redis.Tx) error {
+		old, err := rc.getEntry(tx, k)
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if old != nil && !old.leaseExpired(rc.now()) {
+			return errCacheFound
+		}
+		_, err = tx.Pipelined(func(p redis.Pipeliner) error {
+			p.Set(k, e, rc.lifetime)
+			return nil
+		})
+		return err
+	}, k)
+	if err == redis.TxFailedErr {
+		// other owner modified the entry at same time.
+		return errCacheFound
+	}
+	return err
 }
 
 func (rc *redisCache) Update(id string, stg stage.Stage) error {
 	if stg == stage.None {
 		return nil
 	}
-	b, err := rc.c.SetXX(rc.key(id), stg, rc.lifetime).Result()
-	if err != nil {
-		return err
+	k := rc.key(id)
+	e := rc.newEntry(stg)
+	if rc.lease <= 0 {
+		b, err := rc.c.SetXX(k, e, rc.lifetime).Result()
+		if err != nil {
+			return err
+		}
+		if !b {
+			return errCacheNotFound
+		}
+		return nil
 	}
-	if !b {
-		return errCacheNotFound
+	// check the owner not to overwrite an entry which was taken over.
+	err := rc.c.Watch(func(tx *redis.Tx) error {
+		old, err := rc.getEntry(tx, k)
+		if err == redis.Nil {
+			return errCacheNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if old.Owner != "" && old.Owner != rc.owner {
+			return errCacheTakenOver
+		}
+		_, err = tx.Pipelined(func(p redis.Pipeliner) error {
+			p.Set(k, e, rc.lifetime)
+			return nil
+		})
+		return err
+	}, k)
+	if err == redis.TxFailedErr {
+		return errCacheTakenOver
 	}
-	return nil
+	return err
 }
 
 func (rc *redisCache) Delete(id string) error {
@@ -97,6 +184,85 @@ func (rc *redisCache) Delete(id string) error {
 	return nil
 }
 
+func (rc *redisCache) Get(id string) (*CacheItem, error) {
+	return rc.get(rc.key(id))
+}
+
+// invalidEntryError is an error for a key which isn't a cache entry.
+type invalidEntryError struct {
+	key string
+	err error
+}
+
+func (e *invalidEntryError) Error() string {
+	return fmt.Sprintf("%s (key=%s)", e.err, e.key)
+}
+
+func (rc *redisCache) get(k string) (*CacheItem, error) {
+	b, err := rc.c.Get(k).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		// a key of other types than string.
+		if strings.HasPrefix(err.Error(), "WRONGTYPE") {
+			return nil, &invalidEntryError{key: k, err: err}
+		}
+		return nil, err
+	}
+	ttl, err := rc.c.PTTL(k).Result()
+	if err != nil {
+		return nil, err
+	}
+	if ttl < 0 {
+		// -1ms means no expiration, -2ms means the key has gone.
+		if ttl == -2*time.Millisecond {
+			return nil, nil
+		}
+		ttl = -1
+	}
+	e := &cacheEntry{}
+	if err := e.UnmarshalBinary(b); err != nil {
+		return nil, &invalidEntryError{key: k, err: err}
+	}
+	return e.item(strings.TrimPrefix(k, rc.prefix), ttl, rc.now()), nil
+}
+
+// Walk calls fn for each entry.  Keys which aren't cache entries, like keys
+// of other applications in same DB, are skipped.
+func (rc *redisCache) Walk(fn func(*CacheItem) error) error {
+	iter := rc.c.Scan(0, escapeGlob(rc.prefix)+"*", 100).Iterator()
+	for iter.Next() {
+		item, err := rc.get(iter.Val())
+		if _, ok := err.(*invalidEntryError); ok {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			continue
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// escapeGlob escapes special characters for MATCH of SCAN command.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
 func (rc *redisCache) Close() error {
 	if rc.c != nil {
 		err := rc.c.Close()
@@ -4,9 +4,11 @@ import (
 	"context"
 	"net/url"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/koron/sqs-notify/sqsnotify2/sqstest"
 	"github.com/koron/sqs-notify/sqsnotify2/stage"
 )
 
@@ -32,6 +34,29 @@ func testCache(t *testing.T, c Cache) {
 		t.Fatalf("unexpected update: %v", err)
 	}
 
+	item, err := c.Get(id1)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if item == nil || item.ID != id1 || item.Stage != stage.Exec {
+		t.Fatalf("unexpected item: %+v", item)
+	}
+	item, err = c.Get(id2)
+	if err != nil || item != nil {
+		t.Fatalf("unexpected get: item=%+v err=%v", item, err)
+	}
+	var ids []string
+	err = c.Walk(func(item *CacheItem) error {
+		ids = append(ids, item.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != id1 {
+		t.Fatalf("unexpected walk: %v", ids)
+	}
+
 	err = c.Delete(id1)
 	if err != nil {
 		t.Fatalf("failed to delete: %v", err)
@@ -67,3 +92,170 @@ func TestMemoryCache(t *testing.T) {
 	mc := newMemoryCache(minCapacity)
 	testCache(t, mc)
 }
+
+func TestMemoryCacheLifetime(t *testing.T) {
+	now := time.Unix(1000, 0)
+	mc := newMemoryCache(0)
+	mc.lifetime = 10 * time.Second
+	mc.now = func() time.Time { return now }
+
+	testCache(t, mc)
+
+	if err := mc.Insert("1234", stage.Recv); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	now = now.Add(5 * time.Second)
+	if err := mc.Insert("5678", stage.Recv); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := mc.Insert("1234", stage.Recv); err != errCacheFound {
+		t.Fatalf("unexpected insertion before expiration: %v", err)
+	}
+
+	now = now.Add(5 * time.Second)
+	mc.sweep()
+	if _, ok := mc.m["1234"]; ok {
+		t.Fatal("expired entry is not swept")
+	}
+	if _, ok := mc.m["5678"]; !ok {
+		t.Fatal("valid entry is swept")
+	}
+	if err := mc.Update("1234", stage.Exec); err != errCacheNotFound {
+		t.Fatalf("unexpected update for expired entry: %v", err)
+	}
+	if err := mc.Insert("1234", stage.Recv); err != nil {
+		t.Fatalf("failed to insert after expiration: %v", err)
+	}
+}
+
+func TestMemoryCacheKeepExec(t *testing.T) {
+	mc := newMemoryCache(minCapacity)
+	for i := 0; i < minCapacity; i++ {
+		id := strconv.Itoa(i)
+		if err := mc.Insert(id, stage.Recv); err != nil {
+			t.Fatalf("failed to insert %s: %v", id, err)
+		}
+	}
+	if err := mc.Update("0", stage.Exec); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	for i := minCapacity; i < minCapacity*3; i++ {
+		id := strconv.Itoa(i)
+		if err := mc.Insert(id, stage.Recv); err != nil {
+			t.Fatalf("failed to insert %s: %v", id, err)
+		}
+	}
+	if _, ok := mc.m["0"]; !ok {
+		t.Fatal("entry in Exec stage is evicted")
+	}
+	if _, ok := mc.m["1"]; ok {
+		t.Fatal("old entry is not evicted")
+	}
+	if n := mc.k.Len(); n != minCapacity {
+		t.Fatalf("unexpected length: want=%d got=%d", minCapacity, n)
+	}
+}
+
+func TestMemoryCacheLease(t *testing.T) {
+	now := time.Unix(1000, 0)
+	mc := newMemoryCache(minCapacity)
+	mc.lease = 30 * time.Second
+	mc.now = func() time.Time { return now }
+
+	if err := mc.Insert("1234", stage.Recv); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := mc.Update("1234", stage.Exec); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	now = now.Add(20 * time.Second)
+	if err := mc.Insert("1234", stage.Recv); err != errCacheFound {
+		t.Fatalf("unexpected insertion in lease: %v", err)
+	}
+	// renew the lease.
+	if err := mc.Update("1234", stage.Exec); err != nil {
+		t.Fatalf("failed to renew: %v", err)
+	}
+	now = now.Add(20 * time.Second)
+	if err := mc.Insert("1234", stage.Recv); err != errCacheFound {
+		t.Fatalf("unexpected insertion in renewed lease: %v", err)
+	}
+	now = now.Add(20 * time.Second)
+	if err := mc.Insert("1234", stage.Recv); err != nil {
+		t.Fatalf("failed to take over: %v", err)
+	}
+
+	// entries in Done are never taken over.
+	if err := mc.Update("1234", stage.Done); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	now = now.Add(time.Hour)
+	if err := mc.Insert("1234", stage.Recv); err != errCacheFound {
+		t.Fatalf("unexpected insertion for done: %v", err)
+	}
+}
+
+func TestNewCacheLease(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, name := range []string{"memory://?lease=1ns", "memory://?lease=99ms", "memory://?lease=-1s"} {
+		if _, err := NewCache(ctx, name); err == nil {
+			t.Errorf("too short lease should be rejected: %s", name)
+		}
+	}
+	for _, name := range []string{"memory://?lease=0", "memory://?lease=100ms"} {
+		if _, err := NewCache(ctx, name); err != nil {
+			t.Errorf("failed to create cache %s: %v", name, err)
+		}
+	}
+
+	// a cache which is given directly is checked before running.
+	mc := newMemoryCache(minCapacity)
+	mc.lease = time.Nanosecond
+	sn := newTestSQSNotify(t, Succeed)
+	sn.cache = mc
+	if err := sn.run(ctx, sqstest.New()); err == nil {
+		t.Fatal("too short lease should be rejected by run")
+	}
+}
+
+func TestCacheEntry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	e := newCacheEntry(stage.Exec, "host:123", 30*time.Second, now)
+	b, err := e.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	var e2 cacheEntry
+	if err := e2.UnmarshalBinary(b); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	if e2 != *e {
+		t.Fatalf("unmatch entry: want=%+v got=%+v", *e, e2)
+	}
+	if e2.leaseExpired(now.Add(29 * time.Second)) {
+		t.Fatal("lease expired too early")
+	}
+	if !e2.leaseExpired(now.Add(30 * time.Second)) {
+		t.Fatal("lease should be expired")
+	}
+
+	// legacy format
+	var e3 cacheEntry
+	if err := e3.UnmarshalBinary([]byte{byte(stage.Done)}); err != nil {
+		t.Fatalf("failed to unmarshal legacy: %v", err)
+	}
+	if e3.Stage != stage.Done || e3.Owner != "" || e3.Lease != 0 {
+		t.Fatalf("unexpected legacy entry: %+v", e3)
+	}
+}
+
+func TestCacheEntryInvalid(t *testing.T) {
+	// values of other applications aren't decoded as entries.
+	for _, s := range []string{"x", "0", `{}`, `{"name":"foo"}`, `{"stage":"None"}`, `[1,2]`, "hello"} {
+		var e cacheEntry
+		if err := e.UnmarshalBinary([]byte(s)); err == nil {
+			t.Errorf("unexpected entry for %q: %+v", s, e)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package sqsnotify2
 
 import (
+	"io"
 	"log"
 	"runtime"
 	"time"
@@ -18,6 +19,32 @@ const (
 	BeforeExecution = 2
 )
 
+func (rp RemovePolicy) String() string {
+	switch rp {
+	case Succeed:
+		return "Succeed"
+	case IgnoreFailure:
+		return "IgnoreFailure"
+	case BeforeExecution:
+		return "BeforeExecution"
+	default:
+		return "Unknown"
+	}
+}
+
+// DuplicatePolicy is a policy to handle duplicated messages found in cache.
+type DuplicatePolicy int
+
+const (
+	// KeepDuplicate means "leave duplicated messages in SQS"
+	KeepDuplicate DuplicatePolicy = 0
+	// ByStage means "handle duplicated messages by stage in cache"
+	//   - Done: delete the message
+	//   - Exec: extend visibility timeout of the message
+	//   - Recv or Lock: leave the message in SQS
+	ByStage = 1
+)
+
 // Config configures sqsnotify2 service
 type Config struct {
 	Profile     string
@@ -25,24 +52,121 @@ type Config struct {
 	Endpoint    string
 	QueueName   string
 	CreateQueue bool
-	MaxRetries  int
-	WaitTime    *int64
+	// QueueOptions configures a queue created by CreateQueue.
+	QueueOptions *QueueOptions
+	MaxRetries   int
+	WaitTime     *int64
+
+	// RoleARN is a role to assume by STS, credentials of Profile are used
+	// to assume it.  With WebIdentityTokenFile, it is assumed with a web
+	// identity token in the file.
+	RoleARN              string
+	ExternalID           string
+	RoleSessionName      string
+	WebIdentityTokenFile string
+	// STSEndpoint is an endpoint of STS to assume the role.
+	STSEndpoint string
+
+	// Filter is an expression to choose messages to be processed (see
+	// README).  Messages which don't match are handled by FilterAction.
+	// Empty means "all messages".
+	Filter       string
+	FilterAction FilterAction
+	// FilterQueue is a queue to move messages to, used with FilterMove.
+	FilterQueue string
+	// FilterVisibility is visibility timeout for messages which don't
+	// match, used with FilterLeave.  Zero makes them visible immediately for
+	// other consumers.  It is rounded up to seconds.
+	FilterVisibility time.Duration
 
 	CacheName string
+	// DedupKey chooses a key of cache: "message-id" (default),
+	// "body-sha256", "json:{PATH}" or "attr:{NAME}".  Messages without
+	// the key fall back to "message-id".
+	DedupKey string
+
+	DuplicatePolicy DuplicatePolicy
+	// DuplicateVisibility is visibility timeout for duplicated messages in
+	// stage.Exec, used with ByStage.  It is rounded up to seconds, and at
+	// least a second.
+	DuplicateVisibility time.Duration
 
-	Workers      int
+	Workers int
+	// MaxMessages is max num of messages to receive at once, in 1-10.
+	// Zero means 10.
+	MaxMessages  int
 	Timeout      time.Duration
 	RemovePolicy RemovePolicy
 	CmdName      string
 	CmdArgs      []string
 
+	// RateInterval is min interval between executions of commands.  Zero
+	// means "no limits".
+	RateInterval time.Duration
+
+	// InputMode is a way to pass a body to commands.
+	InputMode InputMode
+
+	// Coprocess starts Workers long-lived copies of the command, and sends
+	// messages to them by a line protocol, instead of executing the command
+	// for each message.  InputMode is ignored.
+	Coprocess bool
+	// CoprocessRecycle restarts a co-process after it processed the number
+	// of messages.  Zero means "never".
+	CoprocessRecycle int
+
+	// Dir is a working directory of commands.
+	Dir string
+	// CleanEnv runs commands without environment variables of sqs-notify2.
+	CleanEnv bool
+	// Env is additional environment variables of commands in "KEY=VALUE".
+	Env []string
+	// User and Group run commands as them, by names or IDs.  Unix only.
+	User  string
+	Group string
+	// Rlimits limits resources of commands.  Linux only.  Commands are
+	// started via the running executable, which sets the limits in init of
+	// this package and executes them.
+	Rlimits Rlimits
+
+	// KillGrace is a grace period to kill a process group of a command
+	// after SIGTERM, on timeout or shutdown.  Zero kills it immediately.
+	KillGrace time.Duration
+
+	// Breaker stops receiving messages when commands fail repeatedly.
+	Breaker CircuitBreaker
+
+	// Control pauses, resumes and drains at runtime, if not nil.
+	Control *Control
+
+	// DryRun receives messages once, shows what would run for them, and
+	// makes them visible again without execution.
+	DryRun bool
+	// DryRunOutput is a writer to show plans of DryRun.  os.Stdout is used
+	// when it is nil.
+	DryRunOutput io.Writer
+
+	// Archive records received messages and their outcomes, if not nil.
+	Archive *Archive
+
+	// Tracer records spans of receiving, executing and deleting messages,
+	// if not nil.
+	Tracer *Tracer
+
 	Logger *log.Logger
 }
 
 // NewConfig creates a new Config object.
 func NewConfig() *Config {
 	return &Config{
-		Region:  "us-east-1",
-		Workers: runtime.NumCPU(),
+		Region:    "us-east-1",
+		Workers:   runtime.NumCPU(),
+		KillGrace: 5 * time.Second,
+		Breaker: CircuitBreaker{
+			Window:       time.Minute,
+			MinRequests:  10,
+			Cooldown:     30 * time.Second,
+			TempFailCode: EXTempFail,
+		},
 	}
 }
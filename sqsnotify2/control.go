@@ -0,0 +1,205 @@
+package sqsnotify2
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/koron/sqs-notify/sqsnotify2/stage"
+)
+
+// states of Control.
+const (
+	// StateRunning means "receiving messages"
+	StateRunning = "running"
+	// StatePaused means "not receiving messages until resumed"
+	StatePaused = "paused"
+	// StateDraining means "not receiving messages, and stopping after
+	// messages in flight finished"
+	StateDraining = "draining"
+)
+
+// errDrained is returned by Control.wait when it is draining.
+var errDrained = errors.New("drained")
+
+// Control controls SQSNotify at runtime: pause, resume and drain.  It also
+// reports messages in flight.  A Control can be shared by SQSNotify objects.
+// Messages in flight are not affected by pause and drain.
+type Control struct {
+	mu       sync.Mutex
+	paused   bool
+	draining bool
+	changed  chan struct{}
+	inFlight map[*result]*InFlight
+}
+
+// InFlight describes a message in flight.
+type InFlight struct {
+	ID         string      `json:"id"`
+	Queue      string      `json:"queue"`
+	Stage      stage.Stage `json:"stage"`
+	ReceivedAt time.Time   `json:"receivedAt"`
+	// Elapsed is elapsed time since received, like "1.5s".
+	Elapsed string `json:"elapsed"`
+}
+
+// Status is a status of Control.
+type Status struct {
+	State    string      `json:"state"`
+	InFlight []*InFlight `json:"inFlight"`
+}
+
+// NewControl creates a new Control.
+func NewControl() *Control {
+	return &Control{
+		changed:  make(chan struct{}),
+		inFlight: map[*result]*InFlight{},
+	}
+}
+
+// notify wakes up waiters of changes.  It should be called with lock.
+func (c *Control) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// Pause stops receiving messages until Resume.  It returns false when it has
+// been paused already.
+func (c *Control) Pause() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		return false
+	}
+	c.paused = true
+	c.notify()
+	return true
+}
+
+// Resume restarts receiving messages.  It returns false when it isn't
+// paused.
+func (c *Control) Resume() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		return false
+	}
+	c.paused = false
+	c.notify()
+	return true
+}
+
+// Drain stops receiving messages, and makes SQSNotify.Run return nil after
+// messages in flight finished.  It can't be canceled.
+func (c *Control) Drain() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.draining {
+		return false
+	}
+	c.draining = true
+	c.notify()
+	return true
+}
+
+// Status returns current state and messages in flight, sorted by received
+// time.
+func (c *Control) Status() *Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := &Status{State: StateRunning, InFlight: []*InFlight{}}
+	switch {
+	case c.draining:
+		st.State = StateDraining
+	case c.paused:
+		st.State = StatePaused
+	}
+	now := time.Now()
+	for _, f := range c.inFlight {
+		g := *f
+		g.Elapsed = now.Sub(g.ReceivedAt).Round(time.Millisecond).String()
+		st.InFlight = append(st.InFlight, &g)
+	}
+	sort.Slice(st.InFlight, func(i, j int) bool {
+		a, b := st.InFlight[i], st.InFlight[j]
+		if !a.ReceivedAt.Equal(b.ReceivedAt) {
+			return a.ReceivedAt.Before(b.ReceivedAt)
+		}
+		return a.ID < b.ID
+	})
+	return st
+}
+
+// wait waits while paused.  It returns errDrained when draining.
+func (c *Control) wait(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	for {
+		c.mu.Lock()
+		draining, paused, ch := c.draining, c.paused, c.changed
+		c.mu.Unlock()
+		if draining {
+			return errDrained
+		}
+		if !paused {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		}
+	}
+}
+
+// context returns a context which is canceled when the state is changed.
+func (c *Control) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c == nil {
+		return ctx, func() {}
+	}
+	c.mu.Lock()
+	ch := c.changed
+	c.mu.Unlock()
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-ch:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// track updates a stage of a message in flight.
+func (c *Control) track(queue string, r *result) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.inFlight[r]
+	if !ok {
+		f = &InFlight{
+			ID:         aws.StringValue(r.msg.MessageId),
+			Queue:      queue,
+			ReceivedAt: r.recv,
+		}
+		c.inFlight[r] = f
+	}
+	f.Stage = r.stg
+}
+
+// untrack removes a message which is finished.
+func (c *Control) untrack(r *result) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.inFlight, r)
+	c.mu.Unlock()
+}
@@ -0,0 +1,123 @@
+package sqsnotify2
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/koron/sqs-notify/sqsnotify2/sqstest"
+	"github.com/koron/sqs-notify/sqsnotify2/stage"
+)
+
+// startRun starts sn.run, and returns a channel of its error.
+func startRun(t *testing.T, sn *SQSNotify, api *sqstest.SQS) <-chan error {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- sn.run(ctx, api)
+	}()
+	return errCh
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for !cond() {
+		select {
+		case <-timeout:
+			t.Fatal("timed out")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestControlState(t *testing.T) {
+	c := NewControl()
+	if st := c.Status(); st.State != StateRunning || len(st.InFlight) != 0 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if !c.Pause() || c.Pause() {
+		t.Error("Pause should return true only at first")
+	}
+	if st := c.Status(); st.State != StatePaused {
+		t.Errorf("unexpected state: %s", st.State)
+	}
+	if !c.Resume() || c.Resume() {
+		t.Error("Resume should return true only at first")
+	}
+	if !c.Drain() || c.Drain() {
+		t.Error("Drain should return true only at first")
+	}
+	if st := c.Status(); st.State != StateDraining {
+		t.Errorf("unexpected state: %s", st.State)
+	}
+	if err := c.wait(context.Background()); err != errDrained {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRunPauseResume(t *testing.T) {
+	api := sqstest.New()
+	sendBodies(t, api, "ok")
+	sn := newTestSQSNotify(t, Succeed)
+	sn.Control = NewControl()
+	sn.Control.Pause()
+	errCh := startRun(t, sn, api)
+
+	time.Sleep(100 * time.Millisecond)
+	if st := api.Stats("test"); st != (sqstest.Stats{Visible: 1}) {
+		t.Fatalf("message should not be received while paused: %+v", st)
+	}
+	sn.Control.Resume()
+	waitFor(t, func() bool { return api.Stats("test") == sqstest.Stats{} })
+
+	// pause interrupts long polling.
+	sn.Control.Pause()
+	time.Sleep(100 * time.Millisecond)
+	sendBodies(t, api, "ok")
+	time.Sleep(100 * time.Millisecond)
+	if st := api.Stats("test"); st != (sqstest.Stats{Visible: 1}) {
+		t.Fatalf("message should not be received while paused: %+v", st)
+	}
+
+	sn.Control.Drain()
+	if err := <-errCh; err != nil {
+		t.Fatalf("drain should stop without errors: %v", err)
+	}
+}
+
+func TestRunDrainInFlight(t *testing.T) {
+	api := sqstest.New()
+	sendBodies(t, api, "sleep")
+	sn := newTestSQSNotify(t, Succeed)
+	sn.Timeout = 500 * time.Millisecond
+	sn.KillGrace = 0
+	sn.Control = NewControl()
+	errCh := startRun(t, sn, api)
+
+	var st *Status
+	waitFor(t, func() bool {
+		st = sn.Control.Status()
+		return len(st.InFlight) == 1 && st.InFlight[0].Stage == stage.Exec
+	})
+	if f := st.InFlight[0]; f.ID != "00000000-0000-0000-0000-000000000001" || f.Queue != "test" || f.Elapsed == "" {
+		t.Errorf("unexpected in-flight message: %+v", f)
+	}
+	sn.Control.Drain()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("drain should stop without errors: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not drained")
+	}
+	if st := sn.Control.Status(); len(st.InFlight) != 0 {
+		t.Errorf("in-flight messages are left: %+v", st.InFlight)
+	}
+	if counts := api.ReceiveCounts("test"); len(counts) != 1 {
+		t.Errorf("unexpected receive counts: %v", counts)
+	}
+}
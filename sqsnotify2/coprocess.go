@@ -0,0 +1,336 @@
+package sqsnotify2
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// results of co-processes.
+const (
+	// CoprocessOK means "the message is processed successfully"
+	CoprocessOK = "ok"
+	// CoprocessRetry means "the message should be processed again"
+	CoprocessRetry = "retry"
+	// CoprocessFail means "the message failed"
+	CoprocessFail = "fail"
+)
+
+// CoprocessRequest is a request to a co-process, written to its STDIN as a
+// line of JSON.
+type CoprocessRequest struct {
+	ID         string                     `json:"id"`
+	Body       string                     `json:"body"`
+	Attributes map[string]*AttributeValue `json:"attributes,omitempty"`
+	// Traceparent and AWSTraceHeader are trace context of the message.
+	Traceparent    string `json:"traceparent,omitempty"`
+	AWSTraceHeader string `json:"awsTraceHeader,omitempty"`
+}
+
+// CoprocessResponse is a response from a co-process, read from its STDOUT as
+// a line of JSON.
+type CoprocessResponse struct {
+	ID     string `json:"id"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// retryError is returned when a co-process asked to retry a message.
+type retryError struct {
+	msg string
+}
+
+func (err *retryError) Error() string {
+	if err.msg == "" {
+		return "co-process asked to retry"
+	}
+	return fmt.Sprintf("co-process asked to retry: %s", err.msg)
+}
+
+func isRetry(err error) bool {
+	_, ok := err.(*retryError)
+	return ok
+}
+
+// coprocPool is a pool of co-processes, long-lived copies of the command.
+type coprocPool struct {
+	sn   *SQSNotify
+	idle chan *coproc
+
+	// recycling is co-processes which are being stopped by recycle.
+	recycling sync.WaitGroup
+}
+
+// newCoprocPool starts n co-processes.
+func newCoprocPool(sn *SQSNotify, n int) (*coprocPool, error) {
+	p := &coprocPool{
+		sn:   sn,
+		idle: make(chan *coproc, n),
+	}
+	for i := 0; i < n; i++ {
+		c, err := p.start()
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		p.idle <- c
+	}
+	return p, nil
+}
+
+func (p *coprocPool) start() (*coproc, error) {
+	// InputMode is ignored, co-processes receive bodies by requests.
+	cmd := p.sn.newCmdWith(context.Background(), p.sn.CmdArgs, p.sn.cmdEnv())
+	setProcessGroup(cmd)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	// use own pipe for STDOUT, because Wait() closes pipes by StdoutPipe()
+	// and the last response may be lost.
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = w
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to start co-process: %s", err)
+	}
+	c := &coproc{
+		cmd:    cmd,
+		stdin:  stdin,
+		lines:  make(chan []byte),
+		exited: make(chan struct{}),
+	}
+	go c.read(r)
+	go func() {
+		c.err = cmd.Wait()
+		close(c.exited)
+	}()
+	return c, nil
+}
+
+// exec processes a message by an idle co-process.  Exited co-processes are
+// restarted, and a co-process is recycled after CoprocessRecycle messages.
+func (p *coprocPool) exec(ctx context.Context, m *sqs.Message) error {
+	var c *coproc
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case c = <-p.idle:
+	}
+	defer func() {
+		p.idle <- c
+	}()
+	if c != nil && c.hasExited() {
+		p.sn.log().Printf("co-process exited, restarting: pid=%d err=%s", c.cmd.Process.Pid, c.exitError())
+		c.discard()
+		c = nil
+	}
+	if c == nil {
+		var err error
+		c, err = p.start()
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := c.call(ctx, newCoprocessRequest(ctx, m))
+	if err != nil {
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			err = fmt.Errorf("co-process timed out, restarted: %s", err)
+		case context.Canceled:
+			err = fmt.Errorf("co-process canceled: %s", err)
+		}
+		c.kill(p.sn.KillGrace)
+		c = nil
+		return err
+	}
+	c.count++
+	if n := p.sn.CoprocessRecycle; n > 0 && c.count >= n {
+		// stop it in background, not to block the message.
+		p.recycling.Add(1)
+		go func(c *coproc) {
+			defer p.recycling.Done()
+			c.stop(p.sn.KillGrace)
+		}(c)
+		c = nil
+	}
+
+	switch res.Result {
+	case CoprocessOK:
+		return nil
+	case CoprocessRetry:
+		return &retryError{msg: res.Error}
+	default:
+		if res.Error == "" {
+			return fmt.Errorf("co-process failed")
+		}
+		return fmt.Errorf("co-process failed: %s", res.Error)
+	}
+}
+
+// close stops all co-processes.  It should be called when no messages are
+// processed.
+func (p *coprocPool) close() {
+	var wg sync.WaitGroup
+	for len(p.idle) > 0 {
+		c := <-p.idle
+		if c == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.stop(p.sn.KillGrace)
+		}()
+	}
+	wg.Wait()
+	p.recycling.Wait()
+}
+
+func newCoprocessRequest(ctx context.Context, m *sqs.Message) *CoprocessRequest {
+	req := &CoprocessRequest{
+		ID:   aws.StringValue(m.MessageId),
+		Body: aws.StringValue(m.Body),
+	}
+	req.Traceparent, req.AWSTraceHeader = traceHeaders(ctx, m)
+	for k, v := range m.MessageAttributes {
+		if req.Attributes == nil {
+			req.Attributes = map[string]*AttributeValue{}
+		}
+		req.Attributes[k] = NewAttributeValue(v)
+	}
+	return req
+}
+
+// coproc is a running co-process.
+type coproc struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	lines chan []byte
+	count int
+
+	exited chan struct{}
+	err    error
+}
+
+// read reads lines from STDOUT of the co-process, until EOF.
+func (c *coproc) read(r io.ReadCloser) {
+	defer r.Close()
+	defer close(c.lines)
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		c.lines <- b
+	}
+}
+
+// call sends a request and waits its response.
+func (c *coproc) call(ctx context.Context, req *CoprocessRequest) (*CoprocessResponse, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c.stdin.Write(append(b, '\n'))
+		errCh <- err
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case err := <-errCh:
+			if err != nil {
+				return nil, fmt.Errorf("failed to write request: %s", err)
+			}
+		case line, ok := <-c.lines:
+			if !ok {
+				<-c.exited
+				return nil, fmt.Errorf("co-process exited: %s", c.exitError())
+			}
+			var res CoprocessResponse
+			err := json.Unmarshal(line, &res)
+			if err != nil {
+				return nil, fmt.Errorf("invalid response of co-process: %s", err)
+			}
+			if res.ID != req.ID {
+				return nil, fmt.Errorf("unexpected ID in response of co-process: %s", res.ID)
+			}
+			return &res, nil
+		}
+	}
+}
+
+func (c *coproc) hasExited() bool {
+	select {
+	case <-c.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *coproc) exitError() error {
+	if c.err == nil {
+		return fmt.Errorf("exit status 0")
+	}
+	return c.err
+}
+
+func (c *coproc) wait(d time.Duration) bool {
+	tm := time.NewTimer(d)
+	defer tm.Stop()
+	select {
+	case <-c.exited:
+		return true
+	case <-tm.C:
+		return false
+	}
+}
+
+// stop closes STDIN of the co-process to let it exit, and kills it when it
+// doesn't exit in grace period.
+func (c *coproc) stop(grace time.Duration) {
+	c.stdin.Close()
+	if grace > 0 && c.wait(grace) {
+		c.discard()
+		return
+	}
+	c.kill(grace)
+}
+
+// kill stops a process group of the co-process, in same way of groupKiller.
+func (c *coproc) kill(grace time.Duration) {
+	if grace <= 0 || terminateGroup(c.cmd.Process) != nil || !c.wait(grace) {
+		killGroup(c.cmd.Process)
+		<-c.exited
+	}
+	c.discard()
+}
+
+// discard drops lines which are not read, to end the reader.
+func (c *coproc) discard() {
+	go func() {
+		for range c.lines {
+		}
+	}()
+}
@@ -0,0 +1,211 @@
+package sqsnotify2
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/koron/sqs-notify/sqsnotify2/sqstest"
+	"github.com/koron/sqs-notify/sqsnotify2/stage"
+)
+
+// helperCoprocess acts as a co-process by the body of requests:
+//   - "ok", "fail" and "retry": responds it as result
+//   - "crash": exits with 3 without responses
+//   - "sleep": sleeps 10 seconds
+//   - "pid": appends its PID to the output file, and responds "ok"
+//   - "linger": responds "ok", and sleeps 10 seconds at EOF of STDIN
+func helperCoprocess() {
+	br := bufio.NewReader(os.Stdin)
+	enc := json.NewEncoder(os.Stdout)
+	linger := false
+	for {
+		b, err := br.ReadBytes('\n')
+		if err != nil {
+			if linger {
+				time.Sleep(10 * time.Second)
+			}
+			return
+		}
+		var req CoprocessRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			os.Exit(2)
+		}
+		res := CoprocessResponse{ID: req.ID, Result: req.Body}
+		switch req.Body {
+		case "crash":
+			os.Exit(3)
+		case "sleep":
+			time.Sleep(10 * time.Second)
+		case "pid":
+			f, _ := os.OpenFile(os.Getenv("SQSNOTIFY2_HELPER_OUTPUT"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			fmt.Fprintln(f, os.Getpid())
+			f.Close()
+			res.Result = CoprocessOK
+		case "linger":
+			linger = true
+			res.Result = CoprocessOK
+		case CoprocessFail, CoprocessRetry:
+			res.Error = "by request"
+		}
+		enc.Encode(res)
+	}
+}
+
+func newTestCoprocess(t *testing.T, n int) *SQSNotify {
+	t.Helper()
+	t.Setenv("SQSNOTIFY2_HELPER_INPUT", "coprocess")
+	sn := newTestSQSNotify(t, Succeed)
+	sn.Coprocess = true
+	sn.Workers = n
+	p, err := newCoprocPool(sn, n)
+	if err != nil {
+		t.Fatalf("failed to start co-processes: %v", err)
+	}
+	sn.coproc = p
+	t.Cleanup(p.close)
+	return sn
+}
+
+func TestCoprocessResults(t *testing.T) {
+	sn := newTestCoprocess(t, 1)
+	if err := execTestCmd(t, sn, "ok"); err != nil {
+		t.Errorf("ok failed: %v", err)
+	}
+	if err := execTestCmd(t, sn, "fail"); err == nil || err.Error() != "co-process failed: by request" {
+		t.Errorf("unexpected error for fail: %v", err)
+	}
+	if err := execTestCmd(t, sn, "retry"); !isRetry(err) {
+		t.Errorf("unexpected error for retry: %v", err)
+	}
+}
+
+func TestCoprocessRestart(t *testing.T) {
+	sn := newTestCoprocess(t, 1)
+	if err := execTestCmd(t, sn, "crash"); err == nil || !strings.Contains(err.Error(), "co-process exited") {
+		t.Fatalf("unexpected error for crash: %v", err)
+	}
+	if err := execTestCmd(t, sn, "ok"); err != nil {
+		t.Fatalf("not restarted: %v", err)
+	}
+}
+
+func TestCoprocessTimeout(t *testing.T) {
+	sn := newTestCoprocess(t, 1)
+	sn.Timeout = 100 * time.Millisecond
+	sn.KillGrace = 0
+	if err := execTestCmd(t, sn, "sleep"); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("unexpected error for sleep: %v", err)
+	}
+	if err := execTestCmd(t, sn, "ok"); err != nil {
+		t.Fatalf("not restarted: %v", err)
+	}
+}
+
+func TestCoprocessRecycle(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	t.Setenv("SQSNOTIFY2_HELPER_OUTPUT", out)
+	sn := newTestCoprocess(t, 1)
+	sn.CoprocessRecycle = 2
+	for i := 0; i < 5; i++ {
+		if err := execTestCmd(t, sn, "pid"); err != nil {
+			t.Fatalf("failed to execute: %v", err)
+		}
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	pids := strings.Fields(string(b))
+	if len(pids) != 5 || pids[0] != pids[1] || pids[1] == pids[2] || pids[2] != pids[3] || pids[3] == pids[4] {
+		t.Errorf("unexpected PIDs: %q", pids)
+	}
+}
+
+func TestCoprocessRecycleBackground(t *testing.T) {
+	sn := newTestCoprocess(t, 1)
+	sn.CoprocessRecycle = 1
+	sn.KillGrace = time.Second
+	start := time.Now()
+	if err := execTestCmd(t, sn, "linger"); err != nil {
+		t.Fatalf("failed to execute: %v", err)
+	}
+	if err := execTestCmd(t, sn, "ok"); err != nil {
+		t.Fatalf("failed to execute after recycle: %v", err)
+	}
+	if d := time.Since(start); d >= sn.KillGrace {
+		t.Errorf("blocked by stopping recycled co-process: %s", d)
+	}
+}
+
+func TestCoprocessInputMode(t *testing.T) {
+	t.Setenv("SQSNOTIFY2_HELPER_INPUT", "coprocess")
+	for _, mode := range []InputMode{InputFile, InputArg, InputEnv} {
+		sn := newTestSQSNotify(t, Succeed)
+		sn.Coprocess = true
+		sn.InputMode = mode
+		p, err := newCoprocPool(sn, 1)
+		if err != nil {
+			t.Fatalf("failed to start co-processes for %s: %v", mode, err)
+		}
+		sn.coproc = p
+		err = execTestCmd(t, sn, "ok")
+		p.close()
+		if err != nil {
+			t.Errorf("failed to execute for %s: %v", mode, err)
+		}
+	}
+}
+
+func TestRunCoprocess(t *testing.T) {
+	t.Setenv("SQSNOTIFY2_HELPER_INPUT", "coprocess")
+	api := sqstest.New()
+	sendBodies(t, api, "ok", "fail", "retry")
+	sn := newTestSQSNotify(t, IgnoreFailure)
+	sn.Coprocess = true
+	sn.Workers = 2
+	ids := []string{
+		"00000000-0000-0000-0000-000000000001",
+		"00000000-0000-0000-0000-000000000002",
+		"00000000-0000-0000-0000-000000000003",
+	}
+	// "retry" is received again and again, because it is never deleted.
+	runUntil(t, sn, api, func() bool {
+		return api.ReceiveCounts("test")[ids[2]] >= 3
+	})
+	if stg := stageOf(t, sn.cache, ids[0]); stg != stage.Done {
+		t.Errorf("unexpected stage for ok: %s", stg)
+	}
+	if stg := stageOf(t, sn.cache, ids[1]); stg != stage.Exec {
+		t.Errorf("unexpected stage for fail: %s", stg)
+	}
+	if sn.coproc != nil {
+		t.Error("co-processes should be stopped")
+	}
+}
+
+func TestCoprocessRequest(t *testing.T) {
+	req := newCoprocessRequest(context.Background(), &sqs.Message{
+		MessageId: aws.String("id1"),
+		Body:      aws.String("hello"),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"type": {DataType: aws.String("String"), StringValue: aws.String("invoice")},
+		},
+	})
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	want := `{"id":"id1","body":"hello","attributes":{"type":{"dataType":"String","stringValue":"invoice"}}}`
+	if string(b) != want {
+		t.Errorf("unexpected request:\n got=%s\nwant=%s", b, want)
+	}
+}
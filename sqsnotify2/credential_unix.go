@@ -0,0 +1,84 @@
+//go:build !windows
+// +build !windows
+
+package sqsnotify2
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+type credential = syscall.Credential
+
+// lookupCredential looks up a user and a group by names or IDs.  The primary
+// group of the user is used when group is empty.  It returns nil when both
+// are empty.
+func lookupCredential(userName, groupName string) (*credential, error) {
+	if userName == "" && groupName == "" {
+		return nil, nil
+	}
+	uid, gid := uint32(syscall.Getuid()), uint32(syscall.Getgid())
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			u, err = user.LookupId(userName)
+			if err != nil {
+				return nil, fmt.Errorf("unknown user: %s", userName)
+			}
+		}
+		uid, err = parseID(u.Uid)
+		if err != nil {
+			return nil, err
+		}
+		gid, err = parseID(u.Gid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			g, err = user.LookupGroupId(groupName)
+			if err != nil {
+				return nil, fmt.Errorf("unknown group: %s", groupName)
+			}
+		}
+		gid, err = parseID(g.Gid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &credential{Uid: uid, Gid: gid}, nil
+}
+
+func parseID(s string) (uint32, error) {
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ID: %s", s)
+	}
+	return uint32(n), nil
+}
+
+// applyCredential makes a command run as a user and a group.
+func applyCredential(cmd *exec.Cmd, cred *credential) {
+	if cred == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = cred
+}
+
+// chownCredential changes owner of a file to the user and the group, so
+// commands can read it.
+func chownCredential(name string, cred *credential) error {
+	if cred == nil {
+		return nil
+	}
+	return os.Chown(name, int(cred.Uid), int(cred.Gid))
+}
@@ -0,0 +1,27 @@
+//go:build windows
+// +build windows
+
+package sqsnotify2
+
+import (
+	"errors"
+	"os/exec"
+)
+
+type credential struct{}
+
+// lookupCredential fails when a user or a group is given, Windows doesn't
+// support them.
+func lookupCredential(userName, groupName string) (*credential, error) {
+	if userName == "" && groupName == "" {
+		return nil, nil
+	}
+	return nil, errors.New("user and group are not supported on Windows")
+}
+
+func applyCredential(cmd *exec.Cmd, cred *credential) {
+}
+
+func chownCredential(name string, cred *credential) error {
+	return nil
+}
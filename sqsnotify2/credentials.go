@@ -0,0 +1,78 @@
+package sqsnotify2
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// roleExpiryWindow is a window to refresh assumed credentials before they
+// expire.
+const roleExpiryWindow = time.Minute
+
+// maxRoleSessionName is max length of a session name, which is limited by
+// STS.
+const maxRoleSessionName = 64
+
+// roleSessionName returns a session name for an assumed role.
+func roleSessionName(cfg *Config) string {
+	if cfg.RoleSessionName != "" {
+		return cfg.RoleSessionName
+	}
+	host, _ := os.Hostname()
+	return defaultRoleSessionName(host, os.Getpid())
+}
+
+// defaultRoleSessionName returns "sqs-notify2-{host}-{pid}".  host is
+// truncated to keep the name in maxRoleSessionName.
+func defaultRoleSessionName(host string, pid int) string {
+	prefix, suffix := "sqs-notify2-", fmt.Sprintf("-%d", pid)
+	if n := maxRoleSessionName - len(prefix) - len(suffix); len(host) > n {
+		host = host[:n]
+	}
+	return prefix + host + suffix
+}
+
+// newRoleCredentials creates credentials which assume a role by STS.  It
+// returns nil when no roles are configured.  Credentials are refreshed
+// automatically before they expire.
+func newRoleCredentials(s *session.Session, cfg *Config) (*credentials.Credentials, error) {
+	if cfg.RoleARN == "" {
+		if cfg.WebIdentityTokenFile != "" || cfg.ExternalID != "" {
+			return nil, errors.New("role ARN is required to assume a role")
+		}
+		return nil, nil
+	}
+	stsCfg := aws.NewConfig()
+	if cfg.Region != "" {
+		stsCfg.WithRegion(cfg.Region)
+	}
+	if cfg.STSEndpoint != "" {
+		stsCfg.WithEndpoint(cfg.STSEndpoint)
+	}
+	svc := sts.New(s, stsCfg)
+	name := roleSessionName(cfg)
+
+	if cfg.WebIdentityTokenFile != "" {
+		p := stscreds.NewWebIdentityRoleProviderWithOptions(svc, cfg.RoleARN, name,
+			stscreds.FetchTokenPath(cfg.WebIdentityTokenFile),
+			func(p *stscreds.WebIdentityRoleProvider) {
+				p.ExpiryWindow = roleExpiryWindow
+			})
+		return credentials.NewCredentials(p), nil
+	}
+	return stscreds.NewCredentialsWithClient(svc, cfg.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = name
+		if cfg.ExternalID != "" {
+			p.ExternalID = aws.String(cfg.ExternalID)
+		}
+		p.ExpiryWindow = roleExpiryWindow
+	}), nil
+}
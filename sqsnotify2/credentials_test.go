@@ -0,0 +1,100 @@
+package sqsnotify2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/koron/sqs-notify/sqsnotify2/sqstest"
+)
+
+// testRoleCredentials checks requests for SQS are signed by credentials
+// which are issued by the stand-in of STS.
+func testRoleCredentials(t *testing.T, cfg *Config) {
+	t.Helper()
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIABASE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "base-secret")
+	var (
+		mu   sync.Mutex
+		auth []string
+	)
+	h := sqstest.NewServer(sqstest.New())
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "" {
+			mu.Lock()
+			auth = append(auth, r.Header.Get("Authorization"))
+			mu.Unlock()
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	cfg.Endpoint = ts.URL
+	cfg.STSEndpoint = ts.URL
+	api, err := NewSQS(cfg)
+	if err != nil {
+		t.Fatalf("failed to create SQS: %v", err)
+	}
+	_, err = GetQueueURL(context.Background(), api, "test")
+	if !isQueueDoesNotExist(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(auth) != 1 || !strings.Contains(auth[0], "Credential=ASIASQSTEST") {
+		t.Fatalf("not signed by assumed credentials: %q", auth)
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	cfg := NewConfig()
+	cfg.RoleARN = "arn:aws:iam::123456789012:role/consumer"
+	cfg.ExternalID = "ext-id"
+	testRoleCredentials(t, cfg)
+}
+
+func TestAssumeRoleWithWebIdentity(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(name, []byte("dummy-token"), 0600); err != nil {
+		t.Fatalf("failed to write token: %v", err)
+	}
+	cfg := NewConfig()
+	cfg.RoleARN = "arn:aws:iam::123456789012:role/consumer"
+	cfg.WebIdentityTokenFile = name
+	testRoleCredentials(t, cfg)
+}
+
+func TestRoleCredentialsWithoutARN(t *testing.T) {
+	cfg := NewConfig()
+	cfg.WebIdentityTokenFile = "token"
+	_, err := NewSQS(cfg)
+	if err == nil {
+		t.Fatal("should fail without role ARN")
+	}
+}
+
+func TestDefaultRoleSessionName(t *testing.T) {
+	for _, c := range []struct {
+		host string
+		want string
+	}{
+		{"myhost", "sqs-notify2-myhost-12345"},
+		{strings.Repeat("h", 46), "sqs-notify2-" + strings.Repeat("h", 46) + "-12345"},
+		{strings.Repeat("h", 47), "sqs-notify2-" + strings.Repeat("h", 46) + "-12345"},
+		{"ip-10-0-0-1.ap-northeast-1.compute.internal.example.com",
+			"sqs-notify2-ip-10-0-0-1.ap-northeast-1.compute.internal.ex-12345"},
+	} {
+		got := defaultRoleSessionName(c.host, 12345)
+		if got != c.want {
+			t.Errorf("unexpected name for %q: got=%s want=%s", c.host, got, c.want)
+		}
+		if len(got) > maxRoleSessionName {
+			t.Errorf("too long name for %q: %d", c.host, len(got))
+		}
+	}
+}
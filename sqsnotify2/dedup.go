@@ -0,0 +1,118 @@
+package sqsnotify2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	dedupMessageID  = "message-id"
+	dedupBodySHA256 = "body-sha256"
+	dedupJSONPrefix = "json:"
+	dedupAttrPrefix = "attr:"
+)
+
+// dedupKey extracts a key for Cache from a message.
+type dedupKey struct {
+	kind string
+	// path is a path of JSON field, used with dedupJSONPrefix.
+	path []string
+	// name is a name of message attribute, used with dedupAttrPrefix.
+	name string
+}
+
+func parseDedupKey(s string) (*dedupKey, error) {
+	switch {
+	case s == "", s == dedupMessageID:
+		return &dedupKey{kind: dedupMessageID}, nil
+	case s == dedupBodySHA256:
+		return &dedupKey{kind: dedupBodySHA256}, nil
+	case strings.HasPrefix(s, dedupJSONPrefix):
+		p := s[len(dedupJSONPrefix):]
+		if p == "" {
+			return nil, fmt.Errorf("empty JSON path in dedup key: %s", s)
+		}
+		return &dedupKey{kind: dedupJSONPrefix, path: strings.Split(p, ".")}, nil
+	case strings.HasPrefix(s, dedupAttrPrefix):
+		n := s[len(dedupAttrPrefix):]
+		if n == "" {
+			return nil, fmt.Errorf("empty attribute name in dedup key: %s", s)
+		}
+		return &dedupKey{kind: dedupAttrPrefix, name: n}, nil
+	}
+	return nil, fmt.Errorf("unknown dedup key: %s", s)
+}
+
+// attributeName returns a name of message attribute to be received.
+func (dk *dedupKey) attributeName() (string, bool) {
+	if dk.kind != dedupAttrPrefix {
+		return "", false
+	}
+	return dk.name, true
+}
+
+func (dk *dedupKey) key(m *sqs.Message) (string, error) {
+	switch dk.kind {
+	case dedupBodySHA256:
+		if m.Body == nil {
+			return "", errors.New("no body for dedup key")
+		}
+		h := sha256.Sum256([]byte(*m.Body))
+		return hex.EncodeToString(h[:]), nil
+	case dedupJSONPrefix:
+		return dk.jsonKey(m)
+	case dedupAttrPrefix:
+		v, ok := m.MessageAttributes[dk.name]
+		if !ok || v == nil {
+			return "", fmt.Errorf("no message attribute for dedup key: %s", dk.name)
+		}
+		if v.StringValue != nil {
+			return *v.StringValue, nil
+		}
+		if v.BinaryValue != nil {
+			return hex.EncodeToString(v.BinaryValue), nil
+		}
+		return "", fmt.Errorf("empty message attribute for dedup key: %s", dk.name)
+	default:
+		return *m.MessageId, nil
+	}
+}
+
+func (dk *dedupKey) jsonKey(m *sqs.Message) (string, error) {
+	if m.Body == nil {
+		return "", errors.New("no body for dedup key")
+	}
+	var v interface{}
+	err := json.Unmarshal([]byte(*m.Body), &v)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse body as JSON for dedup key: %s", err)
+	}
+	for _, p := range dk.path {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("no JSON field for dedup key: %s", strings.Join(dk.path, "."))
+		}
+		v, ok = obj[p]
+		if !ok {
+			return "", fmt.Errorf("no JSON field for dedup key: %s", strings.Join(dk.path, "."))
+		}
+	}
+	switch w := v.(type) {
+	case nil:
+		return "", fmt.Errorf("null JSON field for dedup key: %s", strings.Join(dk.path, "."))
+	case string:
+		return w, nil
+	default:
+		b, err := json.Marshal(w)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
@@ -0,0 +1,63 @@
+package sqsnotify2
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestDedupKey(t *testing.T) {
+	m := &sqs.Message{
+		MessageId: aws.String("id-0001"),
+		Body:      aws.String(`{"order":{"id":"A-123","num":42}}`),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"IdempotencyKey": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String("key-0001"),
+			},
+		},
+	}
+	for _, tc := range []struct {
+		spec string
+		want string
+	}{
+		{"", "id-0001"},
+		{"message-id", "id-0001"},
+		{"body-sha256", "9dd9e4bbe7fde565005d15ddd0567c7b3e4921f240fe948f4bfe19f0eb4b40f5"},
+		{"json:order.id", "A-123"},
+		{"json:order.num", "42"},
+		{"json:order", `{"id":"A-123","num":42}`},
+		{"attr:IdempotencyKey", "key-0001"},
+	} {
+		dk, err := parseDedupKey(tc.spec)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", tc.spec, err)
+		}
+		got, err := dk.key(m)
+		if err != nil {
+			t.Fatalf("failed to get key for %q: %v", tc.spec, err)
+		}
+		if got != tc.want {
+			t.Errorf("unexpected key for %q: want=%s got=%s", tc.spec, tc.want, got)
+		}
+	}
+
+	for _, spec := range []string{"json:order.none", "json:order.id.x", "attr:None"} {
+		dk, err := parseDedupKey(spec)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", spec, err)
+		}
+		_, err = dk.key(m)
+		if err == nil {
+			t.Errorf("key for %q should fail", spec)
+		}
+	}
+
+	for _, spec := range []string{"json:", "attr:", "unknown"} {
+		_, err := parseDedupKey(spec)
+		if err == nil {
+			t.Errorf("parse %q should fail", spec)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package sqsnotify2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// dryRun shows what would run for messages, and makes them visible again.
+func (sn *SQSNotify) dryRun(ctx context.Context, api sqsiface.SQSAPI, queueURL *string, msgs []*sqs.Message) error {
+	w := sn.DryRunOutput
+	if w == nil {
+		w = os.Stdout
+	}
+	for _, m := range msgs {
+		sn.showPlan(w, m)
+		err := changeVisibility(ctx, api, queueURL, m.ReceiptHandle, 0)
+		if err != nil {
+			return fmt.Errorf("failed to reset visibility: id=%s err=%s", *m.MessageId, err)
+		}
+	}
+	return nil
+}
+
+// showPlan writes what would run for a message.
+func (sn *SQSNotify) showPlan(w io.Writer, m *sqs.Message) {
+	fmt.Fprintf(w, "DRY_RUN\tid:%s\n", *m.MessageId)
+
+	cmd := sn.newCmd(context.Background(), m, FilePlaceholder)
+	path := cmd.Path
+	if cmd.Err != nil {
+		path = fmt.Sprintf("%s (%s)", sn.CmdName, cmd.Err)
+	}
+	fmt.Fprintf(w, "  command:  %s\n", path)
+	fmt.Fprintf(w, "  args:     %q\n", cmd.Args[1:])
+	if sn.Coprocess {
+		fmt.Fprintf(w, "  input:    Coprocess\n")
+	} else if sn.InputMode != InputStdin {
+		fmt.Fprintf(w, "  input:    %s\n", sn.InputMode)
+	}
+	if cmd.Dir != "" {
+		fmt.Fprintf(w, "  dir:      %s\n", cmd.Dir)
+	}
+	switch {
+	case sn.CleanEnv:
+		fmt.Fprintf(w, "  env:      %q\n", cmd.Env)
+	case len(sn.Env) > 0:
+		fmt.Fprintf(w, "  env:      (inherited) + %q\n", sn.Env)
+	default:
+		fmt.Fprintf(w, "  env:      (inherited)\n")
+	}
+	if sn.User != "" || sn.Group != "" {
+		fmt.Fprintf(w, "  user:     %s:%s\n", sn.User, sn.Group)
+	}
+
+	fmt.Fprintf(w, "  decision: %s\n", sn.decide(m))
+
+	for _, k := range sortedKeys(m.Attributes) {
+		fmt.Fprintf(w, "  attr:     %s=%s\n", k, *m.Attributes[k])
+	}
+	for _, k := range sortedKeys(m.MessageAttributes) {
+		v := m.MessageAttributes[k]
+		if v.StringValue != nil {
+			fmt.Fprintf(w, "  msgattr:  %s=%s\n", k, *v.StringValue)
+		} else {
+			fmt.Fprintf(w, "  msgattr:  %s=(%s)\n", k, *v.DataType)
+		}
+	}
+	fmt.Fprintf(w, "  body:     %s\n", decodeBody(*m.Body))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// decide describes the decision for a message, without touching cache.
+func (sn *SQSNotify) decide(m *sqs.Message) string {
+	if !sn.flt.match(m) {
+		return fmt.Sprintf("filter out (filter-action:%s)", sn.FilterAction)
+	}
+	key, err := sn.dk.key(m)
+	if err != nil {
+		return fmt.Sprintf("not execute (%s)", err)
+	}
+	item, err := sn.cache.Get(key)
+	if err != nil {
+		return fmt.Sprintf("unknown (key:%s, %s)", key, err)
+	}
+	if item != nil {
+		return fmt.Sprintf("skip (key:%s, found in cache at stage %s)", key, item.Stage)
+	}
+	return fmt.Sprintf("execute (key:%s, remove-policy:%s)", key, sn.RemovePolicy)
+}
+
+// decodeBody formats a body for display.  JSON is indented.
+func decodeBody(s string) string {
+	var b bytes.Buffer
+	if json.Valid([]byte(s)) && json.Indent(&b, []byte(s), "  ", "  ") == nil {
+		return b.String()
+	}
+	return fmt.Sprintf("%q", s)
+}
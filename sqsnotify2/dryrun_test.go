@@ -0,0 +1,86 @@
+package sqsnotify2
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/koron/sqs-notify/sqsnotify2/sqstest"
+	"github.com/koron/sqs-notify/sqsnotify2/stage"
+)
+
+func TestShowPlan(t *testing.T) {
+	sn := New(&Config{CmdName: "echo", CmdArgs: []string{"foo"}})
+	sn.cache = newMemoryCache(minCapacity)
+	sn.dk, _ = parseDedupKey("")
+	if err := sn.cache.Insert("id-0002", stage.Done); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	var b bytes.Buffer
+	sn.showPlan(&b, &sqs.Message{
+		MessageId: aws.String("id-0001"),
+		Body:      aws.String(`{"a":1}`),
+	})
+	sn.showPlan(&b, &sqs.Message{
+		MessageId: aws.String("id-0002"),
+		Body:      aws.String("hello"),
+		Attributes: map[string]*string{
+			"SentTimestamp":           aws.String("1700000000000"),
+			"ApproximateReceiveCount": aws.String("1"),
+		},
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"z": {DataType: aws.String("String"), StringValue: aws.String("last")},
+			"a": {DataType: aws.String("Binary"), BinaryValue: []byte{1}},
+			"m": {DataType: aws.String("Number"), StringValue: aws.String("1")},
+		},
+	})
+	out := b.String()
+	for _, s := range []string{
+		"DRY_RUN\tid:id-0001\n",
+		`  args:     ["foo"]`,
+		"  decision: execute (key:id-0001, remove-policy:Succeed)\n",
+		"  body:     {\n    \"a\": 1\n  }\n",
+		"DRY_RUN\tid:id-0002\n",
+		"  decision: skip (key:id-0002, found in cache at stage Done)\n" +
+			"  attr:     ApproximateReceiveCount=1\n" +
+			"  attr:     SentTimestamp=1700000000000\n" +
+			"  msgattr:  a=(Binary)\n" +
+			"  msgattr:  m=1\n" +
+			"  msgattr:  z=last\n",
+		"  body:     \"hello\"\n",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("output doesn't contain %q:\n%s", s, out)
+		}
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	api := sqstest.New()
+	sendBodies(t, api)
+	sendTyped(t, api, "hello", "greeting")
+	sn := newTestSQSNotify(t, Succeed)
+	sn.DryRun = true
+	var b bytes.Buffer
+	sn.DryRunOutput = &b
+	if err := sn.run(context.Background(), api); err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	// attributes are shown without options which request them.
+	out := b.String()
+	for _, s := range []string{
+		"  attr:     ApproximateReceiveCount=1\n",
+		"  msgattr:  type=greeting\n",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("output doesn't contain %q:\n%s", s, out)
+		}
+	}
+	if st := api.Stats("test"); st != (sqstest.Stats{Visible: 1}) {
+		t.Errorf("message should be visible again: %+v", st)
+	}
+}
@@ -0,0 +1,43 @@
+package sqsnotify2
+
+import (
+	"os"
+	"time"
+)
+
+// Rlimits is limits of resources for commands.  Zero means "not limited".
+// It is supported on Linux only.
+type Rlimits struct {
+	// CPU is max CPU time, truncated to seconds.
+	CPU time.Duration
+	// AddressSpace is max size of virtual memory in bytes.
+	AddressSpace int64
+	// OpenFiles is max number of open files.
+	OpenFiles int64
+}
+
+func (rl Rlimits) isZero() bool {
+	return rl == Rlimits{}
+}
+
+// cmdEnv returns environment variables for commands.  nil means "inherit
+// all from sqs-notify2".
+func (sn *SQSNotify) cmdEnv() []string {
+	if sn.CleanEnv {
+		return append([]string{}, sn.Env...)
+	}
+	if len(sn.Env) == 0 {
+		return nil
+	}
+	return append(os.Environ(), sn.Env...)
+}
+
+// prepareExec checks and prepares settings for environment of commands.
+func (sn *SQSNotify) prepareExec() error {
+	cred, err := lookupCredential(sn.User, sn.Group)
+	if err != nil {
+		return err
+	}
+	sn.cred = cred
+	return checkRlimits(sn.Rlimits)
+}
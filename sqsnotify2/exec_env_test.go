@@ -0,0 +1,52 @@
+package sqsnotify2
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCmdEnv(t *testing.T) {
+	sn := New(&Config{})
+	if env := sn.cmdEnv(); env != nil {
+		t.Errorf("env should be inherited: %q", env)
+	}
+	sn.Env = []string{"FOO=bar"}
+	if env := sn.cmdEnv(); len(env) != len(os.Environ())+1 || env[len(env)-1] != "FOO=bar" {
+		t.Errorf("unexpected env with extras: %q", env)
+	}
+	sn.CleanEnv = true
+	if env := sn.cmdEnv(); len(env) != 1 || env[0] != "FOO=bar" {
+		t.Errorf("unexpected clean env: %q", env)
+	}
+	sn.Env = nil
+	if env := sn.cmdEnv(); env == nil || len(env) != 0 {
+		t.Errorf("clean env should be empty: %q", env)
+	}
+}
+
+func TestExecCmdEnv(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	t.Setenv("SQSNOTIFY2_HELPER_OUTPUT", out)
+	dir := t.TempDir()
+	sn := newTestSQSNotify(t, Succeed)
+	sn.Dir = dir
+	sn.Env = []string{"SQSNOTIFY2_HELPER_VALUE=foo"}
+	if err := sn.prepareExec(); err != nil {
+		t.Fatalf("failed to prepare: %v", err)
+	}
+	if cmd := sn.newCmd(context.Background(), nil, ""); cmd.Dir != dir {
+		t.Errorf("unexpected dir: %s", cmd.Dir)
+	}
+	if err := execTestCmd(t, sn, "env"); err != nil {
+		t.Fatalf("failed to execute: %v", err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if string(b) != "foo" {
+		t.Errorf("unexpected value: %q", b)
+	}
+}
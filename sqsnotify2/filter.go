@@ -0,0 +1,616 @@
+package sqsnotify2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// FilterAction is an action for messages which don't match Filter.
+type FilterAction int
+
+const (
+	// FilterLeave means "leave messages in SQS for other consumers"
+	FilterLeave FilterAction = 0
+	// FilterDelete means "delete messages"
+	FilterDelete = 1
+	// FilterMove means "send messages to FilterQueue, and delete them"
+	FilterMove = 2
+)
+
+func (fa FilterAction) String() string {
+	switch fa {
+	case FilterLeave:
+		return "Leave"
+	case FilterDelete:
+		return "Delete"
+	case FilterMove:
+		return "Move"
+	default:
+		return "Unknown"
+	}
+}
+
+// filter is a compiled filter expression.  Grammar:
+//
+//	expr    = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | compare
+//	compare = primary [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) primary ]
+//	primary = "(" expr ")" | STRING | NUMBER | "true" | "false" | "null" | path
+//	path    = ( "body" | "attr" ) { "." NAME | "[" ( STRING | INTEGER ) "]" }
+//
+// "body" is the body as a string, and "body.NAME" is a field of the body as
+// JSON.  "attr.NAME" is a message attribute, a number for Number types.
+// Missing values are null.
+type filter struct {
+	src   string
+	root  filterNode
+	attrs []string
+}
+
+// filterEnv is a message to evaluate a filter.
+type filterEnv struct {
+	m      *sqs.Message
+	parsed bool
+	body   interface{}
+}
+
+// filterNode is a node of filter expression, which evaluates to nil, bool,
+// float64, string, or JSON object and array.
+type filterNode interface {
+	eval(env *filterEnv) interface{}
+}
+
+func parseFilter(s string) (*filter, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	p := &filterParser{src: s}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return &filter{src: s, root: root, attrs: p.attrs}, nil
+}
+
+// ValidateFilter checks a filter expression.
+func ValidateFilter(s string) error {
+	_, err := parseFilter(s)
+	return err
+}
+
+// attributeNames returns names of message attributes used in the filter.
+func (f *filter) attributeNames() []string {
+	if f == nil {
+		return nil
+	}
+	return f.attrs
+}
+
+// match returns true when a message matches the filter.  A nil filter
+// matches all messages.
+func (f *filter) match(m *sqs.Message) bool {
+	if f == nil {
+		return true
+	}
+	return truthy(f.root.eval(&filterEnv{m: m}))
+}
+
+func (env *filterEnv) jsonBody() interface{} {
+	if !env.parsed {
+		env.parsed = true
+		var v interface{}
+		if json.Unmarshal([]byte(aws.StringValue(env.m.Body)), &v) == nil {
+			env.body = v
+		}
+	}
+	return env.body
+}
+
+func truthy(v interface{}) bool {
+	switch w := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return w
+	case float64:
+		return w != 0
+	case string:
+		return w != ""
+	default:
+		return true
+	}
+}
+
+type literalNode struct {
+	v interface{}
+}
+
+func (n *literalNode) eval(*filterEnv) interface{} {
+	return n.v
+}
+
+type pathNode struct {
+	root string
+	path []interface{}
+}
+
+func (n *pathNode) eval(env *filterEnv) interface{} {
+	var v interface{}
+	path := n.path
+	switch n.root {
+	case "body":
+		if len(path) == 0 {
+			return aws.StringValue(env.m.Body)
+		}
+		v = env.jsonBody()
+	case "attr":
+		if len(path) == 0 {
+			return nil
+		}
+		name, _ := path[0].(string)
+		v = attrValueOf(env.m.MessageAttributes[name])
+		path = path[1:]
+	}
+	for _, p := range path {
+		switch k := p.(type) {
+		case string:
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = obj[k]
+		case int:
+			arr, ok := v.([]interface{})
+			if !ok || k < 0 || k >= len(arr) {
+				return nil
+			}
+			v = arr[k]
+		}
+	}
+	return v
+}
+
+func attrValueOf(v *sqs.MessageAttributeValue) interface{} {
+	if v == nil || v.StringValue == nil {
+		return nil
+	}
+	if strings.HasPrefix(aws.StringValue(v.DataType), "Number") {
+		if f, err := strconv.ParseFloat(*v.StringValue, 64); err == nil {
+			return f
+		}
+	}
+	return *v.StringValue
+}
+
+type notNode struct {
+	x filterNode
+}
+
+func (n *notNode) eval(env *filterEnv) interface{} {
+	return !truthy(n.x.eval(env))
+}
+
+type logicalNode struct {
+	and  bool
+	l, r filterNode
+}
+
+func (n *logicalNode) eval(env *filterEnv) interface{} {
+	if truthy(n.l.eval(env)) != n.and {
+		return !n.and
+	}
+	return truthy(n.r.eval(env))
+}
+
+type compareNode struct {
+	op   string
+	l, r filterNode
+}
+
+func (n *compareNode) eval(env *filterEnv) interface{} {
+	l, r := n.l.eval(env), n.r.eval(env)
+	switch n.op {
+	case "==":
+		return equal(l, r)
+	case "!=":
+		return !equal(l, r)
+	}
+	var c int
+	switch a := l.(type) {
+	case float64:
+		b, ok := r.(float64)
+		if !ok {
+			return false
+		}
+		c = compareFloat(a, b)
+	case string:
+		b, ok := r.(string)
+		if !ok {
+			return false
+		}
+		c = strings.Compare(a, b)
+	default:
+		return false
+	}
+	switch n.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// equal compares scalar values.  JSON objects and arrays are never equal.
+func equal(a, b interface{}) bool {
+	switch a.(type) {
+	case nil, bool, float64, string:
+	default:
+		return false
+	}
+	switch b.(type) {
+	case nil, bool, float64, string:
+	default:
+		return false
+	}
+	return a == b
+}
+
+// tokens of filter expression.
+const (
+	tokEOF = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type filterToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+type filterParser struct {
+	src   string
+	pos   int
+	tok   filterToken
+	attrs []string
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid filter at %d: %s", p.tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+// next reads a next token.
+func (p *filterParser) next() error {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	start := p.pos
+	p.tok = filterToken{pos: start}
+	if p.pos >= len(p.src) {
+		p.tok.kind = tokEOF
+		return nil
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '_' || isLetter(c):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok.kind = tokIdent
+	case isDigit(c) || (c == '-' && p.pos+1 < len(p.src) && isDigit(p.src[p.pos+1])):
+		p.pos++
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || strings.IndexByte(".eE+-", p.src[p.pos]) >= 0) {
+			if (p.src[p.pos] == '+' || p.src[p.pos] == '-') && !strings.ContainsRune("eE", rune(p.src[p.pos-1])) {
+				break
+			}
+			p.pos++
+		}
+		p.tok.kind = tokNumber
+	case c == '"':
+		p.pos++
+		for p.pos < len(p.src) && p.src[p.pos] != '"' {
+			if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.pos >= len(p.src) {
+			return p.errorf("unterminated string")
+		}
+		p.pos++
+		p.tok.kind = tokString
+	default:
+		p.tok.kind = tokOp
+		for _, op := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", "."} {
+			if strings.HasPrefix(p.src[p.pos:], op) {
+				p.pos += len(op)
+				p.tok.text = op
+				return nil
+			}
+		}
+		return p.errorf("unexpected %q", c)
+	}
+	p.tok.text = p.src[start:p.pos]
+	return nil
+}
+
+func isLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func (p *filterParser) isOp(ops ...string) bool {
+	if p.tok.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if p.tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *filterParser) expect(op string) error {
+	if !p.isOp(op) {
+		return p.errorf("%q expected", op)
+	}
+	return p.next()
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &logicalNode{and: false, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &logicalNode{and: true, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.isOp("!") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{x: x}, nil
+	}
+	l, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if !p.isOp("==", "!=", "<", "<=", ">", ">=") {
+		return l, nil
+	}
+	op := p.tok.text
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	r, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	return &compareNode{op: op, l: l, r: r}, nil
+}
+
+func (p *filterParser) parsePrimary() (filterNode, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokString:
+		s, err := strconv.Unquote(tok.text)
+		if err != nil {
+			return nil, p.errorf("invalid string %s", tok.text)
+		}
+		return &literalNode{v: s}, p.next()
+	case tokNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", tok.text)
+		}
+		return &literalNode{v: f}, p.next()
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{v: true}, p.next()
+		case "false":
+			return &literalNode{v: false}, p.next()
+		case "null":
+			return &literalNode{v: nil}, p.next()
+		case "body", "attr":
+			return p.parsePath()
+		}
+		return nil, p.errorf("unknown name %q, use body or attr", tok.text)
+	case tokOp:
+		if tok.text == "(" {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		}
+	case tokEOF:
+		return nil, p.errorf("unexpected end")
+	}
+	return nil, p.errorf("unexpected %q", tok.text)
+}
+
+func (p *filterParser) parsePath() (filterNode, error) {
+	n := &pathNode{root: p.tok.text}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	for p.isOp(".", "[") {
+		if p.tok.text == "." {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if p.tok.kind != tokIdent {
+				return nil, p.errorf("name expected after \".\"")
+			}
+			n.path = append(n.path, p.tok.text)
+		} else {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			switch p.tok.kind {
+			case tokString:
+				s, err := strconv.Unquote(p.tok.text)
+				if err != nil {
+					return nil, p.errorf("invalid string %s", p.tok.text)
+				}
+				n.path = append(n.path, s)
+			case tokNumber:
+				i, err := strconv.Atoi(p.tok.text)
+				if err != nil || i < 0 {
+					return nil, p.errorf("invalid index %s", p.tok.text)
+				}
+				n.path = append(n.path, i)
+			default:
+				return nil, p.errorf("string or index expected after \"[\"")
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if !p.isOp("]") {
+				return nil, p.errorf("%q expected", "]")
+			}
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if n.root == "attr" && len(n.path) > 0 {
+		if name, ok := n.path[0].(string); ok {
+			p.attrs = append(p.attrs, name)
+		}
+	}
+	return n, nil
+}
+
+// filterMessage returns true when a message matches the filter.  Otherwise
+// it handles the message by FilterAction, and adds a result.
+func (sn *SQSNotify) filterMessage(ctx context.Context, api sqsiface.SQSAPI, queueURL *string, r *result) bool {
+	if sn.flt.match(r.msg) {
+		return true
+	}
+	switch sn.FilterAction {
+	case FilterDelete:
+		sn.addResult(r.withSkip(skipFilterDelete))
+	case FilterMove:
+		err := sn.moveMessage(ctx, api, r.msg)
+		if err != nil {
+			sn.addResult(r.withErr(fmt.Errorf("failed to move filtered message to %s: %s", sn.FilterQueue, err)))
+			break
+		}
+		sn.addResult(r.withSkip(skipFilterMove))
+	default:
+		// make it visible again soon, not to keep it from other consumers
+		// until the visibility timeout.
+		timeout := int64((sn.FilterVisibility + time.Second - 1) / time.Second)
+		err := changeVisibility(ctx, api, queueURL, r.msg.ReceiptHandle, timeout)
+		if err != nil {
+			sn.log().Printf("failed to change visibility: id=%s err=%s", *r.msg.MessageId, err)
+		}
+		sn.addResult(r.withSkip(skipFilterLeave))
+	}
+	return false
+}
+
+// moveMessage sends a copy of a message to FilterQueue.  Message attributes,
+// message group and trace header are kept.
+func (sn *SQSNotify) moveMessage(ctx context.Context, api sqsiface.SQSAPI, m *sqs.Message) error {
+	if sn.fqURL == nil || sn.fqName != sn.FilterQueue {
+		u, err := getQueueURL(ctx, api, sn.FilterQueue, false, nil)
+		if err != nil {
+			return err
+		}
+		sn.fqURL, sn.fqName = u, sn.FilterQueue
+	}
+	in := &sqs.SendMessageInput{
+		QueueUrl:          sn.fqURL,
+		MessageBody:       m.Body,
+		MessageAttributes: m.MessageAttributes,
+	}
+	if v, ok := m.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]; ok {
+		in.MessageGroupId = v
+		in.MessageDeduplicationId = m.MessageId
+	}
+	if v, ok := m.Attributes[sqs.MessageSystemAttributeNameAwstraceHeader]; ok {
+		in.MessageSystemAttributes = map[string]*sqs.MessageSystemAttributeValue{
+			sqs.MessageSystemAttributeNameForSendsAwstraceHeader: {
+				DataType:    aws.String("String"),
+				StringValue: v,
+			},
+		}
+	}
+	_, err := api.SendMessageWithContext(ctx, in)
+	return err
+}
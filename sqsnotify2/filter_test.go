@@ -0,0 +1,166 @@
+package sqsnotify2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/koron/sqs-notify/sqsnotify2/sqstest"
+	"github.com/koron/sqs-notify/sqsnotify2/stage"
+)
+
+func TestFilterMatch(t *testing.T) {
+	m := &sqs.Message{
+		Body: aws.String(`{"amount":120,"customer":{"name":"foo","tags":["vip","new"]},"paid":true,"note":null}`),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"type":     {DataType: aws.String("String"), StringValue: aws.String("invoice")},
+			"priority": {DataType: aws.String("Number"), StringValue: aws.String("3")},
+			"x-tenant": {DataType: aws.String("String"), StringValue: aws.String("acme")},
+		},
+	}
+	for _, d := range []struct {
+		expr string
+		want bool
+	}{
+		{`attr.type == "invoice" && body.amount > 0`, true},
+		{`attr.type == "invoice" && body.amount > 200`, false},
+		{`attr.type == "order" || body.amount >= 120`, true},
+		{`attr.priority < 5`, true},
+		{`attr.priority == "3"`, false},
+		{`attr["x-tenant"] == "acme"`, true},
+		{`attr.missing == null`, true},
+		{`!attr.missing`, true},
+		{`body.customer.name == "foo"`, true},
+		{`body.customer.tags[0] == "vip"`, true},
+		{`body.customer.tags[2] == null`, true},
+		{`body.customer["name"] != "bar"`, true},
+		{`body.paid`, true},
+		{`body.note`, false},
+		{`body.amount > "100"`, false},
+		{`body.customer == body.customer`, false},
+		{`!(body.amount <= 100) && (attr.type == "invoice" || false)`, true},
+		{`body.amount == 1.2e2`, true},
+		{`body.amount > -1`, true},
+		{`body != ""`, true},
+	} {
+		f, err := parseFilter(d.expr)
+		if err != nil {
+			t.Errorf("failed to parse %s: %v", d.expr, err)
+			continue
+		}
+		if got := f.match(m); got != d.want {
+			t.Errorf("unexpected result for %s: %t", d.expr, got)
+		}
+	}
+
+	// a body which isn't JSON
+	f, _ := parseFilter(`body == "ping" && body.x == null`)
+	if !f.match(&sqs.Message{Body: aws.String("ping")}) {
+		t.Error("plain body should match")
+	}
+}
+
+func TestFilterParseError(t *testing.T) {
+	for _, expr := range []string{
+		`attr.type ==`,
+		`attr.type = "invoice"`,
+		`foo == 1`,
+		`(body.a == 1`,
+		`body.a == 1)`,
+		`body.`,
+		`body[-1]`,
+		`body["a"`,
+		`"unterminated`,
+		`body.a == 1 == 2`,
+		`attr.a # 1`,
+	} {
+		if _, err := parseFilter(expr); err == nil {
+			t.Errorf("parse should fail: %s", expr)
+		}
+	}
+	f, err := parseFilter("  ")
+	if err != nil || f != nil {
+		t.Errorf("empty filter should be nil: %v %v", f, err)
+	}
+	if !f.match(&sqs.Message{}) {
+		t.Error("nil filter should match all")
+	}
+}
+
+func TestFilterAttributeNames(t *testing.T) {
+	f, err := parseFilter(`attr.type == "a" || attr["x-y"] == 1 || body.attr == 2`)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if got := f.attributeNames(); len(got) != 2 || got[0] != "type" || got[1] != "x-y" {
+		t.Errorf("unexpected attribute names: %q", got)
+	}
+}
+
+func sendTyped(t *testing.T, api *sqstest.SQS, body, typ string) {
+	t.Helper()
+	_, err := api.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String("test"),
+		MessageBody: aws.String(body),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"type": {DataType: aws.String("String"), StringValue: aws.String(typ)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+}
+
+func TestRunFilter(t *testing.T) {
+	ids := []string{"00000000-0000-0000-0000-000000000001", "00000000-0000-0000-0000-000000000002"}
+	for _, d := range []struct {
+		name       string
+		action     FilterAction
+		visibility time.Duration
+		stats      sqstest.Stats
+		// again means the message which doesn't match is received again.
+		again bool
+	}{
+		{"delete", FilterDelete, 0, sqstest.Stats{}, false},
+		{"leave", FilterLeave, 0, sqstest.Stats{}, true},
+		{"leave_visibility", FilterLeave, time.Hour, sqstest.Stats{InFlight: 1}, false},
+		{"move", FilterMove, 0, sqstest.Stats{}, false},
+	} {
+		t.Run(d.name, func(t *testing.T) {
+			api := sqstest.New()
+			sendBodies(t, api)
+			_, err := api.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String("moved")})
+			if err != nil {
+				t.Fatalf("failed to create queue: %v", err)
+			}
+			sendTyped(t, api, "ok", "invoice")
+			sendTyped(t, api, "ok", "order")
+			sn := newTestSQSNotify(t, Succeed)
+			sn.Filter = `attr.type == "invoice"`
+			sn.FilterAction = d.action
+			sn.FilterQueue = "moved"
+			sn.FilterVisibility = d.visibility
+			runUntil(t, sn, api, func() bool {
+				if stageOf(t, sn.cache, ids[0]) != stage.Done {
+					return false
+				}
+				if d.again {
+					return api.ReceiveCounts("test")[ids[1]] >= 2
+				}
+				return api.Stats("test") == d.stats
+			})
+			// a message which doesn't match isn't inserted to the cache.
+			if stg := stageOf(t, sn.cache, ids[1]); stg != stage.None {
+				t.Errorf("filtered message should not be in cache: %s", stg)
+			}
+			moved := sqstest.Stats{}
+			if d.action == FilterMove {
+				moved.Visible = 1
+			}
+			if st := api.Stats("moved"); st != moved {
+				t.Errorf("unexpected stats of moved: %+v", st)
+			}
+		})
+	}
+}
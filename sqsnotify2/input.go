@@ -0,0 +1,109 @@
+package sqsnotify2
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// InputMode is a way to pass a body of message to a command.
+type InputMode int
+
+const (
+	// InputStdin means "pass a body as STDIN"
+	InputStdin InputMode = 0
+	// InputFile means "write a body to a temporary file and pass its path"
+	InputFile = 1
+	// InputArg means "append a body as the last argument"
+	InputArg = 2
+	// InputEnv means "set a body to SQS_BODY environment variable"
+	InputEnv = 3
+)
+
+func (im InputMode) String() string {
+	switch im {
+	case InputStdin:
+		return "Stdin"
+	case InputFile:
+		return "File"
+	case InputArg:
+		return "Arg"
+	case InputEnv:
+		return "Env"
+	default:
+		return "Unknown"
+	}
+}
+
+// FilePlaceholder in arguments is replaced with path of a temporary file
+// which has a body, for InputFile.  The path is appended as the last argument
+// when no arguments have it.
+const FilePlaceholder = "{file}"
+
+// BodyEnvName is a name of environment variable for InputEnv.
+const BodyEnvName = "SQS_BODY"
+
+// maxArgBody is max size of a body for InputArg and InputEnv.  Most of
+// systems limit size of an argument or an environment variable to 128KiB.
+const maxArgBody = 128*1024 - len(BodyEnvName) - 2
+
+// cmdArgs returns arguments of a command for a message.  path is a file
+// which has the body, for InputFile.
+func (sn *SQSNotify) cmdArgs(m *sqs.Message, path string) []string {
+	switch sn.InputMode {
+	case InputFile:
+		args := make([]string, 0, len(sn.CmdArgs)+1)
+		found := false
+		for _, a := range sn.CmdArgs {
+			if strings.Contains(a, FilePlaceholder) {
+				a = strings.ReplaceAll(a, FilePlaceholder, path)
+				found = true
+			}
+			args = append(args, a)
+		}
+		if !found {
+			args = append(args, path)
+		}
+		return args
+	case InputArg:
+		return append(append([]string{}, sn.CmdArgs...), *m.Body)
+	}
+	return sn.CmdArgs
+}
+
+// checkInput checks a message can be passed by the input mode.
+func (sn *SQSNotify) checkInput(m *sqs.Message) error {
+	switch sn.InputMode {
+	case InputArg, InputEnv:
+		if n := len(*m.Body); n > maxArgBody {
+			return fmt.Errorf("body is too large to pass as argument or environment variable: %d bytes (max %d)", n, maxArgBody)
+		}
+		if strings.IndexByte(*m.Body, 0) >= 0 {
+			return fmt.Errorf("body which has NUL can't be passed as argument or environment variable")
+		}
+	}
+	return nil
+}
+
+// writeBodyFile writes a body to a temporary file, and returns its path.
+// The file should be removed by caller.
+func (sn *SQSNotify) writeBodyFile(m *sqs.Message) (string, error) {
+	f, err := os.CreateTemp("", "sqs-notify2-*.msg")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.WriteString(*m.Body)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = chownCredential(f.Name(), sn.cred)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,72 @@
+package sqsnotify2
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testInputMode(t *testing.T, mode InputMode, name string, args ...string) {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "out")
+	t.Setenv("SQSNOTIFY2_HELPER_OUTPUT", out)
+	t.Setenv("SQSNOTIFY2_HELPER_INPUT", name)
+	sn := newTestSQSNotify(t, Succeed)
+	sn.InputMode = mode
+	sn.CmdArgs = append(sn.CmdArgs, args...)
+	if err := execTestCmd(t, sn, "echo:hello world\n"); err != nil {
+		t.Fatalf("failed to execute: %v", err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if string(b) != "echo:hello world\n" {
+		t.Errorf("unexpected body: %q", b)
+	}
+}
+
+func TestInputStdin(t *testing.T) {
+	testInputMode(t, InputStdin, "stdin")
+}
+
+func TestInputFile(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	testInputMode(t, InputFile, "file")
+	testInputMode(t, InputFile, "file", "--", FilePlaceholder)
+	if ents, _ := os.ReadDir(tmp); len(ents) != 0 {
+		t.Errorf("temporary files are left: %v", ents)
+	}
+}
+
+func TestInputArg(t *testing.T) {
+	testInputMode(t, InputArg, "arg")
+}
+
+func TestInputEnv(t *testing.T) {
+	testInputMode(t, InputEnv, "env")
+}
+
+func TestCmdArgsFile(t *testing.T) {
+	sn := New(&Config{InputMode: InputFile, CmdArgs: []string{"-i", "--in={file}"}})
+	args := sn.cmdArgs(nil, "/tmp/msg")
+	if strings.Join(args, " ") != "-i --in=/tmp/msg" {
+		t.Errorf("unexpected args: %q", args)
+	}
+	sn.CmdArgs = []string{"-i"}
+	args = sn.cmdArgs(nil, "/tmp/msg")
+	if strings.Join(args, " ") != "-i /tmp/msg" {
+		t.Errorf("unexpected args: %q", args)
+	}
+}
+
+func TestInputTooLarge(t *testing.T) {
+	sn := newTestSQSNotify(t, Succeed)
+	sn.InputMode = InputArg
+	err := execTestCmd(t, sn, strings.Repeat("x", maxArgBody+1))
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("should fail with large body: %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+/*
+Package logfile provides a log file which rotates itself by size and age.
+
+Rotated files are renamed to "{NAME}.{YYYYMMDD-hhmmss}", and compressed to
+"{NAME}.{YYYYMMDD-hhmmss}.gz" optionally.  The file can be reopened by
+Reopen, to work with external rotation like logrotate.
+*/
+package logfile
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const timeFormat = "20060102-150405"
+
+// Options configures rotation.  Zero values disable each of them.
+type Options struct {
+	// MaxSize rotates the file before it exceeds the size in bytes.
+	MaxSize int64
+	// MaxAge rotates the file when it gets older than the age, since it was
+	// opened or rotated.
+	MaxAge time.Duration
+	// MaxBackups is max number of rotated files to keep.  Older ones are
+	// removed.
+	MaxBackups int
+	// Compress compresses rotated files by gzip.
+	Compress bool
+
+	// OnError is called with errors of compression and removing rotated
+	// files, which are done in background.
+	OnError func(error)
+}
+
+// Writer is a log file which rotates itself.
+type Writer struct {
+	name string
+	opts Options
+	now  func() time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	closed bool
+
+	bg sync.Mutex
+	wg sync.WaitGroup
+}
+
+// Open opens a log file to append.
+func Open(name string, opts Options) (*Writer, error) {
+	w := &Writer{
+		name: name,
+		opts: opts,
+		now:  time.Now,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.name, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0660)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = fi.Size()
+	w.opened = w.now()
+	return nil
+}
+
+// Write writes data to the file.  It rotates the file before writing if
+// needed.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+	if w.shouldRotate(len(p)) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *Writer) shouldRotate(n int) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.opts.MaxSize > 0 && w.size+int64(n) > w.opts.MaxSize {
+		return true
+	}
+	if w.opts.MaxAge > 0 && w.now().Sub(w.opened) >= w.opts.MaxAge {
+		return true
+	}
+	return false
+}
+
+// Rotate rotates the file now.
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return fs.ErrClosed
+	}
+	return w.rotate()
+}
+
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	backup := w.backupName(w.now())
+	if err := os.Rename(w.name, backup); err != nil {
+		// keep writing to the file, even if it can't be renamed.
+		if err2 := w.open(); err2 != nil {
+			return err2
+		}
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.bg.Lock()
+		defer w.bg.Unlock()
+		if w.opts.Compress {
+			if err := compress(backup); err != nil {
+				w.onError(err)
+			}
+		}
+		if err := w.prune(); err != nil {
+			w.onError(err)
+		}
+	}()
+	return nil
+}
+
+// backupName returns a name of a rotated file, which doesn't exist.
+func (w *Writer) backupName(t time.Time) string {
+	base := w.name + "." + t.Format(timeFormat)
+	name := base
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+	return name
+}
+
+func exists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
+
+// Backups returns rotated files, from older to newer.
+func (w *Writer) Backups() ([]string, error) {
+	dir, base := filepath.Split(w.name)
+	if dir == "" {
+		dir = "."
+	}
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	prefix := base + "."
+	var names []string
+	for _, ent := range ents {
+		n := ent.Name()
+		if !strings.HasPrefix(n, prefix) || ent.IsDir() {
+			continue
+		}
+		s := strings.TrimSuffix(n[len(prefix):], ".gz")
+		if len(s) < len(timeFormat) {
+			continue
+		}
+		if _, err := time.Parse(timeFormat, s[:len(timeFormat)]); err != nil {
+			continue
+		}
+		names = append(names, filepath.Join(filepath.Dir(w.name), n))
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return backupKey(names[i]) < backupKey(names[j])
+	})
+	return names, nil
+}
+
+// backupKey is a key to sort rotated files.  "-N" suffix is padded to sort
+// numerically.
+func backupKey(name string) string {
+	name = strings.TrimSuffix(name, ".gz")
+	if i := strings.LastIndexByte(name, '-'); i >= 0 && len(name)-i-1 < len("150405") {
+		return name[:i] + fmt.Sprintf("-%06s", name[i+1:])
+	}
+	return name
+}
+
+// prune removes old rotated files over MaxBackups.
+func (w *Writer) prune() error {
+	if w.opts.MaxBackups <= 0 {
+		return nil
+	}
+	names, err := w.Backups()
+	if err != nil {
+		return err
+	}
+	for len(names) > w.opts.MaxBackups {
+		if err := os.Remove(names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// compress compresses a file by gzip, and removes the original.
+func compress(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err2 := zw.Close(); err == nil {
+		err = err2
+	}
+	if err2 := dst.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return fmt.Errorf("failed to compress %s: %s", name, err)
+	}
+	src.Close()
+	return os.Remove(name)
+}
+
+func (w *Writer) onError(err error) {
+	if w.opts.OnError != nil {
+		w.opts.OnError(err)
+	}
+}
+
+// Reopen closes the file and reopens it.  It is used after the file is
+// rotated by others.
+func (w *Writer) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return fs.ErrClosed
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+// Close closes the file, and waits compression and removing in background.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return fs.ErrClosed
+	}
+	w.closed = true
+	err := w.file.Close()
+	w.mu.Unlock()
+	w.wg.Wait()
+	return err
+}
@@ -0,0 +1,187 @@
+package logfile
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", name, err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("failed to read gzip %s: %v", name, err)
+		}
+		r = zr
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", name, err)
+	}
+	return string(b)
+}
+
+func write(t *testing.T, w *Writer, s string) {
+	t.Helper()
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+}
+
+func backups(t *testing.T, w *Writer) []string {
+	t.Helper()
+	names, err := w.Backups()
+	if err != nil {
+		t.Fatalf("failed to list backups: %v", err)
+	}
+	return names
+}
+
+// fakeClock returns a Writer.now which advances a second for each call, to
+// make names of rotated files unique.
+func fakeClock() func() time.Time {
+	t := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return func() time.Time {
+		t = t.Add(time.Second)
+		return t
+	}
+}
+
+func openTest(t *testing.T, opts Options) (*Writer, string) {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "test.log")
+	w, err := Open(name, opts)
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	w.now = fakeClock()
+	return w, name
+}
+
+func TestMaxSize(t *testing.T) {
+	w, name := openTest(t, Options{MaxSize: 11})
+	write(t, w, "12345\n")
+	write(t, w, "6789\n")
+	write(t, w, "abcdef\n")
+	write(t, w, "0123456789abcdef\n")
+	write(t, w, "x\n")
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	names := backups(t, w)
+	var got []string
+	for _, n := range names {
+		got = append(got, readFile(t, n))
+	}
+	want := []string{"12345\n6789\n", "abcdef\n", "0123456789abcdef\n"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected backups: got=%q want=%q", got, want)
+	}
+	if s := readFile(t, name); s != "x\n" {
+		t.Fatalf("unexpected current file: %q", s)
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	w, name := openTest(t, Options{MaxAge: time.Hour})
+	write(t, w, "a\n")
+	write(t, w, "b\n")
+	w.opened = w.now().Add(-time.Hour)
+	write(t, w, "c\n")
+	w.Close()
+	names := backups(t, w)
+	if len(names) != 1 {
+		t.Fatalf("unexpected backups: %q", names)
+	}
+	if s := readFile(t, names[0]); s != "a\nb\n" {
+		t.Fatalf("unexpected backup: %q", s)
+	}
+	if s := readFile(t, name); s != "c\n" {
+		t.Fatalf("unexpected current file: %q", s)
+	}
+}
+
+func TestMaxBackupsCompress(t *testing.T) {
+	w, name := openTest(t, Options{MaxSize: 2, MaxBackups: 2, Compress: true})
+	for _, s := range []string{"1\n", "2\n", "3\n", "4\n"} {
+		write(t, w, s)
+	}
+	w.Close()
+	names := backups(t, w)
+	if len(names) != 2 {
+		t.Fatalf("unexpected backups: %q", names)
+	}
+	for i, want := range []string{"2\n", "3\n"} {
+		if !strings.HasSuffix(names[i], ".gz") {
+			t.Fatalf("not compressed: %s", names[i])
+		}
+		if s := readFile(t, names[i]); s != want {
+			t.Fatalf("unexpected backup #%d: got=%q want=%q", i, s, want)
+		}
+	}
+	if s := readFile(t, name); s != "4\n" {
+		t.Fatalf("unexpected current file: %q", s)
+	}
+}
+
+func TestBackupName(t *testing.T) {
+	w, name := openTest(t, Options{})
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	w.now = func() time.Time { return now }
+	for i := 0; i < 11; i++ {
+		write(t, w, "x\n")
+		if err := w.Rotate(); err != nil {
+			t.Fatalf("failed to rotate: %v", err)
+		}
+	}
+	w.Close()
+	names := backups(t, w)
+	if len(names) != 11 {
+		t.Fatalf("unexpected backups: %q", names)
+	}
+	base := name + ".20240102-030405"
+	for i, n := range names {
+		want := base
+		if i > 0 {
+			want = fmt.Sprintf("%s-%d", base, i)
+		}
+		if n != want {
+			t.Fatalf("unexpected order #%d: got=%s want=%s", i, n, want)
+		}
+	}
+}
+
+func TestReopen(t *testing.T) {
+	w, name := openTest(t, Options{MaxSize: 100})
+	write(t, w, "before\n")
+	moved := name + ".old"
+	if err := os.Rename(name, moved); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+	if err := w.Reopen(); err != nil {
+		t.Fatalf("failed to reopen: %v", err)
+	}
+	write(t, w, "after\n")
+	w.Close()
+	if s := readFile(t, moved); s != "before\n" {
+		t.Fatalf("unexpected moved file: %q", s)
+	}
+	if s := readFile(t, name); s != "after\n" {
+		t.Fatalf("unexpected current file: %q", s)
+	}
+	if _, err := w.Write([]byte("closed\n")); err == nil {
+		t.Fatal("write after close should fail")
+	}
+}
@@ -0,0 +1,82 @@
+package sqsnotify2
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// groupKiller stops a process group of a command when its context is done.
+// The group receives SIGTERM first, and SIGKILL after grace period.  SIGKILL
+// is sent even if the command itself has ended by SIGTERM, to stop members of
+// the group which ignore it.
+type groupKiller struct {
+	cmd   *exec.Cmd
+	grace time.Duration
+
+	mu         sync.Mutex
+	terminated bool
+	killed     bool
+}
+
+func newGroupKiller(cmd *exec.Cmd, grace time.Duration) *groupKiller {
+	k := &groupKiller{
+		cmd:   cmd,
+		grace: grace,
+	}
+	setProcessGroup(cmd)
+	cmd.Cancel = k.cancel
+	return k
+}
+
+// cancel is called by exec.Cmd when its context is done.
+func (k *groupKiller) cancel() error {
+	k.mu.Lock()
+	k.terminated = true
+	k.mu.Unlock()
+	if k.grace <= 0 {
+		k.kill()
+		return nil
+	}
+	if err := terminateGroup(k.cmd.Process); err != nil {
+		k.kill()
+		return nil
+	}
+	time.AfterFunc(k.grace, k.kill)
+	return nil
+}
+
+func (k *groupKiller) kill() {
+	k.mu.Lock()
+	k.killed = true
+	k.mu.Unlock()
+	killGroup(k.cmd.Process)
+}
+
+// finish should be called after the command ended.  It returns an error
+// which describes how the command was stopped, or err as is when the command
+// ended normally.
+func (k *groupKiller) finish(ctx context.Context, err error) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !k.terminated {
+		return err
+	}
+	cause := "canceled"
+	if ctx.Err() == context.DeadlineExceeded {
+		cause = "timed out"
+	}
+	how := "terminated"
+	if k.killed {
+		how = "killed"
+		if k.grace > 0 {
+			how = fmt.Sprintf("killed after grace period %s", k.grace)
+		}
+	}
+	if err == nil {
+		return fmt.Errorf("command %s, %s", cause, how)
+	}
+	return fmt.Errorf("command %s, %s: %s", cause, how, err)
+}
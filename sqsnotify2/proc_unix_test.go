@@ -0,0 +1,112 @@
+//go:build !windows
+// +build !windows
+
+package sqsnotify2
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestExecCmdTimeout(t *testing.T) {
+	sn := newTestSQSNotify(t, Succeed)
+	sn.Timeout = 200 * time.Millisecond
+	sn.KillGrace = time.Second
+	err := execTestCmd(t, sn, "sleep")
+	if err == nil || !strings.HasPrefix(err.Error(), "command timed out, terminated") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestExecCmdKillAfterGrace(t *testing.T) {
+	sn := newTestSQSNotify(t, Succeed)
+	sn.Timeout = 200 * time.Millisecond
+	sn.KillGrace = 200 * time.Millisecond
+	start := time.Now()
+	err := execTestCmd(t, sn, "trap")
+	if err == nil || !strings.HasPrefix(err.Error(), "command timed out, killed after grace period 200ms") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("too slow to kill: %s", d)
+	}
+}
+
+func TestExecCmdKillGroup(t *testing.T) {
+	pidfile := filepath.Join(t.TempDir(), "pid")
+	t.Setenv("SQSNOTIFY2_HELPER_OUTPUT", pidfile)
+	sn := newTestSQSNotify(t, Succeed)
+	sn.Timeout = time.Second
+	err := execTestCmd(t, sn, "spawn")
+	if err == nil {
+		t.Fatal("command should be timed out")
+	}
+	// the grandchild should be terminated.
+	waitDead(t, readPidfile(t, pidfile), time.Second)
+}
+
+func TestExecCmdKillGroupAfterGrace(t *testing.T) {
+	pidfile := filepath.Join(t.TempDir(), "pid")
+	t.Setenv("SQSNOTIFY2_HELPER_OUTPUT", pidfile)
+	sn := newTestSQSNotify(t, Succeed)
+	sn.Timeout = time.Second
+	sn.KillGrace = 300 * time.Millisecond
+	err := execTestCmd(t, sn, "spawn-trap")
+	if err == nil || !strings.HasPrefix(err.Error(), "command timed out, terminated") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the grandchild ignores SIGTERM, so it should be killed after grace
+	// period, though the command has ended already.
+	pid := readPidfile(t, pidfile)
+	if !isAlive(pid) {
+		t.Fatalf("grandchild should be alive in grace period: pid=%d", pid)
+	}
+	waitDead(t, pid, 2*time.Second)
+}
+
+func readPidfile(t *testing.T, name string) int {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("failed to read pidfile: %v", err)
+	}
+	pid, err := strconv.Atoi(string(b))
+	if err != nil {
+		t.Fatalf("invalid pidfile: %v", err)
+	}
+	return pid
+}
+
+// waitDead waits a process to be dead, or fails after timeout.
+func waitDead(t *testing.T, pid int, timeout time.Duration) {
+	t.Helper()
+	for start := time.Now(); isAlive(pid); {
+		if time.Since(start) >= timeout {
+			t.Fatalf("grandchild is still alive: pid=%d", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// isAlive checks a process is alive.  Zombies are treated as dead, because
+// orphans may not be reaped by init in containers.
+func isAlive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	b, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	// the state follows the command name in parentheses.
+	s := string(b)
+	if i := strings.LastIndex(s, ")"); i >= 0 && i+2 < len(s) {
+		return s[i+2] != 'Z'
+	}
+	return true
+}
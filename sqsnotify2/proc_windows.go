@@ -0,0 +1,23 @@
+//go:build windows
+// +build windows
+
+package sqsnotify2
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup does nothing on Windows.
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// terminateGroup kills a process, Windows doesn't support signals.
+func terminateGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+// killGroup kills a process.
+func killGroup(p *os.Process) error {
+	return p.Kill()
+}
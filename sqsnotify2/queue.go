@@ -0,0 +1,171 @@
+package sqsnotify2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// QueueOptions configures attributes and tags of a queue.  nil fields are
+// not set, so the queue uses default values or keeps current values.
+type QueueOptions struct {
+	// VisibilityTimeout in seconds.
+	VisibilityTimeout *int64
+	// MessageRetentionPeriod in seconds.
+	MessageRetentionPeriod *int64
+	// ReceiveMessageWaitTimeSeconds in seconds.
+	ReceiveMessageWaitTimeSeconds *int64
+	// FifoQueue is used only at creation.  The name of queue should have
+	// ".fifo" suffix.
+	FifoQueue bool
+	// DeadLetterQueue is a name or an ARN of dead-letter queue for
+	// RedrivePolicy.
+	DeadLetterQueue string
+	// MaxReceiveCount is used with DeadLetterQueue for RedrivePolicy.
+	MaxReceiveCount int64
+
+	Tags map[string]string
+}
+
+const fifoSuffix = ".fifo"
+
+// attributes builds attributes for CreateQueue or SetQueueAttributes.
+func (qo *QueueOptions) attributes(ctx context.Context, api sqsiface.SQSAPI, create bool) (map[string]*string, error) {
+	attrs := map[string]*string{}
+	setInt := func(name string, v *int64) {
+		if v != nil {
+			attrs[name] = aws.String(strconv.FormatInt(*v, 10))
+		}
+	}
+	setInt(sqs.QueueAttributeNameVisibilityTimeout, qo.VisibilityTimeout)
+	setInt(sqs.QueueAttributeNameMessageRetentionPeriod, qo.MessageRetentionPeriod)
+	setInt(sqs.QueueAttributeNameReceiveMessageWaitTimeSeconds, qo.ReceiveMessageWaitTimeSeconds)
+	if create && qo.FifoQueue {
+		attrs[sqs.QueueAttributeNameFifoQueue] = aws.String("true")
+	}
+	if qo.DeadLetterQueue != "" {
+		arn, err := queueARN(ctx, api, qo.DeadLetterQueue)
+		if err != nil {
+			return nil, err
+		}
+		n := qo.MaxReceiveCount
+		if n <= 0 {
+			return nil, errors.New("MaxReceiveCount should be greater than 0 for DeadLetterQueue")
+		}
+		b, err := json.Marshal(struct {
+			DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+			MaxReceiveCount     string `json:"maxReceiveCount"`
+		}{arn, strconv.FormatInt(n, 10)})
+		if err != nil {
+			return nil, err
+		}
+		attrs[sqs.QueueAttributeNameRedrivePolicy] = aws.String(string(b))
+	}
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+	return attrs, nil
+}
+
+func (qo *QueueOptions) tags() map[string]*string {
+	if len(qo.Tags) == 0 {
+		return nil
+	}
+	tags := make(map[string]*string, len(qo.Tags))
+	for k, v := range qo.Tags {
+		tags[k] = aws.String(v)
+	}
+	return tags
+}
+
+// queueARN gets an ARN of queue from its name.  An ARN is returned as is.
+func queueARN(ctx context.Context, api sqsiface.SQSAPI, nameOrARN string) (string, error) {
+	if strings.HasPrefix(nameOrARN, "arn:") {
+		return nameOrARN, nil
+	}
+	rGet, err := api.GetQueueUrlWithContext(ctx, &sqs.GetQueueUrlInput{
+		QueueName: aws.String(nameOrARN),
+	})
+	if err != nil {
+		return "", err
+	}
+	rAttr, err := api.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       rGet.QueueUrl,
+		AttributeNames: []*string{aws.String(sqs.QueueAttributeNameQueueArn)},
+	})
+	if err != nil {
+		return "", err
+	}
+	arn, ok := rAttr.Attributes[sqs.QueueAttributeNameQueueArn]
+	if !ok || arn == nil {
+		return "", errors.New("no QueueArn for " + nameOrARN)
+	}
+	return *arn, nil
+}
+
+// CreateQueue creates a queue with options, and returns its URL.
+func CreateQueue(ctx context.Context, api sqsiface.SQSAPI, name string, qo *QueueOptions) (*string, error) {
+	in := &sqs.CreateQueueInput{
+		QueueName: aws.String(name),
+	}
+	if qo != nil {
+		if qo.FifoQueue && !strings.HasSuffix(name, fifoSuffix) {
+			return nil, errors.New("name of FIFO queue should end with " + fifoSuffix)
+		}
+		attrs, err := qo.attributes(ctx, api, true)
+		if err != nil {
+			return nil, err
+		}
+		in.Attributes = attrs
+		in.Tags = qo.tags()
+	}
+	rCreate, err := api.CreateQueueWithContext(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return rCreate.QueueUrl, nil
+}
+
+// SetQueueOptions updates attributes and tags of a queue.
+func SetQueueOptions(ctx context.Context, api sqsiface.SQSAPI, queueURL *string, qo *QueueOptions) error {
+	attrs, err := qo.attributes(ctx, api, false)
+	if err != nil {
+		return err
+	}
+	if len(attrs) > 0 {
+		_, err := api.SetQueueAttributesWithContext(ctx, &sqs.SetQueueAttributesInput{
+			QueueUrl:   queueURL,
+			Attributes: attrs,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if tags := qo.tags(); len(tags) > 0 {
+		_, err := api.TagQueueWithContext(ctx, &sqs.TagQueueInput{
+			QueueUrl: queueURL,
+			Tags:     tags,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetQueueURL gets URL of a queue.
+func GetQueueURL(ctx context.Context, api sqsiface.SQSAPI, name string) (*string, error) {
+	rGet, err := api.GetQueueUrlWithContext(ctx, &sqs.GetQueueUrlInput{
+		QueueName: aws.String(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rGet.QueueUrl, nil
+}
@@ -0,0 +1,48 @@
+package sqsnotify2
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestQueueOptionsAttributes(t *testing.T) {
+	qo := &QueueOptions{
+		VisibilityTimeout: aws.Int64(60),
+		FifoQueue:         true,
+		DeadLetterQueue:   "arn:aws:sqs:us-east-1:123456789012:dlq",
+		MaxReceiveCount:   3,
+	}
+	attrs, err := qo.attributes(context.Background(), nil, true)
+	if err != nil {
+		t.Fatalf("failed to build attributes: %v", err)
+	}
+	want := map[string]string{
+		"VisibilityTimeout": "60",
+		"FifoQueue":         "true",
+		"RedrivePolicy":     `{"deadLetterTargetArn":"arn:aws:sqs:us-east-1:123456789012:dlq","maxReceiveCount":"3"}`,
+	}
+	if len(attrs) != len(want) {
+		t.Fatalf("unexpected attributes: %v", aws.StringValueMap(attrs))
+	}
+	for k, v := range want {
+		if got := aws.StringValue(attrs[k]); got != v {
+			t.Errorf("unexpected %s: want=%s got=%s", k, v, got)
+		}
+	}
+
+	// FifoQueue can't be changed after creation.
+	attrs, err = qo.attributes(context.Background(), nil, false)
+	if err != nil {
+		t.Fatalf("failed to build attributes: %v", err)
+	}
+	if _, ok := attrs["FifoQueue"]; ok {
+		t.Error("FifoQueue should not be set for existing queue")
+	}
+
+	_, err = CreateQueue(context.Background(), nil, "foo", &QueueOptions{FifoQueue: true})
+	if err == nil {
+		t.Error("FIFO queue without .fifo suffix should fail")
+	}
+}
@@ -0,0 +1,51 @@
+package sqsnotify2
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limiter limits rate of executions by an interval between them.  A nil
+// limiter doesn't limit.
+type limiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newLimiter(interval time.Duration) *limiter {
+	if interval <= 0 {
+		return nil
+	}
+	return &limiter{interval: interval}
+}
+
+// wait waits for a slot of execution.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	t := l.next
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	d := time.Until(t)
+	if d <= 0 {
+		return nil
+	}
+	tm := time.NewTimer(d)
+	defer tm.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-tm.C:
+		return nil
+	}
+}
@@ -0,0 +1,93 @@
+package sqsnotify2
+
+import (
+	"context"
+)
+
+// Reload replaces the configuration of running SQSNotify.  It takes effect
+// before receiving next messages, so messages in flight finish under the old
+// configuration.  QueueName, DryRun and Control can't be changed.
+func (sn *SQSNotify) Reload(cfg *Config) {
+	sn.reloadMu.Lock()
+	defer sn.reloadMu.Unlock()
+	c := *cfg
+	sn.pending = &c
+	if sn.reloaded != nil {
+		close(sn.reloaded)
+		sn.reloaded = nil
+	}
+}
+
+// reloadChan returns a channel which is closed when Reload is called, or has
+// been called but not applied yet.
+func (sn *SQSNotify) reloadChan() <-chan struct{} {
+	sn.reloadMu.Lock()
+	defer sn.reloadMu.Unlock()
+	if sn.pending != nil {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	if sn.reloaded == nil {
+		sn.reloaded = make(chan struct{})
+	}
+	return sn.reloaded
+}
+
+// applyReload applies a configuration given by Reload.  It should be called
+// when no messages are in flight.  The old configuration is kept when the
+// new one can't be applied.
+func (sn *SQSNotify) applyReload() error {
+	sn.reloadMu.Lock()
+	cfg := sn.pending
+	sn.pending = nil
+	sn.reloadMu.Unlock()
+	if cfg == nil {
+		return nil
+	}
+
+	cfg.QueueName = sn.QueueName
+	cfg.DryRun = sn.DryRun
+	cfg.Control = sn.Control
+	old := sn.Config
+	err := sn.switchConfig(cfg)
+	if err != nil {
+		sn.log().Printf("failed to reload configuration, keep the old one: queue=%s err=%s", sn.QueueName, err)
+		return sn.switchConfig(&old)
+	}
+	sn.log().Printf("configuration reloaded: queue=%s", sn.QueueName)
+	return nil
+}
+
+func (sn *SQSNotify) switchConfig(cfg *Config) error {
+	breakerChanged := cfg.Breaker != sn.Breaker
+	sn.stopCoprocess()
+	sn.Config = *cfg
+	err := sn.prepare()
+	if err != nil {
+		return err
+	}
+	if breakerChanged {
+		sn.br = newBreaker(sn.Breaker, sn.log().Printf)
+	}
+	return sn.startCoprocess()
+}
+
+// interruptible returns a context which is canceled by changes of Control
+// or Reload, to stop waiting and receiving.
+func (sn *SQSNotify) interruptible(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := sn.Control.context(ctx)
+	ch := sn.reloadChan()
+	ctx, cancel2 := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-ch:
+			cancel2()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		cancel2()
+		cancel()
+	}
+}
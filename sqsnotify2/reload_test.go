@@ -0,0 +1,72 @@
+package sqsnotify2
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/koron/sqs-notify/sqsnotify2/sqstest"
+)
+
+func TestRunReload(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	t.Setenv("SQSNOTIFY2_HELPER_OUTPUT", out)
+	readOut := func() string {
+		b, _ := os.ReadFile(out)
+		return string(b)
+	}
+	api := sqstest.New()
+	sendBodies(t, api, "env")
+	sn := newTestSQSNotify(t, Succeed)
+	sn.WaitTime = aws.Int64(20)
+	sn.Env = []string{"SQSNOTIFY2_HELPER_VALUE=old"}
+	errCh := startRun(t, sn, api)
+	waitFor(t, func() bool { return readOut() == "old" })
+
+	// reloading interrupts long polling, and takes effect for next messages.
+	cfg := sn.Config
+	cfg.Env = []string{"SQSNOTIFY2_HELPER_VALUE=new"}
+	sn.Reload(&cfg)
+	time.Sleep(100 * time.Millisecond)
+	sendBodies(t, api, "env")
+	waitFor(t, func() bool { return readOut() == "new" })
+
+	// invalid configuration is ignored.
+	cfg.User = "no-such-user-of-sqsnotify2"
+	cfg.Env = []string{"SQSNOTIFY2_HELPER_VALUE=invalid"}
+	sn.Reload(&cfg)
+	time.Sleep(100 * time.Millisecond)
+	os.Remove(out)
+	sendBodies(t, api, "env")
+	waitFor(t, func() bool { return readOut() == "new" })
+
+	select {
+	case err := <-errCh:
+		t.Fatalf("run stopped: %v", err)
+	default:
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	if l := newLimiter(0); l != nil {
+		t.Fatal("limiter should be nil without interval")
+	}
+	l := newLimiter(50 * time.Millisecond)
+	st := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatalf("failed to wait: %v", err)
+		}
+	}
+	if d := time.Since(st); d < 100*time.Millisecond {
+		t.Errorf("too fast: %s", d)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx); err != context.Canceled {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
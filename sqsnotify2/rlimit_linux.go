@@ -0,0 +1,104 @@
+package sqsnotify2
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// rlimitsEnvName is an environment variable to make sqs-notify2 act as a
+// wrapper, which sets limits of resources and executes a command.
+const rlimitsEnvName = "SQSNOTIFY2_RLIMITS"
+
+// rlimitsExitCode is exit code of the wrapper when it failed to execute a
+// command.
+const rlimitsExitCode = 126
+
+func init() {
+	v, ok := os.LookupEnv(rlimitsEnvName)
+	if !ok || len(os.Args) < 3 {
+		return
+	}
+	os.Unsetenv(rlimitsEnvName)
+	err := execRlimits(v, os.Args[1], os.Args[2:])
+	fmt.Fprintf(os.Stderr, "sqs-notify2: failed to execute %s: %s\n", os.Args[1], err)
+	os.Exit(rlimitsExitCode)
+}
+
+func checkRlimits(rl Rlimits) error {
+	return nil
+}
+
+// wrapRlimits makes a command start via the wrapper, when limits are given.
+// Limits are set before exec, so children of the command can't escape from
+// them.  The wrapper switches the user too, because the user may not be
+// able to execute sqs-notify2 itself.
+func wrapRlimits(cmd *exec.Cmd, rl Rlimits) {
+	if rl.isZero() || cmd.Err != nil {
+		return
+	}
+	self, err := os.Executable()
+	if err != nil {
+		cmd.Err = fmt.Errorf("failed to set rlimits: %s", err)
+		return
+	}
+	v := fmt.Sprintf("%d,%d,%d", rl.CPU/time.Second, rl.AddressSpace, rl.OpenFiles)
+	if attr := cmd.SysProcAttr; attr != nil && attr.Credential != nil {
+		v += fmt.Sprintf(",%d,%d", attr.Credential.Uid, attr.Credential.Gid)
+		attr.Credential = nil
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env, rlimitsEnvName+"="+v)
+	cmd.Args = append([]string{self, cmd.Path}, cmd.Args...)
+	cmd.Path = self
+}
+
+// execRlimits sets limits of resources and the user given by the wrapper,
+// and executes a command.  It returns only when failed.
+func execRlimits(v, path string, args []string) error {
+	var n []uint64
+	for _, s := range strings.Split(v, ",") {
+		x, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", rlimitsEnvName, v)
+		}
+		n = append(n, x)
+	}
+	if len(n) != 3 && len(n) != 5 {
+		return fmt.Errorf("invalid %s: %s", rlimitsEnvName, v)
+	}
+	for i, resource := range []int{
+		syscall.RLIMIT_CPU,
+		syscall.RLIMIT_AS,
+		syscall.RLIMIT_NOFILE,
+	} {
+		if n[i] == 0 {
+			continue
+		}
+		err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: n[i], Max: n[i]})
+		if err != nil {
+			return fmt.Errorf("failed to set rlimits: %s", err)
+		}
+	}
+	if len(n) == 5 {
+		// same as Credential of syscall.SysProcAttr.
+		err := syscall.Setgroups([]int{})
+		if err == nil {
+			err = syscall.Setgid(int(n[4]))
+		}
+		if err == nil {
+			err = syscall.Setuid(int(n[3]))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to switch user: %s", err)
+		}
+	}
+	return syscall.Exec(path, args, os.Environ())
+}
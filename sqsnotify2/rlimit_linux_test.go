@@ -0,0 +1,38 @@
+package sqsnotify2
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func helperRlimit() string {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		return err.Error()
+	}
+	return strconv.FormatUint(lim.Cur, 10)
+}
+
+func TestExecCmdRlimits(t *testing.T) {
+	for _, body := range []string{"rlimit", "rlimit-spawn"} {
+		t.Run(body, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out")
+			t.Setenv("SQSNOTIFY2_HELPER_OUTPUT", out)
+			sn := newTestSQSNotify(t, Succeed)
+			sn.Rlimits = Rlimits{OpenFiles: 64}
+			if err := execTestCmd(t, sn, body); err != nil {
+				t.Fatalf("failed to execute: %v", err)
+			}
+			b, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatalf("failed to read output: %v", err)
+			}
+			if string(b) != "64" {
+				t.Errorf("unexpected limit of open files: %s", b)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package sqsnotify2
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// checkRlimits fails when limits are given, they are supported on Linux
+// only.
+func checkRlimits(rl Rlimits) error {
+	if rl.isZero() {
+		return nil
+	}
+	return errors.New("rlimits are supported on Linux only")
+}
+
+func wrapRlimits(cmd *exec.Cmd, rl Rlimits) {
+}
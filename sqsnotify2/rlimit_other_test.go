@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package sqsnotify2
+
+func helperRlimit() string {
+	return ""
+}
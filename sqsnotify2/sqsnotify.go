@@ -2,11 +2,14 @@ package sqsnotify2
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -27,6 +30,18 @@ type SQSNotify struct {
 	l       sync.Mutex
 	results []*result
 	cache   Cache
+	dk      *dedupKey
+	flt     *filter
+	fqURL   *string
+	fqName  string
+	cred    *credential
+	coproc  *coprocPool
+	br      *breaker
+	lim     *limiter
+
+	reloadMu sync.Mutex
+	pending  *Config
+	reloaded chan struct{}
 }
 
 // New creates a SQSNotify object with configuration.
@@ -47,6 +62,10 @@ func (sn *SQSNotify) log() *log.Logger {
 }
 
 func (sn *SQSNotify) logResult(r *result) {
+	if r.skip != "" {
+		sn.log().Printf("\tSKIPPED\tstage:%s action:%s body:%#v", r.stg, r.skip, *r.msg.Body)
+		return
+	}
 	if r.err == nil {
 		sn.log().Printf("\tEXECUTED\tbody:%#v", *r.msg.Body)
 		return
@@ -67,102 +86,264 @@ func (sn *SQSNotify) Run(ctx context.Context, cache Cache) error {
 }
 
 func (sn *SQSNotify) newSQS() (*sqs.SQS, error) {
+	return NewSQS(&sn.Config)
+}
+
+// NewSQS creates a SQS client with AWS related part of configuration.
+func NewSQS(cfg *Config) (*sqs.SQS, error) {
 	s, err := session.NewSessionWithOptions(session.Options{
-		Profile: sn.Profile,
+		Profile: cfg.Profile,
 	})
 	if err != nil {
 		return nil, err
 	}
-	cfg := aws.NewConfig()
-	if sn.Region != "" {
-		cfg.WithRegion(sn.Region)
+	awsCfg := aws.NewConfig()
+	if cfg.Region != "" {
+		awsCfg.WithRegion(cfg.Region)
 	}
-	if sn.MaxRetries > 0 {
-		cfg.WithMaxRetries(sn.MaxRetries)
+	if cfg.MaxRetries > 0 {
+		awsCfg.WithMaxRetries(cfg.MaxRetries)
 	}
-	if sn.Endpoint != "" {
-		cfg.WithEndpoint(sn.Endpoint)
+	if cfg.Endpoint != "" {
+		awsCfg.WithEndpoint(cfg.Endpoint)
 	}
-	return sqs.New(s, cfg), nil
+	creds, err := newRoleCredentials(s, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		awsCfg.WithCredentials(creds)
+	}
+	return sqs.New(s, awsCfg), nil
+}
+
+// prepare prepares the configuration.
+func (sn *SQSNotify) prepare() error {
+	dk, err := parseDedupKey(sn.DedupKey)
+	if err != nil {
+		return err
+	}
+	sn.dk = dk
+	flt, err := parseFilter(sn.Filter)
+	if err != nil {
+		return err
+	}
+	if flt != nil && sn.FilterAction == FilterMove && sn.FilterQueue == "" {
+		return errors.New("no queue to move filtered messages")
+	}
+	sn.flt = flt
+	if l, ok := sn.cache.(leaser); ok {
+		if err := checkLease(l.leaseTime()); err != nil {
+			return err
+		}
+	}
+	if err := sn.prepareExec(); err != nil {
+		return err
+	}
+	sn.lim = newLimiter(sn.RateInterval)
+	return nil
+}
+
+func (sn *SQSNotify) startCoprocess() error {
+	if !sn.Coprocess || sn.DryRun {
+		return nil
+	}
+	p, err := newCoprocPool(sn, sn.numWorkers())
+	if err != nil {
+		return err
+	}
+	sn.coproc = p
+	return nil
+}
+
+func (sn *SQSNotify) stopCoprocess() {
+	if sn.coproc == nil {
+		return
+	}
+	sn.coproc.close()
+	sn.coproc = nil
 }
 
 func (sn *SQSNotify) run(ctx context.Context, api sqsiface.SQSAPI) error {
-	qu, err := getQueueURL(api, sn.QueueName, sn.CreateQueue)
+	if err := sn.prepare(); err != nil {
+		return err
+	}
+	if err := sn.startCoprocess(); err != nil {
+		return err
+	}
+	defer sn.stopCoprocess()
+	qu, err := getQueueURL(ctx, api, sn.QueueName, sn.CreateQueue, sn.QueueOptions)
 	if err != nil {
 		return err
 	}
+	sn.br = newBreaker(sn.Breaker, sn.log().Printf)
 	var round = 0
 	for {
+		// no messages are in flight here, so apply a reloaded
+		// configuration.
+		if err := sn.applyReload(); err != nil {
+			return err
+		}
+
+		// wait while paused, and stop when draining.  Waiting for the
+		// breaker and receiving are interrupted by changes of the state
+		// and reloading.
+		cctx, cancel := sn.interruptible(ctx)
+		err := sn.Control.wait(ctx)
+		if err != nil {
+			cancel()
+			if err == errDrained {
+				return nil
+			}
+			return err
+		}
+
+		// wait while the circuit breaker is open, and receive only one
+		// message as a probe after cooldown.
+		probe, err := sn.br.wait(cctx)
+		if err != nil {
+			cancel()
+			if ctx.Err() == nil {
+				continue
+			}
+			return err
+		}
+		max := int64(sn.maxMessages())
+		if probe {
+			max = 1
+		}
+
 		// receive messages.
-		msgs, err := sn.receiveQ(ctx, api, qu, maxMsg)
+		rstart := time.Now()
+		msgs, err := sn.receiveQ(cctx, api, qu, max)
+		cancel()
 		if err != nil {
+			if ctx.Err() == nil && cctx.Err() != nil {
+				continue
+			}
 			return err
 		}
+		recv := time.Now()
 		if len(msgs) == 0 {
 			//sn.log().Printf("round %d polling timed out, proceed next", round)
 			round++
 			continue
 		}
 
+		// dry-run doesn't touch the cache nor delete messages, so it
+		// returns before them.
+		if sn.DryRun {
+			return sn.dryRun(ctx, api, qu, msgs)
+		}
+
+		ress := make([]*result, 0, len(msgs))
+		for i, m := range msgs {
+			res := &result{round: round, index: i, msg: m, recv: recv}
+			sn.startTrace(res)
+			sn.traceReceive(res, rstart)
+			// messages which don't match the filter are not
+			// executed, nor inserted to the cache.
+			if !sn.filterMessage(ctx, api, qu, res) {
+				continue
+			}
+			ress = append(ress, res)
+		}
+
 		// remove messsages first when RemovePolicy == BeforeExecution
 		if sn.RemovePolicy == BeforeExecution {
-			entries := make([]*sqs.DeleteMessageBatchRequestEntry, 0, len(msgs))
-			for _, m := range msgs {
+			entries := make([]*sqs.DeleteMessageBatchRequestEntry, 0, len(ress))
+			for _, r := range ress {
 				entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{
-					Id:            m.MessageId,
-					ReceiptHandle: m.ReceiptHandle,
+					Id:            r.msg.MessageId,
+					ReceiptHandle: r.msg.ReceiptHandle,
 				})
 			}
+			dstart := time.Now()
 			err := sn.deleteQ(ctx, api, qu, entries)
+			sn.traceDelete(ress, dstart, err)
 			if err != nil {
 				return err
 			}
+			for _, r := range ress {
+				r.removed = true
+			}
 		}
 
 		// run as commands
 		sem := sn.newWeighted()
 		var wg sync.WaitGroup
-		for i, m := range msgs {
-			res := &result{round: round, index: i, msg: m}
+		for _, res := range ress {
+			m := res.msg
 			err := sn.cacheInsert(res, stage.Recv)
+			if err == errCacheFound && sn.DuplicatePolicy == ByStage {
+				sn.handleDuplicate(ctx, api, qu, res)
+				continue
+			}
 			if err != nil {
 				sn.addResult(res.withErr(err))
 				continue
 			}
+			// renew the lease while waiting for a worker, and while
+			// the command is running.
+			stop := sn.renewLease(ctx, res)
 			wg.Add(1)
-			go func(r, n int, m *sqs.Message, res *result) {
+			go func(m *sqs.Message, res *result) {
 				defer wg.Done()
-				res.stg = stage.Lock
+				defer stop()
+				sn.setStage(res, stage.Lock)
 				err := sem.Acquire(ctx, 1)
 				if err != nil {
 					sn.addResult(res.withErr(err))
 					return
 				}
 				defer sem.Release(1)
-				res.stg = stage.Exec
+				// don't execute rest of messages when the circuit breaker
+				// has been opened.  They will be received again after it
+				// closed.
+				if sn.RemovePolicy != BeforeExecution && sn.br.blocks() {
+					sn.release(res)
+					sn.addResult(res.withErr(errBreakerOpen))
+					return
+				}
+				err = sn.lim.wait(ctx)
+				if err != nil {
+					sn.addResult(res.withErr(err))
+					return
+				}
 				err = sn.cacheUpdate(res, stage.Exec)
 				if err != nil {
 					sn.addResult(res.withErr(err))
 					return
 				}
-				err = sn.execCmd(ctx, m)
+				err = sn.traceExec(ctx, res, func(ctx context.Context) error {
+					return sn.execCmd(ctx, m)
+				})
+				if ctx.Err() == nil {
+					sn.br.record(err)
+				}
+				if isRetry(err) {
+					sn.retry(ctx, api, qu, res)
+				}
 				if err != nil {
 					sn.addResult(res.withErr(err))
 					return
 				}
-				res.stg = stage.Done
 				err = sn.cacheUpdate(res, stage.Done)
 				if err != nil {
 					sn.addResult(res.withErr(err))
 					return
 				}
-				sn.addResult(&result{round: r, index: n, msg: m})
-			}(round, i, m, res)
+				sn.addResult(res)
+			}(m, res)
 		}
 		wg.Wait()
 
 		// delete messages
-		err = sn.deleteQ(ctx, api, qu, sn.deleteEntries())
+		entries, deleted := sn.deleteEntries()
+		dstart := time.Now()
+		err = sn.deleteQ(ctx, api, qu, entries)
+		sn.traceDelete(deleted, dstart, err)
+		sn.archiveResults(deleted, err)
 		if err != nil {
 			return err
 		}
@@ -171,8 +352,10 @@ func (sn *SQSNotify) run(ctx context.Context, api sqsiface.SQSAPI) error {
 	}
 }
 
-func (sn *SQSNotify) deleteEntries() []*sqs.DeleteMessageBatchRequestEntry {
+// deleteEntries returns entries to delete messages, and their results.
+func (sn *SQSNotify) deleteEntries() ([]*sqs.DeleteMessageBatchRequestEntry, []*result) {
 	var entries []*sqs.DeleteMessageBatchRequestEntry
+	var deleted []*result
 	for _, r := range sn.results {
 		if !sn.shouldRemoveAfter(r) {
 			continue
@@ -181,30 +364,173 @@ func (sn *SQSNotify) deleteEntries() []*sqs.DeleteMessageBatchRequestEntry {
 			Id:            r.msg.MessageId,
 			ReceiptHandle: r.msg.ReceiptHandle,
 		})
+		deleted = append(deleted, r)
 	}
-	return entries
+	return entries, deleted
 }
 
-func (sn *SQSNotify) cacheInsert(r *result, stg stage.Stage) error {
+// setStage sets a stage of a message in flight.
+func (sn *SQSNotify) setStage(r *result, stg stage.Stage) {
 	r.stg = stg
-	err := sn.cache.Insert(*r.msg.MessageId, stg)
+	sn.Control.track(sn.QueueName, r)
+}
+
+func (sn *SQSNotify) cacheInsert(r *result, stg stage.Stage) error {
+	sn.setStage(r, stg)
+	key, err := sn.dk.key(r.msg)
+	if err != nil {
+		// a message without the key is identified by its ID, otherwise
+		// it would be received again and again until it is expired.
+		sn.log().Printf("no dedup key, use message ID instead: id=%s err=%s", *r.msg.MessageId, err)
+		key = *r.msg.MessageId
+	}
+	r.key = key
+	err = sn.cache.Insert(r.key, stg)
 	if err != nil {
 		return err
 	}
+	r.cached = stg
 	return nil
 }
 
 func (sn *SQSNotify) cacheUpdate(r *result, stg stage.Stage) error {
-	r.stg = stg
-	err := sn.cache.Update(*r.msg.MessageId, stg)
+	sn.setStage(r, stg)
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+	err := sn.cache.Update(r.key, stg)
 	if err != nil {
 		// FIXME: consider errCacheNotFound
 		return err
 	}
+	r.cached = stg
 	return nil
 }
 
+// renewLease renews the lease of a cache entry periodically until the
+// message is processed.  Call returned func to stop renewal.
+func (sn *SQSNotify) renewLease(ctx context.Context, r *result) func() {
+	l, ok := sn.cache.(leaser)
+	if !ok || l.leaseTime() <= 0 {
+		return func() {}
+	}
+	d := l.leaseTime() / 3
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		tk := time.NewTicker(d)
+		defer tk.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case <-tk.C:
+				err := sn.renewEntry(r)
+				if err != nil {
+					sn.log().Printf("failed to renew lease: id=%s err=%s", *r.msg.MessageId, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// renewEntry updates a cache entry with its current stage to renew the
+// lease.  Released or done entries are not renewed.
+func (sn *SQSNotify) renewEntry(r *result) error {
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+	if r.cached == stage.None || r.cached == stage.Done {
+		return nil
+	}
+	return sn.cache.Update(r.key, r.cached)
+}
+
+// handleDuplicate handles a message found in cache by stage of the entry.
+func (sn *SQSNotify) handleDuplicate(ctx context.Context, api sqsiface.SQSAPI, queueURL *string, r *result) {
+	item, err := sn.cache.Get(r.key)
+	if err != nil {
+		sn.addResult(r.withErr(err))
+		return
+	}
+	if item == nil {
+		// the entry has gone, it will be processed at next delivery.
+		sn.addResult(r.withSkip(skipKeep))
+		return
+	}
+	r.stg = item.Stage
+	switch item.Stage {
+	case stage.Done:
+		sn.addResult(r.withSkip(skipDelete))
+	case stage.Exec:
+		// the message has been deleted already with BeforeExecution.
+		if sn.RemovePolicy != BeforeExecution {
+			err := changeVisibility(ctx, api, queueURL, r.msg.ReceiptHandle, visibilitySeconds(sn.DuplicateVisibility))
+			if err != nil {
+				sn.log().Printf("failed to extend visibility: id=%s err=%s", *r.msg.MessageId, err)
+			}
+		}
+		sn.addResult(r.withSkip(skipExtend))
+	default:
+		sn.addResult(r.withSkip(skipKeep))
+	}
+}
+
+// visibilitySeconds converts d to seconds of visibility timeout.  It is
+// rounded up and at least a second, because zero makes a message visible
+// immediately.
+func visibilitySeconds(d time.Duration) int64 {
+	n := int64((d + time.Second - 1) / time.Second)
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// retry makes a message, which a co-process asked to retry, visible again
+// and removes its cache entry, to process it at next delivery.
+func (sn *SQSNotify) retry(ctx context.Context, api sqsiface.SQSAPI, queueURL *string, r *result) {
+	sn.release(r)
+	if sn.RemovePolicy == BeforeExecution {
+		return
+	}
+	err := changeVisibility(ctx, api, queueURL, r.msg.ReceiptHandle, 0)
+	if err != nil {
+		sn.log().Printf("failed to reset visibility for retry: id=%s err=%s", *r.msg.MessageId, err)
+	}
+}
+
+// release removes a cache entry of a message which is not processed, to
+// process it at next delivery.
+func (sn *SQSNotify) release(r *result) {
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+	r.cached = stage.None
+	err := sn.cache.Delete(r.key)
+	if err != nil {
+		sn.log().Printf("failed to delete cache: id=%s err=%s", *r.msg.MessageId, err)
+	}
+}
+
 func (sn *SQSNotify) shouldRemoveAfter(r *result) bool {
+	switch r.skip {
+	case skipFilterDelete, skipFilterMove:
+		// filtered messages are not removed before execution.
+		return true
+	case skipFilterLeave:
+		return false
+	}
+	if r.skip != "" {
+		return r.skip == skipDelete && sn.RemovePolicy != BeforeExecution
+	}
+	if isRetry(r.err) {
+		return false
+	}
 	switch sn.RemovePolicy {
 	default:
 		fallthrough
@@ -221,39 +547,81 @@ func (sn *SQSNotify) shouldRemoveAfter(r *result) bool {
 	}
 }
 
+// newCmd creates a command for a message.  path is a file which has the
+// body, for InputFile.
+func (sn *SQSNotify) newCmd(ctx context.Context, m *sqs.Message, path string) *exec.Cmd {
+	env := sn.cmdEnv()
+	if sn.InputMode == InputEnv {
+		if env == nil {
+			env = os.Environ()
+		}
+		env = append(env, BodyEnvName+"="+*m.Body)
+	}
+	return sn.newCmdWith(ctx, sn.cmdArgs(m, path), traceEnv(ctx, env, m))
+}
+
+// newCmdWith creates a command with arguments and environment variables,
+// and applies other options for commands.  It doesn't care messages, so it
+// is used for co-processes as is.
+func (sn *SQSNotify) newCmdWith(ctx context.Context, args, env []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, sn.CmdName, args...)
+	cmd.Dir = sn.Dir
+	cmd.Env = env
+	applyCredential(cmd, sn.cred)
+	wrapRlimits(cmd, sn.Rlimits)
+	return cmd
+}
+
 // execCmd executes a command for a message, and returns its exit code.
+// The command runs in its own process group, which is stopped on timeout or
+// cancel.
 func (sn *SQSNotify) execCmd(ctx context.Context, m *sqs.Message) error {
 	if sn.Timeout != 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, sn.Timeout)
 		defer cancel()
 	}
-	cmd := exec.CommandContext(ctx, sn.CmdName, sn.CmdArgs...)
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
+	if sn.coproc != nil {
+		return sn.coproc.exec(ctx, m)
 	}
-	go io.Copy(os.Stdout, stdout)
-	stderr, err := cmd.StderrPipe()
+	err := sn.checkInput(m)
 	if err != nil {
 		return err
 	}
-	go io.Copy(os.Stderr, stderr)
-
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return err
+	var path string
+	if sn.InputMode == InputFile {
+		path, err = sn.writeBodyFile(m)
+		if err != nil {
+			return fmt.Errorf("failed to write body to file: %s", err)
+		}
+		defer os.Remove(path)
 	}
-	go func() {
-		defer stdin.Close()
-		_, err := io.WriteString(stdin, *m.Body)
+	cmd := sn.newCmd(ctx, m, path)
+	k := newGroupKiller(cmd, sn.KillGrace)
+
+	// pass files as is, Wait() doesn't wait copying from pipes.
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if sn.InputMode == InputStdin {
+		stdin, err := cmd.StdinPipe()
 		if err != nil {
-			sn.handleCopyMessageFailure(err, m)
+			return err
 		}
-	}()
+		go func() {
+			defer stdin.Close()
+			_, err := io.WriteString(stdin, *m.Body)
+			if err != nil {
+				sn.handleCopyMessageFailure(err, m)
+			}
+		}()
+	}
 
-	err = cmd.Run()
+	err = cmd.Start()
+	if err != nil {
+		return k.finish(ctx, err)
+	}
+	err = k.finish(ctx, cmd.Wait())
 	if err != nil {
 		return err
 	}
@@ -261,7 +629,23 @@ func (sn *SQSNotify) execCmd(ctx context.Context, m *sqs.Message) error {
 }
 
 func (sn *SQSNotify) receiveQ(ctx context.Context, api sqsiface.SQSAPI, queueURL *string, max int64) ([]*sqs.Message, error) {
-	msgs, err := receiveMessages(ctx, api, queueURL, maxMsg, sn.WaitTime)
+	// trace context is passed to commands.
+	attrNames := []*string{aws.String(sqs.MessageSystemAttributeNameAwstraceHeader)}
+	msgAttrNames := []*string{aws.String(TraceparentAttribute)}
+	if n, ok := sn.dk.attributeName(); ok {
+		msgAttrNames = append(msgAttrNames, aws.String(n))
+	}
+	for _, n := range sn.flt.attributeNames() {
+		msgAttrNames = append(msgAttrNames, aws.String(n))
+	}
+	if sn.Archive != nil || sn.Coprocess || sn.DryRun || (sn.flt != nil && sn.FilterAction == FilterMove) {
+		// archive records all attributes, co-processes receive them,
+		// dry-run shows them, and moved messages keep them.
+		all := aws.String(sqs.QueueAttributeNameAll)
+		attrNames = []*string{all}
+		msgAttrNames = []*string{all}
+	}
+	msgs, err := receiveMessages(ctx, api, queueURL, max, sn.WaitTime, attrNames, msgAttrNames)
 	if err != nil {
 		return nil, err
 	}
@@ -289,12 +673,25 @@ func (sn *SQSNotify) handleCopyMessageFailure(err error, m *sqs.Message) {
 	sn.log().Printf("failed to pass message body: id=%s err=%s", *m.MessageId, err)
 }
 
-func (sn *SQSNotify) newWeighted() *semaphore.Weighted {
+// numWorkers returns number of workers, which run commands concurrently.
+func (sn *SQSNotify) numWorkers() int {
 	n := sn.Workers
 	if n < 0 || n > maxMsg {
 		n = 4
 	}
-	return semaphore.NewWeighted(int64(n))
+	return n
+}
+
+// maxMessages returns max num of messages to receive at once.
+func (sn *SQSNotify) maxMessages() int {
+	if sn.MaxMessages <= 0 || sn.MaxMessages > maxMsg {
+		return maxMsg
+	}
+	return sn.MaxMessages
+}
+
+func (sn *SQSNotify) newWeighted() *semaphore.Weighted {
+	return semaphore.NewWeighted(int64(sn.numWorkers()))
 }
 
 func (sn *SQSNotify) clearResults() {
@@ -305,20 +702,52 @@ func (sn *SQSNotify) clearResults() {
 
 func (sn *SQSNotify) addResult(r *result) {
 	sn.logResult(r)
+	sn.Control.untrack(r)
 	sn.l.Lock()
 	sn.results = append(sn.results, r)
 	sn.l.Unlock()
 }
 
+// actions for skipped (duplicated) messages.
+const (
+	skipDelete = "delete"
+	skipExtend = "extend"
+	skipKeep   = "keep"
+)
+
+// actions for messages which don't match the filter.
+const (
+	skipFilterDelete = "filter-delete"
+	skipFilterLeave  = "filter-leave"
+	skipFilterMove   = "filter-move"
+)
+
 type result struct {
 	round int
 	index int
 	msg   *sqs.Message
+	key   string
 	stg   stage.Stage
 	err   error
+	skip  string
+	recv  time.Time
+	tc    *traceContext
+
+	// removed is true when the message has been deleted before execution.
+	removed bool
+
+	// cacheMu guards cached, a stage in the cache, against renewal of
+	// the lease.
+	cacheMu sync.Mutex
+	cached  stage.Stage
 }
 
 func (r *result) withErr(err error) *result {
 	r.err = err
 	return r
 }
+
+func (r *result) withSkip(action string) *result {
+	r.skip = action
+	return r
+}
@@ -0,0 +1,386 @@
+package sqsnotify2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/koron/sqs-notify/sqsnotify2/sqstest"
+	"github.com/koron/sqs-notify/sqsnotify2/stage"
+)
+
+// TestHelperProcess isn't a real test, it is used as a command for messages.
+// It acts by the body:
+//   - "fail": exits with 1
+//   - "tempfail": exits with 75 (EX_TEMPFAIL)
+//   - "sleep": sleeps 10 seconds
+//   - "trap": ignores SIGTERM and sleeps
+//   - "spawn": starts a child which sleeps, writes its PID to a file given
+//     by SQSNOTIFY2_HELPER_OUTPUT, and sleeps
+//   - "spawn-trap": same as "spawn", but the child is a shell which ignores
+//     SIGTERM
+//   - "env": writes SQSNOTIFY2_HELPER_VALUE to the output file
+//   - "rlimit": writes soft limit of open files to the output file
+//   - "rlimit-spawn": runs a child at once, which acts as "rlimit"
+//   - "trace": writes TRACEPARENT and _X_AMZN_TRACE_ID to the output file,
+//     separated by "|"
+//   - "echo:...": writes the body to the output file
+//
+// The body is read by SQSNOTIFY2_HELPER_INPUT, same as -input.  It acts as a
+// co-process when SQSNOTIFY2_HELPER_INPUT is "coprocess".
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("SQSNOTIFY2_HELPER_PROCESS") != "1" {
+		return
+	}
+	if os.Getenv("SQSNOTIFY2_HELPER_INPUT") == "coprocess" {
+		helperCoprocess()
+		os.Exit(0)
+	}
+	b := helperBody()
+	if strings.HasPrefix(b, "echo:") {
+		writeHelperOutput(b)
+		os.Exit(0)
+	}
+	switch b {
+	case "fail":
+		os.Exit(1)
+	case "tempfail":
+		os.Exit(EXTempFail)
+	case "trap":
+		signal.Ignore(syscall.SIGTERM)
+		time.Sleep(10 * time.Second)
+	case "sleep":
+		time.Sleep(10 * time.Second)
+	case "spawn":
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+		cmd.Stdin = strings.NewReader("sleep")
+		helperSpawn(cmd)
+	case "spawn-trap":
+		helperSpawn(exec.Command("sh", "-c", "trap '' TERM; exec sleep 10"))
+	case "env":
+		writeHelperOutput(os.Getenv("SQSNOTIFY2_HELPER_VALUE"))
+	case "rlimit":
+		writeHelperOutput(helperRlimit())
+	case "rlimit-spawn":
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+		cmd.Stdin = strings.NewReader("rlimit")
+		if err := cmd.Run(); err != nil {
+			os.Exit(2)
+		}
+	case "trace":
+		writeHelperOutput(os.Getenv(TraceparentEnvName) + "|" + os.Getenv(AmznTraceIDEnvName))
+	}
+	os.Exit(0)
+}
+
+func helperSpawn(cmd *exec.Cmd) {
+	if err := cmd.Start(); err != nil {
+		os.Exit(2)
+	}
+	writeHelperOutput(strconv.Itoa(cmd.Process.Pid))
+	time.Sleep(10 * time.Second)
+}
+
+func helperBody() string {
+	last := os.Args[len(os.Args)-1]
+	switch os.Getenv("SQSNOTIFY2_HELPER_INPUT") {
+	case "file":
+		b, _ := os.ReadFile(last)
+		return string(b)
+	case "arg":
+		return last
+	case "env":
+		return os.Getenv(BodyEnvName)
+	}
+	b, _ := io.ReadAll(os.Stdin)
+	return string(b)
+}
+
+func writeHelperOutput(s string) {
+	os.WriteFile(os.Getenv("SQSNOTIFY2_HELPER_OUTPUT"), []byte(s), 0644)
+}
+
+func execTestCmd(t *testing.T, sn *SQSNotify, body string) error {
+	t.Helper()
+	return sn.execCmd(context.Background(), &sqs.Message{
+		MessageId: aws.String("test"),
+		Body:      aws.String(body),
+	})
+}
+
+func newTestSQSNotify(t *testing.T, rp RemovePolicy) *SQSNotify {
+	t.Helper()
+	t.Setenv("SQSNOTIFY2_HELPER_PROCESS", "1")
+	cfg := NewConfig()
+	cfg.QueueName = "test"
+	cfg.CreateQueue = true
+	cfg.WaitTime = aws.Int64(1)
+	cfg.RemovePolicy = rp
+	cfg.CmdName = os.Args[0]
+	cfg.CmdArgs = []string{"-test.run=^TestHelperProcess$"}
+	sn := New(cfg)
+	sn.cache = newMemoryCache(minCapacity)
+	return sn
+}
+
+// totalReceived returns total times of receives of messages in a queue.
+func totalReceived(api *sqstest.SQS, queueName string) int {
+	n := 0
+	for _, c := range api.ReceiveCounts(queueName) {
+		n += c
+	}
+	return n
+}
+
+func sendBodies(t *testing.T, api *sqstest.SQS, bodies ...string) {
+	t.Helper()
+	out, err := api.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String("test")})
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	for _, b := range bodies {
+		_, err := api.SendMessage(&sqs.SendMessageInput{
+			QueueUrl:    out.QueueUrl,
+			MessageBody: aws.String(b),
+		})
+		if err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+	}
+}
+
+// runUntil runs sn until cond is satisfied.  The test fails when run stops
+// before it, or run fails by other than the cancel.
+func runUntil(t *testing.T, sn *SQSNotify, api *sqstest.SQS, cond func() bool) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- sn.run(ctx, api)
+	}()
+	timeout := time.After(10 * time.Second)
+	for !cond() {
+		select {
+		case err := <-errCh:
+			t.Fatalf("run stopped before the condition is satisfied: %v", err)
+		case <-timeout:
+			t.Fatal("timed out")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+	if err := <-errCh; err != nil && !isCanceled(err) {
+		t.Fatalf("run failed: %v", err)
+	}
+}
+
+// runError runs sn until it stops, and returns an error of run.
+func runError(t *testing.T, sn *SQSNotify, api *sqstest.SQS) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := sn.run(ctx, api)
+	if ctx.Err() != nil {
+		t.Fatal("timed out")
+	}
+	return err
+}
+
+// isCanceled checks err is caused by the cancel of context.
+func isCanceled(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return true
+	}
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == request.CanceledErrorCode
+}
+
+func stageOf(t *testing.T, c Cache, id string) stage.Stage {
+	t.Helper()
+	item, err := c.Get(id)
+	if err != nil {
+		t.Fatalf("failed to get cache: %v", err)
+	}
+	if item == nil {
+		return stage.None
+	}
+	return item.Stage
+}
+
+func TestRunSucceed(t *testing.T) {
+	api := sqstest.New()
+	sendBodies(t, api, "ok", "fail")
+	sn := newTestSQSNotify(t, Succeed)
+	runUntil(t, sn, api, func() bool {
+		return api.Stats("test") == sqstest.Stats{InFlight: 1}
+	})
+	// a succeeded message is deleted, and a failed one is left.
+	ids := []string{"00000000-0000-0000-0000-000000000001", "00000000-0000-0000-0000-000000000002"}
+	if stg := stageOf(t, sn.cache, ids[0]); stg != stage.Done {
+		t.Errorf("unexpected stage for succeeded: %s", stg)
+	}
+	if stg := stageOf(t, sn.cache, ids[1]); stg != stage.Exec {
+		t.Errorf("unexpected stage for failed: %s", stg)
+	}
+	if counts := api.ReceiveCounts("test"); counts[ids[1]] != 1 {
+		t.Errorf("unexpected receive counts: %v", counts)
+	}
+}
+
+func TestRunIgnoreFailure(t *testing.T) {
+	api := sqstest.New()
+	sendBodies(t, api, "ok", "fail")
+	sn := newTestSQSNotify(t, IgnoreFailure)
+	runUntil(t, sn, api, func() bool {
+		return api.Stats("test") == sqstest.Stats{}
+	})
+}
+
+func TestRunBeforeExecution(t *testing.T) {
+	api := sqstest.New()
+	sendBodies(t, api, "ok", "fail")
+	sn := newTestSQSNotify(t, BeforeExecution)
+	runUntil(t, sn, api, func() bool {
+		return stageOf(t, sn.cache, "00000000-0000-0000-0000-000000000001") == stage.Done
+	})
+	if st := api.Stats("test"); st != (sqstest.Stats{}) {
+		t.Errorf("messages should be deleted: %+v", st)
+	}
+}
+
+func TestRunDeleteFailure(t *testing.T) {
+	api := sqstest.New()
+	api.FailDelete = func(string, string) bool { return true }
+	sendBodies(t, api, "ok")
+	sn := newTestSQSNotify(t, Succeed)
+	err := runError(t, sn, api)
+	if _, ok := err.(*deleteFailure); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRunDuplicateByStage(t *testing.T) {
+	api := sqstest.New()
+	sendBodies(t, api, "done", "exec")
+	sn := newTestSQSNotify(t, Succeed)
+	sn.DuplicatePolicy = ByStage
+	sn.DuplicateVisibility = time.Minute
+	ids := []string{"00000000-0000-0000-0000-000000000001", "00000000-0000-0000-0000-000000000002"}
+	sn.cache.Insert(ids[0], stage.Done)
+	sn.cache.Insert(ids[1], stage.Exec)
+	runUntil(t, sn, api, func() bool {
+		return api.Stats("test") == sqstest.Stats{InFlight: 1}
+	})
+	// the message in Exec is kept with extended visibility.
+	api.Advance(30 * time.Second)
+	if st := api.Stats("test"); st != (sqstest.Stats{InFlight: 1}) {
+		t.Errorf("visibility should be extended: %+v", st)
+	}
+}
+
+func TestRunDuplicateBeforeExecution(t *testing.T) {
+	api := sqstest.New()
+	sendBodies(t, api, "exec")
+	sn := newTestSQSNotify(t, BeforeExecution)
+	sn.DuplicatePolicy = ByStage
+	sn.DuplicateVisibility = time.Minute
+	var buf bytes.Buffer
+	sn.Logger = log.New(&buf, "", 0)
+	sn.cache.Insert("00000000-0000-0000-0000-000000000001", stage.Exec)
+	runUntil(t, sn, api, func() bool {
+		return api.Stats("test") == sqstest.Stats{}
+	})
+	// the message has been deleted, its visibility can't be changed.
+	if s := buf.String(); strings.Contains(s, "failed to extend visibility") {
+		t.Errorf("visibility of deleted message should not be changed:\n%s", s)
+	}
+}
+
+func TestRunMaxMessages(t *testing.T) {
+	api := sqstest.New()
+	sendBodies(t, api, "sleep", "sleep", "sleep")
+	sn := newTestSQSNotify(t, Succeed)
+	sn.MaxMessages = 1
+	total := func() int { return totalReceived(api, "test") }
+	runUntil(t, sn, api, func() bool {
+		return total() > 0
+	})
+	// messages are received one by one.
+	if n := total(); n != 1 {
+		t.Errorf("unexpected received messages: %v", api.ReceiveCounts("test"))
+	}
+}
+
+func TestRunDedupKeyFallback(t *testing.T) {
+	api := sqstest.New()
+	sendBodies(t, api, "not JSON")
+	sn := newTestSQSNotify(t, Succeed)
+	sn.DedupKey = "json:order.id"
+	id := "00000000-0000-0000-0000-000000000001"
+	// a message without the key is executed and deleted by its ID.
+	runUntil(t, sn, api, func() bool {
+		return stageOf(t, sn.cache, id) == stage.Done && api.Stats("test") == sqstest.Stats{}
+	})
+}
+
+func TestRunRenewLeaseWhileWaiting(t *testing.T) {
+	api := sqstest.New()
+	sendBodies(t, api, "sleep", "sleep")
+	sn := newTestSQSNotify(t, Succeed)
+	sn.Workers = 1
+	mc := newMemoryCache(minCapacity)
+	mc.lease = 300 * time.Millisecond
+	sn.cache = mc
+	ids := []string{"00000000-0000-0000-0000-000000000001", "00000000-0000-0000-0000-000000000002"}
+	start := time.Now()
+	var expired []string
+	// one of messages waits for a worker longer than the lease.
+	runUntil(t, sn, api, func() bool {
+		if time.Since(start) < time.Second {
+			return false
+		}
+		for _, id := range ids {
+			item, err := mc.Get(id)
+			if err != nil || item == nil || !item.Lease.After(time.Now()) {
+				expired = append(expired, id)
+			}
+		}
+		return true
+	})
+	if len(expired) > 0 {
+		t.Errorf("leases should be renewed: %q", expired)
+	}
+}
+
+func TestVisibilitySeconds(t *testing.T) {
+	for _, d := range []struct {
+		d    time.Duration
+		want int64
+	}{
+		{0, 1},
+		{-time.Second, 1},
+		{500 * time.Millisecond, 1},
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+		{30 * time.Second, 30},
+	} {
+		if got := visibilitySeconds(d.d); got != d.want {
+			t.Errorf("unexpected seconds for %s: want=%d got=%d", d.d, d.want, got)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package sqstest
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Types of requests and responses on the wire.  They are decoded from JSON
+// or Query requests, and encoded to JSON or XML responses.  Names of fields
+// are same as JSON, and "query" and "xml" tags give names for Query
+// protocol when they differ.
+
+// blob is binary data, which is encoded by base64 in all protocols.
+type blob []byte
+
+func (b blob) MarshalText() ([]byte, error) {
+	return []byte(base64.StdEncoding.EncodeToString(b)), nil
+}
+
+func (b *blob) UnmarshalText(text []byte) error {
+	v, err := base64.StdEncoding.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+func (b *blob) unmarshalQuery(v url.Values, key string) error {
+	if !v.Has(key) {
+		return nil
+	}
+	return b.UnmarshalText([]byte(v.Get(key)))
+}
+
+// attributeMap is a map of attributes, which are "Name" and "Value" pairs in
+// Query protocol.
+type attributeMap map[string]string
+
+func (m attributeMap) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalPairs(e, start, m, "Name")
+}
+
+func (m *attributeMap) unmarshalQuery(v url.Values, key string) error {
+	return unmarshalPairs(v, key, "Name", (*map[string]string)(m))
+}
+
+// tagMap is a map of tags, which are "Key" and "Value" pairs in Query
+// protocol.
+type tagMap map[string]string
+
+func (m tagMap) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalPairs(e, start, m, "Key")
+}
+
+func (m *tagMap) unmarshalQuery(v url.Values, key string) error {
+	return unmarshalPairs(v, key, "Key", (*map[string]string)(m))
+}
+
+// marshalPairs encodes m as flattened elements in order of keys.
+func marshalPairs(e *xml.Encoder, start xml.StartElement, m map[string]string, keyName string) error {
+	for _, k := range sortedKeys(m) {
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		if err := e.EncodeElement(k, xml.StartElement{Name: xml.Name{Local: keyName}}); err != nil {
+			return err
+		}
+		if err := e.EncodeElement(m[k], xml.StartElement{Name: xml.Name{Local: "Value"}}); err != nil {
+			return err
+		}
+		if err := e.EncodeToken(start.End()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmarshalPairs decodes "{key}.N.{keyName}" and "{key}.N.Value" to m.
+func unmarshalPairs(v url.Values, key, keyName string, m *map[string]string) error {
+	for n := 1; ; n++ {
+		p := fmt.Sprintf("%s.%d.", key, n)
+		if !v.Has(p + keyName) {
+			return nil
+		}
+		if *m == nil {
+			*m = map[string]string{}
+		}
+		(*m)[v.Get(p+keyName)] = v.Get(p + "Value")
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// messageAttributeValue is a value of message attribute, and message system
+// attribute.
+type messageAttributeValue struct {
+	DataType    *string
+	StringValue *string `json:",omitempty" xml:",omitempty"`
+	BinaryValue blob    `json:",omitempty" xml:",omitempty"`
+}
+
+// messageAttributeMap is a map of message attributes, which are "Name" and
+// "Value" pairs in Query protocol.
+type messageAttributeMap map[string]*messageAttributeValue
+
+func (m messageAttributeMap) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	for _, k := range sortedKeys(m) {
+		err := e.EncodeElement(struct {
+			Name  string
+			Value *messageAttributeValue
+		}{k, m[k]}, start)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *messageAttributeMap) unmarshalQuery(v url.Values, key string) error {
+	for n := 1; ; n++ {
+		p := fmt.Sprintf("%s.%d.", key, n)
+		if !v.Has(p + "Name") {
+			return nil
+		}
+		val := &messageAttributeValue{}
+		if err := unmarshalQuery(v, p+"Value.", val); err != nil {
+			return err
+		}
+		if *m == nil {
+			*m = messageAttributeMap{}
+		}
+		(*m)[v.Get(p+"Name")] = val
+	}
+}
+
+func (m messageAttributeMap) toSQS() map[string]*sqs.MessageAttributeValue {
+	if m == nil {
+		return nil
+	}
+	r := make(map[string]*sqs.MessageAttributeValue, len(m))
+	for k, v := range m {
+		r[k] = &sqs.MessageAttributeValue{
+			DataType:    v.DataType,
+			StringValue: v.StringValue,
+			BinaryValue: v.BinaryValue,
+		}
+	}
+	return r
+}
+
+func (m messageAttributeMap) toSQSSystem() map[string]*sqs.MessageSystemAttributeValue {
+	if m == nil {
+		return nil
+	}
+	r := make(map[string]*sqs.MessageSystemAttributeValue, len(m))
+	for k, v := range m {
+		r[k] = &sqs.MessageSystemAttributeValue{
+			DataType:    v.DataType,
+			StringValue: v.StringValue,
+			BinaryValue: v.BinaryValue,
+		}
+	}
+	return r
+}
+
+func messageAttributesOf(m map[string]*sqs.MessageAttributeValue) messageAttributeMap {
+	if m == nil {
+		return nil
+	}
+	r := make(messageAttributeMap, len(m))
+	for k, v := range m {
+		r[k] = &messageAttributeValue{
+			DataType:    v.DataType,
+			StringValue: v.StringValue,
+			BinaryValue: v.BinaryValue,
+		}
+	}
+	return r
+}
+
+func stringMap(m map[string]string) map[string]*string {
+	if m == nil {
+		return nil
+	}
+	return aws.StringMap(m)
+}
+
+// requests.
+
+type createQueueInput struct {
+	QueueName  *string
+	Attributes attributeMap `query:"Attribute"`
+	Tags       tagMap       `json:"tags" query:"Tag"`
+}
+
+type getQueueURLInput struct {
+	QueueName              *string
+	QueueOwnerAWSAccountId *string
+}
+
+type listQueuesInput struct {
+	QueueNamePrefix *string
+	MaxResults      *int64
+	NextToken       *string
+}
+
+type queueURLInput struct {
+	QueueUrl *string
+}
+
+type getQueueAttributesInput struct {
+	QueueUrl       *string
+	AttributeNames []*string `query:"AttributeName"`
+}
+
+type setQueueAttributesInput struct {
+	QueueUrl   *string
+	Attributes attributeMap `query:"Attribute"`
+}
+
+type tagQueueInput struct {
+	QueueUrl *string
+	Tags     tagMap `query:"Tag"`
+}
+
+type sendMessageInput struct {
+	QueueUrl                *string
+	MessageBody             *string
+	DelaySeconds            *int64
+	MessageAttributes       messageAttributeMap `query:"MessageAttribute"`
+	MessageSystemAttributes messageAttributeMap `query:"MessageSystemAttribute"`
+	MessageDeduplicationId  *string
+	MessageGroupId          *string
+}
+
+type sendMessageBatchRequestEntry struct {
+	Id                      *string
+	MessageBody             *string
+	DelaySeconds            *int64
+	MessageAttributes       messageAttributeMap `query:"MessageAttribute"`
+	MessageSystemAttributes messageAttributeMap `query:"MessageSystemAttribute"`
+	MessageDeduplicationId  *string
+	MessageGroupId          *string
+}
+
+type sendMessageBatchInput struct {
+	QueueUrl *string
+	Entries  []*sendMessageBatchRequestEntry `query:"SendMessageBatchRequestEntry"`
+}
+
+type receiveMessageInput struct {
+	QueueUrl                    *string
+	AttributeNames              []*string `query:"AttributeName"`
+	MessageSystemAttributeNames []*string `query:"MessageSystemAttributeName"`
+	MessageAttributeNames       []*string `query:"MessageAttributeName"`
+	MaxNumberOfMessages         *int64
+	VisibilityTimeout           *int64
+	WaitTimeSeconds             *int64
+	ReceiveRequestAttemptId     *string
+}
+
+type changeMessageVisibilityInput struct {
+	QueueUrl          *string
+	ReceiptHandle     *string
+	VisibilityTimeout *int64
+}
+
+type changeMessageVisibilityBatchRequestEntry struct {
+	Id                *string
+	ReceiptHandle     *string
+	VisibilityTimeout *int64
+}
+
+type changeMessageVisibilityBatchInput struct {
+	QueueUrl *string
+	Entries  []*changeMessageVisibilityBatchRequestEntry `query:"ChangeMessageVisibilityBatchRequestEntry"`
+}
+
+type deleteMessageInput struct {
+	QueueUrl      *string
+	ReceiptHandle *string
+}
+
+type deleteMessageBatchRequestEntry struct {
+	Id            *string
+	ReceiptHandle *string
+}
+
+type deleteMessageBatchInput struct {
+	QueueUrl *string
+	Entries  []*deleteMessageBatchRequestEntry `query:"DeleteMessageBatchRequestEntry"`
+}
+
+// responses.
+
+type queueURLOutput struct {
+	QueueUrl *string
+}
+
+type listQueuesOutput struct {
+	QueueUrls []*string `json:",omitempty" xml:"QueueUrl"`
+	NextToken *string   `json:",omitempty"`
+}
+
+type getQueueAttributesOutput struct {
+	Attributes attributeMap `json:",omitempty" xml:"Attribute"`
+}
+
+type listQueueTagsOutput struct {
+	Tags tagMap `json:",omitempty" xml:"Tag"`
+}
+
+type sendMessageOutput struct {
+	MessageId              *string
+	MD5OfMessageBody       *string
+	MD5OfMessageAttributes *string `json:",omitempty"`
+	SequenceNumber         *string `json:",omitempty"`
+}
+
+type sendMessageBatchResultEntry struct {
+	Id                     *string
+	MessageId              *string
+	MD5OfMessageBody       *string
+	MD5OfMessageAttributes *string `json:",omitempty"`
+	SequenceNumber         *string `json:",omitempty"`
+}
+
+type batchResultErrorEntry struct {
+	Id          *string
+	Code        *string
+	Message     *string `json:",omitempty"`
+	SenderFault *bool
+}
+
+type sendMessageBatchOutput struct {
+	Successful []*sendMessageBatchResultEntry `xml:"SendMessageBatchResultEntry"`
+	Failed     []*batchResultErrorEntry       `xml:"BatchResultErrorEntry"`
+}
+
+type receivedMessage struct {
+	MessageId              *string
+	ReceiptHandle          *string
+	MD5OfBody              *string
+	Body                   *string
+	Attributes             attributeMap        `json:",omitempty" xml:"Attribute"`
+	MD5OfMessageAttributes *string             `json:",omitempty"`
+	MessageAttributes      messageAttributeMap `json:",omitempty" xml:"MessageAttribute"`
+}
+
+type receiveMessageOutput struct {
+	Messages []*receivedMessage `json:",omitempty" xml:"Message"`
+}
+
+type batchResultEntry struct {
+	Id *string
+}
+
+type changeMessageVisibilityBatchOutput struct {
+	Successful []*batchResultEntry      `xml:"ChangeMessageVisibilityBatchResultEntry"`
+	Failed     []*batchResultErrorEntry `xml:"BatchResultErrorEntry"`
+}
+
+type deleteMessageBatchOutput struct {
+	Successful []*batchResultEntry      `xml:"DeleteMessageBatchResultEntry"`
+	Failed     []*batchResultErrorEntry `xml:"BatchResultErrorEntry"`
+}
+
+func batchErrorsOf(entries []*sqs.BatchResultErrorEntry) []*batchResultErrorEntry {
+	r := make([]*batchResultErrorEntry, 0, len(entries))
+	for _, e := range entries {
+		r = append(r, &batchResultErrorEntry{
+			Id:          e.Id,
+			Code:        e.Code,
+			Message:     e.Message,
+			SenderFault: e.SenderFault,
+		})
+	}
+	return r
+}
+
+func batchEntriesOf(ids []*string) []*batchResultEntry {
+	r := make([]*batchResultEntry, 0, len(ids))
+	for _, id := range ids {
+		r = append(r, &batchResultEntry{Id: id})
+	}
+	return r
+}
@@ -0,0 +1,137 @@
+package sqstest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// sqsNamespace is a namespace of XML for SQS in Query protocol.
+const sqsNamespace = "http://queue.amazonaws.com/doc/2012-11-05/"
+
+// queryUnmarshaler is implemented by types which have their own forms in
+// Query protocol.
+type queryUnmarshaler interface {
+	unmarshalQuery(v url.Values, key string) error
+}
+
+// unmarshalQuery decodes parameters of Query protocol to a struct pointed by
+// dst.  Names of fields are prefixed by prefix, and lists are flattened like
+// "{NAME}.1", "{NAME}.2".
+func unmarshalQuery(v url.Values, prefix string, dst interface{}) error {
+	rv := reflect.ValueOf(dst).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		name := f.Tag.Get("query")
+		if name == "" {
+			name = f.Name
+		}
+		if err := unmarshalQueryValue(v, prefix+name, rv.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unmarshalQueryValue(v url.Values, key string, fv reflect.Value) error {
+	if u, ok := fv.Addr().Interface().(queryUnmarshaler); ok {
+		return u.unmarshalQuery(v, key)
+	}
+	switch fv.Kind() {
+	case reflect.Ptr:
+		if fv.Type().Elem().Kind() == reflect.Struct {
+			p := reflect.New(fv.Type().Elem())
+			if err := unmarshalQuery(v, key+".", p.Interface()); err != nil {
+				return err
+			}
+			fv.Set(p)
+			return nil
+		}
+		if !v.Has(key) {
+			return nil
+		}
+		p := reflect.New(fv.Type().Elem())
+		if err := unmarshalQueryValue(v, key, p.Elem()); err != nil {
+			return err
+		}
+		fv.Set(p)
+	case reflect.Slice:
+		for n := 1; hasQueryKey(v, fmt.Sprintf("%s.%d", key, n)); n++ {
+			ev := reflect.New(fv.Type().Elem()).Elem()
+			if err := unmarshalQueryValue(v, fmt.Sprintf("%s.%d", key, n), ev); err != nil {
+				return err
+			}
+			fv.Set(reflect.Append(fv, ev))
+		}
+	case reflect.String:
+		fv.SetString(v.Get(key))
+	case reflect.Int64:
+		n, err := strconv.ParseInt(v.Get(key), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %s", key, err)
+		}
+		fv.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(v.Get(key))
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %s", key, err)
+		}
+		fv.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type for %s: %s", key, fv.Type())
+	}
+	return nil
+}
+
+// hasQueryKey checks v has key, or keys of its members.
+func hasQueryKey(v url.Values, key string) bool {
+	if v.Has(key) {
+		return true
+	}
+	for k := range v {
+		if strings.HasPrefix(k, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+type responseMetadata struct {
+	RequestId string
+}
+
+// marshalQueryResponse encodes a response of action in Query protocol.  out
+// is encoded as "{action}Result", it is omitted when out is nil.
+func marshalQueryResponse(action string, out interface{}, reqID string) ([]byte, error) {
+	var b bytes.Buffer
+	e := xml.NewEncoder(&b)
+	start := xml.StartElement{
+		Name: xml.Name{Local: action + "Response"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: sqsNamespace}},
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return nil, err
+	}
+	if out != nil {
+		err := e.EncodeElement(out, xml.StartElement{Name: xml.Name{Local: action + "Result"}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := e.EncodeElement(responseMetadata{RequestId: reqID}, xml.StartElement{Name: xml.Name{Local: "ResponseMetadata"}})
+	if err != nil {
+		return nil, err
+	}
+	if err := e.EncodeToken(start.End()); err != nil {
+		return nil, err
+	}
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
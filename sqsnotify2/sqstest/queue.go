@@ -0,0 +1,224 @@
+package sqstest
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// dedupInterval is the interval of deduplication for FIFO queues.
+const dedupInterval = 5 * time.Minute
+
+type message struct {
+	id      string
+	body    string
+	attrs   map[string]*sqs.MessageAttributeValue
+	sysAttr map[string]*sqs.MessageSystemAttributeValue
+	sent    time.Time
+
+	groupID string
+	dedupID string
+	seq     int64
+
+	visible      time.Time
+	receiveCount int
+	firstRecv    time.Time
+	receipt      string
+	inFlight     bool
+}
+
+func (m *message) md5OfBody() string {
+	sum := md5.Sum([]byte(m.body))
+	return hex.EncodeToString(sum[:])
+}
+
+// toSQS converts a message to be received, filtered by names of attributes.
+func (m *message) toSQS(attrNames, msgAttrNames []*string) *sqs.Message {
+	out := &sqs.Message{
+		MessageId:     aws.String(m.id),
+		ReceiptHandle: aws.String(m.receipt),
+		Body:          aws.String(m.body),
+		MD5OfBody:     aws.String(m.md5OfBody()),
+	}
+	sys := map[string]string{
+		sqs.MessageSystemAttributeNameApproximateReceiveCount:          strconv.Itoa(m.receiveCount),
+		sqs.MessageSystemAttributeNameSentTimestamp:                    strconv.FormatInt(m.sent.UnixMilli(), 10),
+		sqs.MessageSystemAttributeNameApproximateFirstReceiveTimestamp: strconv.FormatInt(m.firstRecv.UnixMilli(), 10),
+		sqs.MessageSystemAttributeNameSenderId:                         "sqstest",
+	}
+	if m.groupID != "" {
+		sys[sqs.MessageSystemAttributeNameMessageGroupId] = m.groupID
+		sys[sqs.MessageSystemAttributeNameMessageDeduplicationId] = m.dedupID
+		sys[sqs.MessageSystemAttributeNameSequenceNumber] = strconv.FormatInt(m.seq, 10)
+	}
+	for k, v := range m.sysAttr {
+		if v.StringValue != nil {
+			sys[k] = *v.StringValue
+		}
+	}
+	for k, v := range sys {
+		if matchName(attrNames, k) {
+			if out.Attributes == nil {
+				out.Attributes = map[string]*string{}
+			}
+			out.Attributes[k] = aws.String(v)
+		}
+	}
+	for k, v := range m.attrs {
+		if matchName(msgAttrNames, k) {
+			if out.MessageAttributes == nil {
+				out.MessageAttributes = map[string]*sqs.MessageAttributeValue{}
+			}
+			out.MessageAttributes[k] = v
+		}
+	}
+	return out
+}
+
+// matchName checks a name of attribute is requested or not.  "All", ".*"
+// and prefix with ".*" (ex. "foo.*") are supported.
+func matchName(names []*string, name string) bool {
+	for _, p := range names {
+		s := aws.StringValue(p)
+		switch {
+		case s == sqs.QueueAttributeNameAll, s == ".*", s == name:
+			return true
+		case strings.HasSuffix(s, ".*") && strings.HasPrefix(name, s[:len(s)-1]):
+			return true
+		}
+	}
+	return false
+}
+
+// queue is a model of SQS queue.
+type queue struct {
+	name    string
+	url     string
+	arn     string
+	created time.Time
+	attrs   map[string]string
+	tags    map[string]string
+
+	msgs  []*message
+	dedup map[string]time.Time
+	seq   int64
+}
+
+func (q *queue) fifo() bool {
+	return q.attrs[sqs.QueueAttributeNameFifoQueue] == "true"
+}
+
+func (q *queue) intAttr(name string, def int64) int64 {
+	s, ok := q.attrs[name]
+	if !ok {
+		return def
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+func (q *queue) visibilityTimeout() time.Duration {
+	return time.Duration(q.intAttr(sqs.QueueAttributeNameVisibilityTimeout, 30)) * time.Second
+}
+
+// redrive returns ARN of dead-letter queue and maxReceiveCount.
+func (q *queue) redrive() (string, int) {
+	s, ok := q.attrs[sqs.QueueAttributeNameRedrivePolicy]
+	if !ok {
+		return "", 0
+	}
+	var v struct {
+		DeadLetterTargetArn string      `json:"deadLetterTargetArn"`
+		MaxReceiveCount     json.Number `json:"maxReceiveCount"`
+	}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return "", 0
+	}
+	n, err := v.MaxReceiveCount.Int64()
+	if err != nil {
+		return "", 0
+	}
+	return v.DeadLetterTargetArn, int(n)
+}
+
+// dedupKey returns a deduplication ID for FIFO queue.
+func (q *queue) dedupKey(body string, dedupID *string) string {
+	if dedupID != nil {
+		return *dedupID
+	}
+	if q.attrs[sqs.QueueAttributeNameContentBasedDeduplication] == "true" {
+		sum := sha256.Sum256([]byte(body))
+		return hex.EncodeToString(sum[:])
+	}
+	return ""
+}
+
+// isDuplicated checks a message is sent in the deduplication interval.
+func (q *queue) isDuplicated(key string, now time.Time) bool {
+	if key == "" {
+		return false
+	}
+	for k, t := range q.dedup {
+		if now.Sub(t) >= dedupInterval {
+			delete(q.dedup, k)
+		}
+	}
+	_, ok := q.dedup[key]
+	if !ok {
+		q.dedup[key] = now
+	}
+	return ok
+}
+
+// blockedGroups returns FIFO groups which have messages in flight.
+func (q *queue) blockedGroups(now time.Time) map[string]bool {
+	blocked := map[string]bool{}
+	if !q.fifo() {
+		return blocked
+	}
+	for _, m := range q.msgs {
+		if m.inFlight && now.Before(m.visible) {
+			blocked[m.groupID] = true
+		}
+	}
+	return blocked
+}
+
+func (q *queue) find(receipt string) (int, *message) {
+	for i, m := range q.msgs {
+		if m.receipt == receipt && m.inFlight {
+			return i, m
+		}
+	}
+	return -1, nil
+}
+
+func (q *queue) remove(i int) {
+	q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+}
+
+// stats counts messages.
+func (q *queue) stats(now time.Time) Stats {
+	var st Stats
+	for _, m := range q.msgs {
+		switch {
+		case now.Before(m.visible) && m.inFlight:
+			st.InFlight++
+		case now.Before(m.visible):
+			st.Delayed++
+		default:
+			st.Visible++
+		}
+	}
+	return st
+}
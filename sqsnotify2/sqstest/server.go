@@ -0,0 +1,452 @@
+package sqstest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// targetPrefix is a prefix of X-Amz-Target header for SQS.
+const targetPrefix = "AmazonSQS."
+
+// jsonContentType is a content type of AWS JSON 1.0 protocol.
+const jsonContentType = "application/x-amz-json-1.0"
+
+// operation is an action of SQS.  It decodes a request to newInput(), and
+// call returns a response, or nil for an empty response.
+type operation struct {
+	newInput func() interface{}
+	call     func(ctx context.Context, in interface{}) (interface{}, error)
+}
+
+func op[I any](fn func(context.Context, *I) (interface{}, error)) operation {
+	return operation{
+		newInput: func() interface{} { return new(I) },
+		call: func(ctx context.Context, in interface{}) (interface{}, error) {
+			return fn(ctx, in.(*I))
+		},
+	}
+}
+
+// Server is an SQS compatible HTTP endpoint backed by SQS.
+//
+// It serves both AWS JSON 1.0 protocol, which is used by aws-sdk-go v1.50 or
+// later and current AWS CLI, and Query protocol (form encoded requests with
+// XML responses), which is used by older SDKs.  It also serves a stand-in of
+// STS for assuming roles by Query protocol.
+type Server struct {
+	s    *SQS
+	ops  map[string]operation
+	id   int64
+	keys int64
+}
+
+var _ http.Handler = (*Server)(nil)
+
+// NewServer creates a new Server for s.
+func NewServer(s *SQS) *Server {
+	sv := &Server{s: s}
+	sv.ops = map[string]operation{
+		"CreateQueue":                  op(sv.createQueue),
+		"GetQueueUrl":                  op(sv.getQueueURL),
+		"ListQueues":                   op(sv.listQueues),
+		"DeleteQueue":                  op(sv.deleteQueue),
+		"PurgeQueue":                   op(sv.purgeQueue),
+		"GetQueueAttributes":           op(sv.getQueueAttributes),
+		"SetQueueAttributes":           op(sv.setQueueAttributes),
+		"TagQueue":                     op(sv.tagQueue),
+		"ListQueueTags":                op(sv.listQueueTags),
+		"SendMessage":                  op(sv.sendMessage),
+		"SendMessageBatch":             op(sv.sendMessageBatch),
+		"ReceiveMessage":               op(sv.receiveMessage),
+		"ChangeMessageVisibility":      op(sv.changeMessageVisibility),
+		"ChangeMessageVisibilityBatch": op(sv.changeMessageVisibilityBatch),
+		"DeleteMessage":                op(sv.deleteMessage),
+		"DeleteMessageBatch":           op(sv.deleteMessageBatch),
+	}
+	return sv
+}
+
+// ServeHTTP serves a request of SQS.
+func (sv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reqID := sv.requestID()
+	w.Header().Set("X-Amzn-Requestid", reqID)
+	target := r.Header.Get("X-Amz-Target")
+	if r.Method == http.MethodPost && strings.HasPrefix(target, targetPrefix) {
+		sv.serveJSON(w, r, strings.TrimPrefix(target, targetPrefix))
+		return
+	}
+	if target == "" {
+		sv.serveQuery(w, r, reqID)
+		return
+	}
+	writeError(w, awserr.New("UnsupportedProtocol",
+		"only AWS JSON 1.0 protocol and Query protocol are supported", nil))
+}
+
+func (sv *Server) requestID() string {
+	n := atomic.AddInt64(&sv.id, 1)
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", n)
+}
+
+func errInvalidAction(action string) error {
+	return awserr.New("InvalidAction",
+		fmt.Sprintf("The action %s is not valid for this endpoint.", action), nil)
+}
+
+// serveJSON serves a request in AWS JSON 1.0 protocol.
+func (sv *Server) serveJSON(w http.ResponseWriter, r *http.Request, action string) {
+	o, ok := sv.ops[action]
+	if !ok {
+		writeError(w, errInvalidAction(action))
+		return
+	}
+	in := o.newInput()
+	if err := json.NewDecoder(r.Body).Decode(in); err != nil && err != io.EOF {
+		writeError(w, awserr.New("SerializationException", err.Error(), nil))
+		return
+	}
+	out, err := o.call(r.Context(), in)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	b := []byte("{}")
+	if out != nil {
+		b, err = json.Marshal(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+}
+
+// serveQuery serves a request in Query protocol.  Actions of STS are passed
+// to the stand-in of STS.
+func (sv *Server) serveQuery(w http.ResponseWriter, r *http.Request, reqID string) {
+	if err := r.ParseForm(); err != nil {
+		writeQueryError(w, awserr.New("MalformedQueryString", err.Error(), nil), reqID)
+		return
+	}
+	action := r.Form.Get("Action")
+	switch action {
+	case "AssumeRole", "AssumeRoleWithWebIdentity":
+		sv.serveSTS(w, r, reqID)
+		return
+	}
+	o, ok := sv.ops[action]
+	if !ok {
+		writeQueryError(w, errInvalidAction(action), reqID)
+		return
+	}
+	in := o.newInput()
+	if err := unmarshalQuery(r.Form, "", in); err != nil {
+		writeQueryError(w, awserr.New(sqs.ErrCodeInvalidAttributeValue, err.Error(), nil), reqID)
+		return
+	}
+	out, err := o.call(r.Context(), in)
+	if err != nil {
+		writeQueryError(w, err, reqID)
+		return
+	}
+	b, err := marshalQueryResponse(action, out, reqID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+}
+
+// jsonErrorTypes maps codes of errors in Query protocol to types in JSON
+// protocol.  Codes which are not listed are used as types as is.
+var jsonErrorTypes = map[string]string{
+	sqs.ErrCodeQueueDoesNotExist: "QueueDoesNotExist",
+}
+
+// errorStatus returns a code, a message and HTTP status for an error.
+func errorStatus(err error) (string, string, int) {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return "InternalFailure", err.Error(), http.StatusInternalServerError
+	}
+	if aerr.Code() == request.CanceledErrorCode {
+		// the client has gone, no one receives this.
+		return aerr.Code(), aerr.Message(), http.StatusInternalServerError
+	}
+	return aerr.Code(), aerr.Message(), http.StatusBadRequest
+}
+
+// writeError writes an error in JSON protocol.  Its code in Query protocol
+// is also given by x-amzn-query-error header, so clients see same codes as
+// SQS returns.
+func writeError(w http.ResponseWriter, err error) {
+	code, msg, status := errorStatus(err)
+	typ, ok := jsonErrorTypes[code]
+	if !ok {
+		typ = code
+	}
+	b, _ := json.Marshal(map[string]string{"__type": typ, "message": msg})
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("X-Amzn-Query-Error", code+";Sender")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// writeQueryError writes an error in Query protocol.
+func writeQueryError(w http.ResponseWriter, err error, reqID string) {
+	code, msg, status := errorStatus(err)
+	writeErrorResponse(w, sqsNamespace, status, code, msg, reqID)
+}
+
+func (sv *Server) createQueue(ctx context.Context, in *createQueueInput) (interface{}, error) {
+	out, err := sv.s.CreateQueueWithContext(ctx, &sqs.CreateQueueInput{
+		QueueName:  in.QueueName,
+		Attributes: stringMap(in.Attributes),
+		Tags:       stringMap(in.Tags),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &queueURLOutput{QueueUrl: out.QueueUrl}, nil
+}
+
+func (sv *Server) getQueueURL(ctx context.Context, in *getQueueURLInput) (interface{}, error) {
+	out, err := sv.s.GetQueueUrlWithContext(ctx, &sqs.GetQueueUrlInput{
+		QueueName:              in.QueueName,
+		QueueOwnerAWSAccountId: in.QueueOwnerAWSAccountId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &queueURLOutput{QueueUrl: out.QueueUrl}, nil
+}
+
+func (sv *Server) listQueues(ctx context.Context, in *listQueuesInput) (interface{}, error) {
+	out, err := sv.s.ListQueuesWithContext(ctx, &sqs.ListQueuesInput{
+		QueueNamePrefix: in.QueueNamePrefix,
+		MaxResults:      in.MaxResults,
+		NextToken:       in.NextToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &listQueuesOutput{QueueUrls: out.QueueUrls, NextToken: out.NextToken}, nil
+}
+
+func (sv *Server) deleteQueue(ctx context.Context, in *queueURLInput) (interface{}, error) {
+	_, err := sv.s.DeleteQueueWithContext(ctx, &sqs.DeleteQueueInput{QueueUrl: in.QueueUrl})
+	return nil, err
+}
+
+func (sv *Server) purgeQueue(ctx context.Context, in *queueURLInput) (interface{}, error) {
+	_, err := sv.s.PurgeQueueWithContext(ctx, &sqs.PurgeQueueInput{QueueUrl: in.QueueUrl})
+	return nil, err
+}
+
+func (sv *Server) getQueueAttributes(ctx context.Context, in *getQueueAttributesInput) (interface{}, error) {
+	out, err := sv.s.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       in.QueueUrl,
+		AttributeNames: in.AttributeNames,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &getQueueAttributesOutput{Attributes: aws.StringValueMap(out.Attributes)}, nil
+}
+
+func (sv *Server) setQueueAttributes(ctx context.Context, in *setQueueAttributesInput) (interface{}, error) {
+	_, err := sv.s.SetQueueAttributesWithContext(ctx, &sqs.SetQueueAttributesInput{
+		QueueUrl:   in.QueueUrl,
+		Attributes: stringMap(in.Attributes),
+	})
+	return nil, err
+}
+
+func (sv *Server) tagQueue(ctx context.Context, in *tagQueueInput) (interface{}, error) {
+	_, err := sv.s.TagQueueWithContext(ctx, &sqs.TagQueueInput{
+		QueueUrl: in.QueueUrl,
+		Tags:     stringMap(in.Tags),
+	})
+	return nil, err
+}
+
+func (sv *Server) listQueueTags(ctx context.Context, in *queueURLInput) (interface{}, error) {
+	out, err := sv.s.ListQueueTagsWithContext(ctx, &sqs.ListQueueTagsInput{QueueUrl: in.QueueUrl})
+	if err != nil {
+		return nil, err
+	}
+	return &listQueueTagsOutput{Tags: aws.StringValueMap(out.Tags)}, nil
+}
+
+func (sv *Server) sendMessage(ctx context.Context, in *sendMessageInput) (interface{}, error) {
+	out, err := sv.s.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:                in.QueueUrl,
+		MessageBody:             in.MessageBody,
+		DelaySeconds:            in.DelaySeconds,
+		MessageAttributes:       in.MessageAttributes.toSQS(),
+		MessageSystemAttributes: in.MessageSystemAttributes.toSQSSystem(),
+		MessageDeduplicationId:  in.MessageDeduplicationId,
+		MessageGroupId:          in.MessageGroupId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sendMessageOutput{
+		MessageId:              out.MessageId,
+		MD5OfMessageBody:       out.MD5OfMessageBody,
+		MD5OfMessageAttributes: out.MD5OfMessageAttributes,
+		SequenceNumber:         out.SequenceNumber,
+	}, nil
+}
+
+func (sv *Server) sendMessageBatch(ctx context.Context, in *sendMessageBatchInput) (interface{}, error) {
+	entries := make([]*sqs.SendMessageBatchRequestEntry, 0, len(in.Entries))
+	for _, e := range in.Entries {
+		entries = append(entries, &sqs.SendMessageBatchRequestEntry{
+			Id:                      e.Id,
+			MessageBody:             e.MessageBody,
+			DelaySeconds:            e.DelaySeconds,
+			MessageAttributes:       e.MessageAttributes.toSQS(),
+			MessageSystemAttributes: e.MessageSystemAttributes.toSQSSystem(),
+			MessageDeduplicationId:  e.MessageDeduplicationId,
+			MessageGroupId:          e.MessageGroupId,
+		})
+	}
+	out, err := sv.s.SendMessageBatchWithContext(ctx, &sqs.SendMessageBatchInput{
+		QueueUrl: in.QueueUrl,
+		Entries:  entries,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r := &sendMessageBatchOutput{
+		Successful: make([]*sendMessageBatchResultEntry, 0, len(out.Successful)),
+		Failed:     batchErrorsOf(out.Failed),
+	}
+	for _, e := range out.Successful {
+		r.Successful = append(r.Successful, &sendMessageBatchResultEntry{
+			Id:                     e.Id,
+			MessageId:              e.MessageId,
+			MD5OfMessageBody:       e.MD5OfMessageBody,
+			MD5OfMessageAttributes: e.MD5OfMessageAttributes,
+			SequenceNumber:         e.SequenceNumber,
+		})
+	}
+	return r, nil
+}
+
+func (sv *Server) receiveMessage(ctx context.Context, in *receiveMessageInput) (interface{}, error) {
+	out, err := sv.s.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:                    in.QueueUrl,
+		AttributeNames:              in.AttributeNames,
+		MessageSystemAttributeNames: in.MessageSystemAttributeNames,
+		MessageAttributeNames:       in.MessageAttributeNames,
+		MaxNumberOfMessages:         in.MaxNumberOfMessages,
+		VisibilityTimeout:           in.VisibilityTimeout,
+		WaitTimeSeconds:             in.WaitTimeSeconds,
+		ReceiveRequestAttemptId:     in.ReceiveRequestAttemptId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r := &receiveMessageOutput{}
+	for _, m := range out.Messages {
+		var attrs attributeMap
+		if m.Attributes != nil {
+			attrs = aws.StringValueMap(m.Attributes)
+		}
+		r.Messages = append(r.Messages, &receivedMessage{
+			MessageId:              m.MessageId,
+			ReceiptHandle:          m.ReceiptHandle,
+			MD5OfBody:              m.MD5OfBody,
+			Body:                   m.Body,
+			Attributes:             attrs,
+			MD5OfMessageAttributes: m.MD5OfMessageAttributes,
+			MessageAttributes:      messageAttributesOf(m.MessageAttributes),
+		})
+	}
+	return r, nil
+}
+
+func (sv *Server) changeMessageVisibility(ctx context.Context, in *changeMessageVisibilityInput) (interface{}, error) {
+	_, err := sv.s.ChangeMessageVisibilityWithContext(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          in.QueueUrl,
+		ReceiptHandle:     in.ReceiptHandle,
+		VisibilityTimeout: in.VisibilityTimeout,
+	})
+	return nil, err
+}
+
+func (sv *Server) changeMessageVisibilityBatch(ctx context.Context, in *changeMessageVisibilityBatchInput) (interface{}, error) {
+	entries := make([]*sqs.ChangeMessageVisibilityBatchRequestEntry, 0, len(in.Entries))
+	for _, e := range in.Entries {
+		entries = append(entries, &sqs.ChangeMessageVisibilityBatchRequestEntry{
+			Id:                e.Id,
+			ReceiptHandle:     e.ReceiptHandle,
+			VisibilityTimeout: e.VisibilityTimeout,
+		})
+	}
+	out, err := sv.s.ChangeMessageVisibilityBatchWithContext(ctx, &sqs.ChangeMessageVisibilityBatchInput{
+		QueueUrl: in.QueueUrl,
+		Entries:  entries,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ids []*string
+	for _, e := range out.Successful {
+		ids = append(ids, e.Id)
+	}
+	return &changeMessageVisibilityBatchOutput{
+		Successful: batchEntriesOf(ids),
+		Failed:     batchErrorsOf(out.Failed),
+	}, nil
+}
+
+func (sv *Server) deleteMessage(ctx context.Context, in *deleteMessageInput) (interface{}, error) {
+	_, err := sv.s.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      in.QueueUrl,
+		ReceiptHandle: in.ReceiptHandle,
+	})
+	return nil, err
+}
+
+func (sv *Server) deleteMessageBatch(ctx context.Context, in *deleteMessageBatchInput) (interface{}, error) {
+	entries := make([]*sqs.DeleteMessageBatchRequestEntry, 0, len(in.Entries))
+	for _, e := range in.Entries {
+		entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{
+			Id:            e.Id,
+			ReceiptHandle: e.ReceiptHandle,
+		})
+	}
+	out, err := sv.s.DeleteMessageBatchWithContext(ctx, &sqs.DeleteMessageBatchInput{
+		QueueUrl: in.QueueUrl,
+		Entries:  entries,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ids []*string
+	for _, e := range out.Successful {
+		ids = append(ids, e.Id)
+	}
+	return &deleteMessageBatchOutput{
+		Successful: batchEntriesOf(ids),
+		Failed:     batchErrorsOf(out.Failed),
+	}, nil
+}
@@ -0,0 +1,201 @@
+package sqstest
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestServer(t *testing.T) {
+	s := New()
+	ts := httptest.NewServer(NewServer(s))
+	defer ts.Close()
+	s.BaseURL = ts.URL + "/000000000000/"
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(ts.URL),
+		Credentials: credentials.NewStaticCredentials("dummy", "dummy", ""),
+	})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	api := sqs.New(sess)
+
+	_, err = api.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String("q1")})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != sqs.ErrCodeQueueDoesNotExist {
+		t.Fatalf("unexpected error for missing queue: %v", err)
+	}
+
+	cq, err := api.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String("q1")})
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	if want := s.BaseURL + "q1"; aws.StringValue(cq.QueueUrl) != want {
+		t.Fatalf("unexpected queue URL: got=%s want=%s", aws.StringValue(cq.QueueUrl), want)
+	}
+
+	_, err = api.SendMessageBatch(&sqs.SendMessageBatchInput{
+		QueueUrl: cq.QueueUrl,
+		Entries: []*sqs.SendMessageBatchRequestEntry{
+			{Id: aws.String("0"), MessageBody: aws.String("foo")},
+			{Id: aws.String("1"), MessageBody: aws.String("bar"),
+				MessageAttributes: map[string]*sqs.MessageAttributeValue{
+					"Key": {DataType: aws.String("String"), StringValue: aws.String("baz")},
+				}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to send messages: %v", err)
+	}
+
+	rm, err := api.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:              cq.QueueUrl,
+		MaxNumberOfMessages:   aws.Int64(10),
+		MessageAttributeNames: aws.StringSlice([]string{"All"}),
+	})
+	if err != nil {
+		t.Fatalf("failed to receive messages: %v", err)
+	}
+	if len(rm.Messages) != 2 {
+		t.Fatalf("unexpected number of messages: %d", len(rm.Messages))
+	}
+	m := rm.Messages[1]
+	if aws.StringValue(m.Body) != "bar" || aws.StringValue(m.MessageAttributes["Key"].StringValue) != "baz" {
+		t.Fatalf("unexpected message: %s", m)
+	}
+
+	_, err = api.DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl:      cq.QueueUrl,
+		ReceiptHandle: m.ReceiptHandle,
+	})
+	if err != nil {
+		t.Fatalf("failed to delete message: %v", err)
+	}
+	if st := s.Stats("q1"); st.InFlight != 1 || st.Visible != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+// postQuery posts a request in Query protocol, and decodes its response to
+// out.  It returns HTTP status of the response.
+func postQuery(t *testing.T, endpoint string, form url.Values, out interface{}) int {
+	t.Helper()
+	resp, err := http.PostForm(endpoint, form)
+	if err != nil {
+		t.Fatalf("failed to post %s: %v", form.Get("Action"), err)
+	}
+	defer resp.Body.Close()
+	if err := xml.NewDecoder(resp.Body).Decode(out); err != nil {
+		t.Fatalf("failed to decode response of %s: %v", form.Get("Action"), err)
+	}
+	return resp.StatusCode
+}
+
+func TestServerQuery(t *testing.T) {
+	s := New()
+	ts := httptest.NewServer(NewServer(s))
+	defer ts.Close()
+	s.BaseURL = ts.URL + "/000000000000/"
+
+	var errResp struct {
+		Code string `xml:"Error>Code"`
+	}
+	status := postQuery(t, ts.URL, url.Values{
+		"Action":    {"GetQueueUrl"},
+		"QueueName": {"q1"},
+	}, &errResp)
+	if status != http.StatusBadRequest || errResp.Code != sqs.ErrCodeQueueDoesNotExist {
+		t.Fatalf("unexpected error for missing queue: status=%d code=%s", status, errResp.Code)
+	}
+
+	var cq struct {
+		QueueUrl string `xml:"CreateQueueResult>QueueUrl"`
+	}
+	postQuery(t, ts.URL, url.Values{
+		"Action":            {"CreateQueue"},
+		"QueueName":         {"q1"},
+		"Attribute.1.Name":  {"VisibilityTimeout"},
+		"Attribute.1.Value": {"60"},
+	}, &cq)
+	if want := s.BaseURL + "q1"; cq.QueueUrl != want {
+		t.Fatalf("unexpected queue URL: got=%s want=%s", cq.QueueUrl, want)
+	}
+
+	var sm struct {
+		MessageId string `xml:"SendMessageResult>MessageId"`
+	}
+	postQuery(t, ts.URL, url.Values{
+		"Action":                               {"SendMessage"},
+		"QueueUrl":                             {cq.QueueUrl},
+		"MessageBody":                          {"foo"},
+		"MessageAttribute.1.Name":              {"Key"},
+		"MessageAttribute.1.Value.DataType":    {"String"},
+		"MessageAttribute.1.Value.StringValue": {"bar"},
+	}, &sm)
+	if sm.MessageId == "" {
+		t.Fatal("no message ID for SendMessage")
+	}
+
+	var rm struct {
+		Messages []struct {
+			MessageId     string
+			ReceiptHandle string
+			Body          string
+			Attributes    []struct {
+				Name  string
+				Value string
+			} `xml:"Attribute"`
+			MessageAttributes []struct {
+				Name  string
+				Value struct {
+					DataType    string
+					StringValue string
+				}
+			} `xml:"MessageAttribute"`
+		} `xml:"ReceiveMessageResult>Message"`
+	}
+	postQuery(t, ts.URL, url.Values{
+		"Action":                 {"ReceiveMessage"},
+		"QueueUrl":               {cq.QueueUrl},
+		"AttributeName.1":        {"ApproximateReceiveCount"},
+		"MessageAttributeName.1": {"All"},
+	}, &rm)
+	if len(rm.Messages) != 1 {
+		t.Fatalf("unexpected number of messages: %d", len(rm.Messages))
+	}
+	m := rm.Messages[0]
+	if m.MessageId != sm.MessageId || m.Body != "foo" {
+		t.Fatalf("unexpected message: %+v", m)
+	}
+	if len(m.Attributes) != 1 || m.Attributes[0].Name != "ApproximateReceiveCount" || m.Attributes[0].Value != "1" {
+		t.Fatalf("unexpected attributes: %+v", m.Attributes)
+	}
+	if len(m.MessageAttributes) != 1 || m.MessageAttributes[0].Name != "Key" || m.MessageAttributes[0].Value.StringValue != "bar" {
+		t.Fatalf("unexpected message attributes: %+v", m.MessageAttributes)
+	}
+
+	var dm struct {
+		Successful []string `xml:"DeleteMessageBatchResult>DeleteMessageBatchResultEntry>Id"`
+	}
+	postQuery(t, ts.URL, url.Values{
+		"Action":                              {"DeleteMessageBatch"},
+		"QueueUrl":                            {cq.QueueUrl},
+		"DeleteMessageBatchRequestEntry.1.Id": {"0"},
+		"DeleteMessageBatchRequestEntry.1.ReceiptHandle": {m.ReceiptHandle},
+	}, &dm)
+	if len(dm.Successful) != 1 || dm.Successful[0] != "0" {
+		t.Fatalf("unexpected result of DeleteMessageBatch: %+v", dm)
+	}
+	if st := s.Stats("q1"); st.InFlight != 0 || st.Visible != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
@@ -0,0 +1,709 @@
+// Package sqstest provides an in-memory fake of SQS, which implements
+// sqsiface.SQSAPI for offline and deterministic tests.
+//
+// It models visibility timeouts, receive counts, redrive policies (DLQ),
+// delays, FIFO groups and deduplication.  Time can be controlled by Now or
+// Advance, and failures of batch deletion can be injected by FailDelete.
+// Methods which are not implemented panic.
+//
+// Server serves it as an SQS compatible HTTP endpoint.
+package sqstest
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+const (
+	// DefaultBaseURL is the default prefix of queue URLs.
+	DefaultBaseURL = "http://sqstest.local/000000000000/"
+	// DefaultARNPrefix is the default prefix of queue ARNs.
+	DefaultARNPrefix = "arn:aws:sqs:us-east-1:000000000000:"
+)
+
+// pollInterval is an interval to check visibility of messages while long
+// polling.
+const pollInterval = 10 * time.Millisecond
+
+// SQS is an in-memory fake of SQS.
+type SQS struct {
+	// SQSAPI is embedded to satisfy sqsiface.SQSAPI, it is nil so methods
+	// which are not implemented panic.
+	sqsiface.SQSAPI
+
+	// BaseURL is a prefix of queue URLs.
+	BaseURL string
+	// ARNPrefix is a prefix of queue ARNs.
+	ARNPrefix string
+	// Now returns current time.  time.Now is used when it is nil.
+	Now func() time.Time
+	// FailDelete injects a failure of an entry of DeleteMessageBatch when it
+	// returns true.
+	FailDelete func(queueName, messageID string) bool
+
+	mu      sync.Mutex
+	queues  map[string]*queue
+	offset  time.Duration
+	lastID  int64
+	receipt int64
+	notify  chan struct{}
+}
+
+var _ sqsiface.SQSAPI = (*SQS)(nil)
+
+// New creates a new fake SQS.
+func New() *SQS {
+	return &SQS{
+		BaseURL:   DefaultBaseURL,
+		ARNPrefix: DefaultARNPrefix,
+		queues:    map[string]*queue{},
+		notify:    make(chan struct{}),
+	}
+}
+
+// Stats is numbers of messages in a queue.
+type Stats struct {
+	Visible  int
+	InFlight int
+	Delayed  int
+}
+
+// Advance advances the clock of fake.
+func (s *SQS) Advance(d time.Duration) {
+	s.mu.Lock()
+	s.offset += d
+	s.mu.Unlock()
+	s.wakeUp()
+}
+
+// Stats returns numbers of messages in a queue.
+func (s *SQS) Stats(queueName string) Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[queueName]
+	if !ok {
+		return Stats{}
+	}
+	return q.stats(s.now())
+}
+
+// ReceiveCounts returns receive counts of messages in a queue, by message
+// IDs.
+func (s *SQS) ReceiveCounts(queueName string) map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[queueName]
+	if !ok {
+		return nil
+	}
+	counts := make(map[string]int, len(q.msgs))
+	for _, m := range q.msgs {
+		counts[m.id] = m.receiveCount
+	}
+	return counts
+}
+
+func (s *SQS) now() time.Time {
+	if s.Now != nil {
+		return s.Now().Add(s.offset)
+	}
+	return time.Now().Add(s.offset)
+}
+
+// wakeUp wakes up receivers in long polling.
+func (s *SQS) wakeUp() {
+	s.mu.Lock()
+	close(s.notify)
+	s.notify = make(chan struct{})
+	s.mu.Unlock()
+}
+
+func (s *SQS) nextID() string {
+	s.lastID++
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", s.lastID)
+}
+
+func (s *SQS) nextReceipt(id string) string {
+	s.receipt++
+	return id + "#" + strconv.FormatInt(s.receipt, 10)
+}
+
+func errQueueDoesNotExist() error {
+	return awserr.New(sqs.ErrCodeQueueDoesNotExist, "The specified queue does not exist.", nil)
+}
+
+func errInvalidParameter(msg string) error {
+	return awserr.New("InvalidParameterValue", msg, nil)
+}
+
+func errReceiptHandle(receipt string) error {
+	return awserr.New(sqs.ErrCodeReceiptHandleIsInvalid, "The receipt handle is not valid: "+receipt, nil)
+}
+
+// getQueue gets a queue by URL.  It should be called with lock.
+func (s *SQS) getQueue(queueURL *string) (*queue, error) {
+	if queueURL == nil {
+		return nil, errQueueDoesNotExist()
+	}
+	q, ok := s.queues[path.Base(*queueURL)]
+	if !ok {
+		return nil, errQueueDoesNotExist()
+	}
+	return q, nil
+}
+
+func (s *SQS) queueByARN(arn string) *queue {
+	for _, q := range s.queues {
+		if q.arn == arn {
+			return q
+		}
+	}
+	return nil
+}
+
+// CreateQueue creates a queue.
+func (s *SQS) CreateQueue(in *sqs.CreateQueueInput) (*sqs.CreateQueueOutput, error) {
+	return s.CreateQueueWithContext(aws.BackgroundContext(), in)
+}
+
+// CreateQueueWithContext creates a queue.
+func (s *SQS) CreateQueueWithContext(ctx aws.Context, in *sqs.CreateQueueInput, opts ...request.Option) (*sqs.CreateQueueOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := aws.StringValue(in.QueueName)
+	if name == "" {
+		return nil, errInvalidParameter("QueueName is required")
+	}
+	if q, ok := s.queues[name]; ok {
+		return &sqs.CreateQueueOutput{QueueUrl: aws.String(q.url)}, nil
+	}
+	q := &queue{
+		name:    name,
+		url:     s.BaseURL + name,
+		arn:     s.ARNPrefix + name,
+		created: s.now(),
+		attrs:   aws.StringValueMap(in.Attributes),
+		tags:    aws.StringValueMap(in.Tags),
+		dedup:   map[string]time.Time{},
+	}
+	s.queues[name] = q
+	return &sqs.CreateQueueOutput{QueueUrl: aws.String(q.url)}, nil
+}
+
+// GetQueueUrl gets URL of a queue.
+func (s *SQS) GetQueueUrl(in *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	return s.GetQueueUrlWithContext(aws.BackgroundContext(), in)
+}
+
+// GetQueueUrlWithContext gets URL of a queue.
+func (s *SQS) GetQueueUrlWithContext(ctx aws.Context, in *sqs.GetQueueUrlInput, opts ...request.Option) (*sqs.GetQueueUrlOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[aws.StringValue(in.QueueName)]
+	if !ok {
+		return nil, errQueueDoesNotExist()
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(q.url)}, nil
+}
+
+// ListQueues lists URLs of queues.
+func (s *SQS) ListQueues(in *sqs.ListQueuesInput) (*sqs.ListQueuesOutput, error) {
+	return s.ListQueuesWithContext(aws.BackgroundContext(), in)
+}
+
+// ListQueuesWithContext lists URLs of queues.
+func (s *SQS) ListQueuesWithContext(ctx aws.Context, in *sqs.ListQueuesInput, opts ...request.Option) (*sqs.ListQueuesOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix := aws.StringValue(in.QueueNamePrefix)
+	var urls []string
+	for name, q := range s.queues {
+		if len(name) >= len(prefix) && name[:len(prefix)] == prefix {
+			urls = append(urls, q.url)
+		}
+	}
+	sort.Strings(urls)
+	return &sqs.ListQueuesOutput{QueueUrls: aws.StringSlice(urls)}, nil
+}
+
+// DeleteQueue deletes a queue.
+func (s *SQS) DeleteQueue(in *sqs.DeleteQueueInput) (*sqs.DeleteQueueOutput, error) {
+	return s.DeleteQueueWithContext(aws.BackgroundContext(), in)
+}
+
+// DeleteQueueWithContext deletes a queue.
+func (s *SQS) DeleteQueueWithContext(ctx aws.Context, in *sqs.DeleteQueueInput, opts ...request.Option) (*sqs.DeleteQueueOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.getQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	delete(s.queues, q.name)
+	return &sqs.DeleteQueueOutput{}, nil
+}
+
+// PurgeQueue deletes all messages in a queue.
+func (s *SQS) PurgeQueue(in *sqs.PurgeQueueInput) (*sqs.PurgeQueueOutput, error) {
+	return s.PurgeQueueWithContext(aws.BackgroundContext(), in)
+}
+
+// PurgeQueueWithContext deletes all messages in a queue.
+func (s *SQS) PurgeQueueWithContext(ctx aws.Context, in *sqs.PurgeQueueInput, opts ...request.Option) (*sqs.PurgeQueueOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.getQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	q.msgs = nil
+	return &sqs.PurgeQueueOutput{}, nil
+}
+
+// GetQueueAttributes gets attributes of a queue.
+func (s *SQS) GetQueueAttributes(in *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	return s.GetQueueAttributesWithContext(aws.BackgroundContext(), in)
+}
+
+// GetQueueAttributesWithContext gets attributes of a queue.
+func (s *SQS) GetQueueAttributesWithContext(ctx aws.Context, in *sqs.GetQueueAttributesInput, opts ...request.Option) (*sqs.GetQueueAttributesOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.getQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	st := q.stats(s.now())
+	all := map[string]string{
+		sqs.QueueAttributeNameVisibilityTimeout:                     "30",
+		sqs.QueueAttributeNameMessageRetentionPeriod:                "345600",
+		sqs.QueueAttributeNameReceiveMessageWaitTimeSeconds:         "0",
+		sqs.QueueAttributeNameDelaySeconds:                          "0",
+		sqs.QueueAttributeNameMaximumMessageSize:                    "262144",
+		sqs.QueueAttributeNameQueueArn:                              q.arn,
+		sqs.QueueAttributeNameCreatedTimestamp:                      strconv.FormatInt(q.created.Unix(), 10),
+		sqs.QueueAttributeNameApproximateNumberOfMessages:           strconv.Itoa(st.Visible),
+		sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible: strconv.Itoa(st.InFlight),
+		sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed:    strconv.Itoa(st.Delayed),
+	}
+	for k, v := range q.attrs {
+		all[k] = v
+	}
+	out := map[string]*string{}
+	for k, v := range all {
+		if matchName(in.AttributeNames, k) {
+			out[k] = aws.String(v)
+		}
+	}
+	return &sqs.GetQueueAttributesOutput{Attributes: out}, nil
+}
+
+// SetQueueAttributes sets attributes of a queue.
+func (s *SQS) SetQueueAttributes(in *sqs.SetQueueAttributesInput) (*sqs.SetQueueAttributesOutput, error) {
+	return s.SetQueueAttributesWithContext(aws.BackgroundContext(), in)
+}
+
+// SetQueueAttributesWithContext sets attributes of a queue.
+func (s *SQS) SetQueueAttributesWithContext(ctx aws.Context, in *sqs.SetQueueAttributesInput, opts ...request.Option) (*sqs.SetQueueAttributesOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.getQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range in.Attributes {
+		if k == sqs.QueueAttributeNameFifoQueue {
+			return nil, errInvalidParameter("FifoQueue can't be changed")
+		}
+		q.attrs[k] = aws.StringValue(v)
+	}
+	return &sqs.SetQueueAttributesOutput{}, nil
+}
+
+// TagQueue adds tags to a queue.
+func (s *SQS) TagQueue(in *sqs.TagQueueInput) (*sqs.TagQueueOutput, error) {
+	return s.TagQueueWithContext(aws.BackgroundContext(), in)
+}
+
+// TagQueueWithContext adds tags to a queue.
+func (s *SQS) TagQueueWithContext(ctx aws.Context, in *sqs.TagQueueInput, opts ...request.Option) (*sqs.TagQueueOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.getQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range in.Tags {
+		q.tags[k] = aws.StringValue(v)
+	}
+	return &sqs.TagQueueOutput{}, nil
+}
+
+// ListQueueTags lists tags of a queue.
+func (s *SQS) ListQueueTags(in *sqs.ListQueueTagsInput) (*sqs.ListQueueTagsOutput, error) {
+	return s.ListQueueTagsWithContext(aws.BackgroundContext(), in)
+}
+
+// ListQueueTagsWithContext lists tags of a queue.
+func (s *SQS) ListQueueTagsWithContext(ctx aws.Context, in *sqs.ListQueueTagsInput, opts ...request.Option) (*sqs.ListQueueTagsOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.getQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	return &sqs.ListQueueTagsOutput{Tags: aws.StringMap(q.tags)}, nil
+}
+
+// send adds a message to a queue.  It should be called with lock.
+func (s *SQS) send(q *queue, in *sqs.SendMessageInput) (*message, error) {
+	if aws.StringValue(in.MessageBody) == "" {
+		return nil, errInvalidParameter("MessageBody is required")
+	}
+	now := s.now()
+	m := &message{
+		body:    *in.MessageBody,
+		attrs:   in.MessageAttributes,
+		sysAttr: in.MessageSystemAttributes,
+		sent:    now,
+		visible: now,
+	}
+	if q.fifo() {
+		if aws.StringValue(in.MessageGroupId) == "" {
+			return nil, errInvalidParameter("MessageGroupId is required for FIFO queue")
+		}
+		if in.DelaySeconds != nil {
+			return nil, errInvalidParameter("DelaySeconds is not supported for FIFO queue")
+		}
+		key := q.dedupKey(m.body, in.MessageDeduplicationId)
+		if key == "" {
+			return nil, errInvalidParameter("MessageDeduplicationId is required for FIFO queue without ContentBasedDeduplication")
+		}
+		if q.isDuplicated(key, now) {
+			// accepted but not enqueued.
+			m.id = s.nextID()
+			return m, nil
+		}
+		m.groupID = *in.MessageGroupId
+		m.dedupID = key
+		q.seq++
+		m.seq = q.seq
+	}
+	delay := q.intAttr(sqs.QueueAttributeNameDelaySeconds, 0)
+	if in.DelaySeconds != nil {
+		delay = *in.DelaySeconds
+	}
+	m.visible = now.Add(time.Duration(delay) * time.Second)
+	m.id = s.nextID()
+	q.msgs = append(q.msgs, m)
+	return m, nil
+}
+
+func (m *message) sendOutput() (*string, *string, *string) {
+	var seq *string
+	if m.seq != 0 {
+		seq = aws.String(strconv.FormatInt(m.seq, 10))
+	}
+	return aws.String(m.id), aws.String(m.md5OfBody()), seq
+}
+
+// SendMessage sends a message.
+func (s *SQS) SendMessage(in *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	return s.SendMessageWithContext(aws.BackgroundContext(), in)
+}
+
+// SendMessageWithContext sends a message.
+func (s *SQS) SendMessageWithContext(ctx aws.Context, in *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	s.mu.Lock()
+	q, err := s.getQueue(in.QueueUrl)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	m, err := s.send(q, in)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	s.wakeUp()
+	id, md5, seq := m.sendOutput()
+	return &sqs.SendMessageOutput{MessageId: id, MD5OfMessageBody: md5, SequenceNumber: seq}, nil
+}
+
+// SendMessageBatch sends messages.
+func (s *SQS) SendMessageBatch(in *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	return s.SendMessageBatchWithContext(aws.BackgroundContext(), in)
+}
+
+// SendMessageBatchWithContext sends messages.
+func (s *SQS) SendMessageBatchWithContext(ctx aws.Context, in *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	s.mu.Lock()
+	q, err := s.getQueue(in.QueueUrl)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	out := &sqs.SendMessageBatchOutput{}
+	for _, e := range in.Entries {
+		m, err := s.send(q, &sqs.SendMessageInput{
+			MessageBody:             e.MessageBody,
+			MessageAttributes:       e.MessageAttributes,
+			MessageSystemAttributes: e.MessageSystemAttributes,
+			DelaySeconds:            e.DelaySeconds,
+			MessageGroupId:          e.MessageGroupId,
+			MessageDeduplicationId:  e.MessageDeduplicationId,
+		})
+		if err != nil {
+			out.Failed = append(out.Failed, batchError(e.Id, err))
+			continue
+		}
+		id, md5, seq := m.sendOutput()
+		out.Successful = append(out.Successful, &sqs.SendMessageBatchResultEntry{
+			Id:               e.Id,
+			MessageId:        id,
+			MD5OfMessageBody: md5,
+			SequenceNumber:   seq,
+		})
+	}
+	s.mu.Unlock()
+	s.wakeUp()
+	return out, nil
+}
+
+func batchError(id *string, err error) *sqs.BatchResultErrorEntry {
+	e := &sqs.BatchResultErrorEntry{
+		Id:          id,
+		Code:        aws.String("InternalError"),
+		Message:     aws.String(err.Error()),
+		SenderFault: aws.Bool(false),
+	}
+	if ae, ok := err.(awserr.Error); ok {
+		e.Code = aws.String(ae.Code())
+		e.Message = aws.String(ae.Message())
+		e.SenderFault = aws.Bool(true)
+	}
+	return e
+}
+
+// receive receives messages.  It should be called with lock.
+func (s *SQS) receive(q *queue, in *sqs.ReceiveMessageInput) []*sqs.Message {
+	now := s.now()
+	max := int(aws.Int64Value(in.MaxNumberOfMessages))
+	if max <= 0 {
+		max = 1
+	}
+	vt := q.visibilityTimeout()
+	if in.VisibilityTimeout != nil {
+		vt = time.Duration(*in.VisibilityTimeout) * time.Second
+	}
+	attrNames := append(append([]*string{}, in.AttributeNames...), in.MessageSystemAttributeNames...)
+	dlqARN, maxRecv := q.redrive()
+	blocked := q.blockedGroups(now)
+
+	var out []*sqs.Message
+	for i := 0; i < len(q.msgs) && len(out) < max; {
+		m := q.msgs[i]
+		if now.Before(m.visible) {
+			// keep order of messages in a FIFO group.
+			if q.fifo() {
+				blocked[m.groupID] = true
+			}
+			i++
+			continue
+		}
+		if blocked[m.groupID] {
+			i++
+			continue
+		}
+		if maxRecv > 0 && m.receiveCount >= maxRecv {
+			if dlq := s.queueByARN(dlqARN); dlq != nil {
+				// move the message to dead-letter queue.
+				q.remove(i)
+				m.inFlight = false
+				m.receipt = ""
+				dlq.msgs = append(dlq.msgs, m)
+				continue
+			}
+		}
+		m.receiveCount++
+		if m.firstRecv.IsZero() {
+			m.firstRecv = now
+		}
+		m.inFlight = true
+		m.visible = now.Add(vt)
+		m.receipt = s.nextReceipt(m.id)
+		out = append(out, m.toSQS(attrNames, in.MessageAttributeNames))
+		i++
+	}
+	return out
+}
+
+// ReceiveMessage receives messages.
+func (s *SQS) ReceiveMessage(in *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	return s.ReceiveMessageWithContext(aws.BackgroundContext(), in)
+}
+
+// ReceiveMessageWithContext receives messages.  It waits for messages in
+// WaitTimeSeconds (long polling) by real time.
+func (s *SQS) ReceiveMessageWithContext(ctx aws.Context, in *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	// a request with canceled context fails, even if messages are
+	// available, like SDK.
+	if ctx.Err() != nil {
+		return nil, awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+	}
+	var deadline time.Time
+	for {
+		s.mu.Lock()
+		q, err := s.getQueue(in.QueueUrl)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		msgs := s.receive(q, in)
+		if deadline.IsZero() {
+			wait := q.intAttr(sqs.QueueAttributeNameReceiveMessageWaitTimeSeconds, 0)
+			if in.WaitTimeSeconds != nil {
+				wait = *in.WaitTimeSeconds
+			}
+			deadline = time.Now().Add(time.Duration(wait) * time.Second)
+		}
+		notify := s.notify
+		s.mu.Unlock()
+		if len(msgs) > 0 || !time.Now().Before(deadline) {
+			return &sqs.ReceiveMessageOutput{Messages: msgs}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+		case <-notify:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// changeVisibility changes visibility timeout of a message.  It should be
+// called with lock.
+func (s *SQS) changeVisibility(q *queue, receipt string, timeout int64) error {
+	_, m := q.find(receipt)
+	if m == nil {
+		return errReceiptHandle(receipt)
+	}
+	m.visible = s.now().Add(time.Duration(timeout) * time.Second)
+	return nil
+}
+
+// ChangeMessageVisibility changes visibility timeout of a message.
+func (s *SQS) ChangeMessageVisibility(in *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	return s.ChangeMessageVisibilityWithContext(aws.BackgroundContext(), in)
+}
+
+// ChangeMessageVisibilityWithContext changes visibility timeout of a message.
+func (s *SQS) ChangeMessageVisibilityWithContext(ctx aws.Context, in *sqs.ChangeMessageVisibilityInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error) {
+	s.mu.Lock()
+	q, err := s.getQueue(in.QueueUrl)
+	if err == nil {
+		err = s.changeVisibility(q, aws.StringValue(in.ReceiptHandle), aws.Int64Value(in.VisibilityTimeout))
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	s.wakeUp()
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// ChangeMessageVisibilityBatch changes visibility timeout of messages.
+func (s *SQS) ChangeMessageVisibilityBatch(in *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	return s.ChangeMessageVisibilityBatchWithContext(aws.BackgroundContext(), in)
+}
+
+// ChangeMessageVisibilityBatchWithContext changes visibility timeout of
+// messages.
+func (s *SQS) ChangeMessageVisibilityBatchWithContext(ctx aws.Context, in *sqs.ChangeMessageVisibilityBatchInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	s.mu.Lock()
+	q, err := s.getQueue(in.QueueUrl)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	out := &sqs.ChangeMessageVisibilityBatchOutput{}
+	for _, e := range in.Entries {
+		err := s.changeVisibility(q, aws.StringValue(e.ReceiptHandle), aws.Int64Value(e.VisibilityTimeout))
+		if err != nil {
+			out.Failed = append(out.Failed, batchError(e.Id, err))
+			continue
+		}
+		out.Successful = append(out.Successful, &sqs.ChangeMessageVisibilityBatchResultEntry{Id: e.Id})
+	}
+	s.mu.Unlock()
+	s.wakeUp()
+	return out, nil
+}
+
+// deleteMessage deletes a message.  It should be called with lock.
+func (s *SQS) deleteMessage(q *queue, receipt string) error {
+	i, m := q.find(receipt)
+	if m == nil {
+		return errReceiptHandle(receipt)
+	}
+	if s.FailDelete != nil && s.FailDelete(q.name, m.id) {
+		return fmt.Errorf("injected failure to delete: %s", m.id)
+	}
+	q.remove(i)
+	return nil
+}
+
+// DeleteMessage deletes a message.
+func (s *SQS) DeleteMessage(in *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	return s.DeleteMessageWithContext(aws.BackgroundContext(), in)
+}
+
+// DeleteMessageWithContext deletes a message.
+func (s *SQS) DeleteMessageWithContext(ctx aws.Context, in *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.getQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	err = s.deleteMessage(q, aws.StringValue(in.ReceiptHandle))
+	if err != nil {
+		return nil, err
+	}
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+// DeleteMessageBatch deletes messages.
+func (s *SQS) DeleteMessageBatch(in *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	return s.DeleteMessageBatchWithContext(aws.BackgroundContext(), in)
+}
+
+// DeleteMessageBatchWithContext deletes messages.
+func (s *SQS) DeleteMessageBatchWithContext(ctx aws.Context, in *sqs.DeleteMessageBatchInput, opts ...request.Option) (*sqs.DeleteMessageBatchOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.getQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	out := &sqs.DeleteMessageBatchOutput{}
+	for _, e := range in.Entries {
+		err := s.deleteMessage(q, aws.StringValue(e.ReceiptHandle))
+		if err != nil {
+			out.Failed = append(out.Failed, batchError(e.Id, err))
+			continue
+		}
+		out.Successful = append(out.Successful, &sqs.DeleteMessageBatchResultEntry{Id: e.Id})
+	}
+	return out, nil
+}
@@ -0,0 +1,139 @@
+package sqstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func newQueue(t *testing.T, s *SQS, name string, attrs map[string]string) *string {
+	t.Helper()
+	out, err := s.CreateQueue(&sqs.CreateQueueInput{
+		QueueName:  aws.String(name),
+		Attributes: aws.StringMap(attrs),
+	})
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	return out.QueueUrl
+}
+
+func send(t *testing.T, s *SQS, qu *string, body, group string) {
+	t.Helper()
+	in := &sqs.SendMessageInput{QueueUrl: qu, MessageBody: aws.String(body)}
+	if group != "" {
+		in.MessageGroupId = aws.String(group)
+		in.MessageDeduplicationId = aws.String(body)
+	}
+	if _, err := s.SendMessage(in); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+}
+
+func receive(t *testing.T, s *SQS, qu *string, max int64) []*sqs.Message {
+	t.Helper()
+	out, err := s.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:            qu,
+		MaxNumberOfMessages: aws.Int64(max),
+		AttributeNames:      aws.StringSlice([]string{"All"}),
+	})
+	if err != nil {
+		t.Fatalf("failed to receive: %v", err)
+	}
+	return out.Messages
+}
+
+func TestVisibilityTimeout(t *testing.T) {
+	s := New()
+	s.Now = func() time.Time { return time.Unix(1000, 0) }
+	qu := newQueue(t, s, "q1", map[string]string{"VisibilityTimeout": "10"})
+	send(t, s, qu, "hello", "")
+
+	msgs := receive(t, s, qu, 10)
+	if len(msgs) != 1 || *msgs[0].Body != "hello" {
+		t.Fatalf("unexpected messages: %v", msgs)
+	}
+	if n := *msgs[0].Attributes["ApproximateReceiveCount"]; n != "1" {
+		t.Fatalf("unexpected receive count: %s", n)
+	}
+	if msgs := receive(t, s, qu, 10); len(msgs) != 0 {
+		t.Fatalf("message in flight is received: %v", msgs)
+	}
+	if st := s.Stats("q1"); st != (Stats{InFlight: 1}) {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+
+	s.Advance(10 * time.Second)
+	msgs2 := receive(t, s, qu, 10)
+	if len(msgs2) != 1 || *msgs2[0].Attributes["ApproximateReceiveCount"] != "2" {
+		t.Fatalf("unexpected messages after visibility timeout: %v", msgs2)
+	}
+
+	// old receipt handle is invalid.
+	out, err := s.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+		QueueUrl: qu,
+		Entries: []*sqs.DeleteMessageBatchRequestEntry{
+			{Id: aws.String("0"), ReceiptHandle: msgs[0].ReceiptHandle},
+			{Id: aws.String("1"), ReceiptHandle: msgs2[0].ReceiptHandle},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if len(out.Failed) != 1 || *out.Failed[0].Id != "0" || len(out.Successful) != 1 {
+		t.Fatalf("unexpected delete result: %v", out)
+	}
+	if st := s.Stats("q1"); st != (Stats{}) {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestRedrive(t *testing.T) {
+	s := New()
+	s.Now = func() time.Time { return time.Unix(1000, 0) }
+	newQueue(t, s, "dlq", nil)
+	qu := newQueue(t, s, "q1", map[string]string{
+		"VisibilityTimeout": "1",
+		"RedrivePolicy":     `{"deadLetterTargetArn":"` + DefaultARNPrefix + `dlq","maxReceiveCount":"2"}`,
+	})
+	send(t, s, qu, "hello", "")
+	for i := 0; i < 2; i++ {
+		if msgs := receive(t, s, qu, 1); len(msgs) != 1 {
+			t.Fatalf("unexpected messages #%d: %v", i, msgs)
+		}
+		s.Advance(time.Second)
+	}
+	if msgs := receive(t, s, qu, 1); len(msgs) != 0 {
+		t.Fatalf("message should be moved to DLQ: %v", msgs)
+	}
+	if st := s.Stats("dlq"); st.Visible != 1 {
+		t.Fatalf("unexpected stats of DLQ: %+v", st)
+	}
+}
+
+func TestFIFO(t *testing.T) {
+	s := New()
+	s.Now = func() time.Time { return time.Unix(1000, 0) }
+	qu := newQueue(t, s, "q1.fifo", map[string]string{"FifoQueue": "true"})
+	send(t, s, qu, "a1", "a")
+	send(t, s, qu, "a2", "a")
+	send(t, s, qu, "b1", "b")
+	send(t, s, qu, "a1", "a") // duplicated
+
+	msgs := receive(t, s, qu, 1)
+	if len(msgs) != 1 || *msgs[0].Body != "a1" {
+		t.Fatalf("unexpected messages: %v", msgs)
+	}
+	// group "a" is blocked while "a1" is in flight.
+	msgs = receive(t, s, qu, 10)
+	if len(msgs) != 1 || *msgs[0].Body != "b1" {
+		t.Fatalf("unexpected messages: %v", msgs)
+	}
+	s.Advance(30 * time.Second)
+	msgs = receive(t, s, qu, 10)
+	if len(msgs) != 3 || *msgs[0].Body != "a1" || *msgs[1].Body != "a2" || *msgs[2].Body != "b1" {
+		t.Fatalf("unexpected messages: %v", msgs)
+	}
+}
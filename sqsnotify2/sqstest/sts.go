@@ -0,0 +1,98 @@
+package sqstest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// stsDuration is a duration of credentials issued by the stand-in of STS.
+const stsDuration = time.Hour
+
+const stsResponseFormat = `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>%[2]s</AccessKeyId>
+      <SecretAccessKey>sqstest-secret</SecretAccessKey>
+      <SessionToken>sqstest-token</SessionToken>
+      <Expiration>%[3]s</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <AssumedRoleId>%[4]s</AssumedRoleId>
+      <Arn>%[5]s</Arn>
+    </AssumedRoleUser>
+  </%[1]sResult>
+  <ResponseMetadata>
+    <RequestId>%[6]s</RequestId>
+  </ResponseMetadata>
+</%[1]sResponse>
+`
+
+// stsNamespace is a namespace of XML for STS.
+const stsNamespace = "https://sts.amazonaws.com/doc/2011-06-15/"
+
+// errorResponseFormat is a format of errors in Query protocol, which is
+// shared by SQS and STS.
+const errorResponseFormat = `<ErrorResponse xmlns="%s">
+  <Error>
+    <Type>Sender</Type>
+    <Code>%s</Code>
+    <Message>%s</Message>
+  </Error>
+  <RequestId>%s</RequestId>
+</ErrorResponse>
+`
+
+func escapeXML(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// serveSTS serves a stand-in of STS, which issues dummy credentials for
+// AssumeRole and AssumeRoleWithWebIdentity by Query protocol.  Credentials,
+// tokens and external IDs are not verified.
+func (sv *Server) serveSTS(w http.ResponseWriter, r *http.Request, reqID string) {
+	if err := r.ParseForm(); err != nil {
+		writeSTSError(w, "InvalidParameterValue", err.Error(), reqID)
+		return
+	}
+	action := r.PostForm.Get("Action")
+	switch action {
+	case "AssumeRole", "AssumeRoleWithWebIdentity":
+	default:
+		writeSTSError(w, "InvalidAction",
+			fmt.Sprintf("The action %s is not valid for this endpoint.", action), reqID)
+		return
+	}
+	role, name := r.PostForm.Get("RoleArn"), r.PostForm.Get("RoleSessionName")
+	if role == "" || name == "" {
+		writeSTSError(w, "ValidationError", "RoleArn and RoleSessionName are required", reqID)
+		return
+	}
+	if len(name) > 64 {
+		writeSTSError(w, "ValidationError", "RoleSessionName must be 64 characters or less", reqID)
+		return
+	}
+	n := atomic.AddInt64(&sv.keys, 1)
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, stsResponseFormat, action,
+		fmt.Sprintf("ASIASQSTEST%09d", n),
+		time.Now().Add(stsDuration).UTC().Format(time.RFC3339),
+		escapeXML(fmt.Sprintf("AROASQSTEST%09d:%s", n, name)),
+		escapeXML(role+"/"+name),
+		reqID)
+}
+
+func writeSTSError(w http.ResponseWriter, code, msg, reqID string) {
+	writeErrorResponse(w, stsNamespace, http.StatusBadRequest, code, msg, reqID)
+}
+
+func writeErrorResponse(w http.ResponseWriter, ns string, status int, code, msg, reqID string) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, errorResponseFormat, ns, code, escapeXML(msg), reqID)
+}
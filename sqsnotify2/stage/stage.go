@@ -1,5 +1,7 @@
 package stage
 
+import "fmt"
+
 // Stage represents execution stages.
 type Stage int
 
@@ -16,9 +18,52 @@ const (
 	Done
 )
 
+// Parse parses a name of Stage.
+func Parse(s string) (Stage, error) {
+	switch s {
+	case "None":
+		return None, nil
+	case "Recv":
+		return Recv, nil
+	case "Lock":
+		return Lock, nil
+	case "Exec":
+		return Exec, nil
+	case "Done":
+		return Done, nil
+	default:
+		return None, fmt.Errorf("unknown stage: %q", s)
+	}
+}
+
 // MarshalBinary marshal Stage into []byte.  used by go-redis/redis.
 func (stg Stage) MarshalBinary() ([]byte, error) {
-	return []byte{byte(stg)}, nil
+	return stg.MarshalText()
+}
+
+// UnmarshalBinary unmarshal []byte into Stage.  It accepts the legacy format
+// too, which is a single byte.
+func (stg *Stage) UnmarshalBinary(b []byte) error {
+	if len(b) == 1 {
+		*stg = Stage(b[0])
+		return nil
+	}
+	return stg.UnmarshalText(b)
+}
+
+// MarshalText marshal Stage into its name.
+func (stg Stage) MarshalText() ([]byte, error) {
+	return []byte(stg.String()), nil
+}
+
+// UnmarshalText unmarshal a name into Stage.
+func (stg *Stage) UnmarshalText(b []byte) error {
+	v, err := Parse(string(b))
+	if err != nil {
+		return err
+	}
+	*stg = v
+	return nil
 }
 
 func (stg Stage) String() string {
@@ -0,0 +1,258 @@
+package sqsnotify2
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	// TraceparentAttribute is a name of a message attribute, which has W3C
+	// trace context.
+	TraceparentAttribute = "traceparent"
+
+	// TraceparentEnvName is an environment variable to pass W3C trace
+	// context to commands.
+	TraceparentEnvName = "TRACEPARENT"
+	// AmznTraceIDEnvName is an environment variable to pass AWSTraceHeader
+	// (X-Ray trace header) to commands.
+	AmznTraceIDEnvName = "_X_AMZN_TRACE_ID"
+)
+
+// traceContext is W3C trace context.
+type traceContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+// traceparent returns a value of traceparent with spanID as parent.
+func (tc *traceContext) traceparent(spanID [8]byte) string {
+	flags := "00"
+	if tc.sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%x-%x-%s", tc.traceID, spanID, flags)
+}
+
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTraceparent parses a value of traceparent, like
+// "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01".
+func parseTraceparent(s string) (*traceContext, bool) {
+	f := strings.Split(strings.TrimSpace(s), "-")
+	if len(f) < 4 || f[0] == "ff" || (f[0] == "00" && len(f) != 4) {
+		return nil, false
+	}
+	var ver, flags [1]byte
+	tc := &traceContext{}
+	if !decodeHex(ver[:], f[0]) || !decodeHex(tc.traceID[:], f[1]) ||
+		!decodeHex(tc.spanID[:], f[2]) || !decodeHex(flags[:], f[3]) {
+		return nil, false
+	}
+	if isZero(tc.traceID[:]) || isZero(tc.spanID[:]) {
+		return nil, false
+	}
+	tc.sampled = flags[0]&1 != 0
+	return tc, true
+}
+
+// parseAWSTraceHeader parses a value of AWSTraceHeader, like
+// "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1".
+// Sampled is assumed when it is omitted.
+func parseAWSTraceHeader(s string) (*traceContext, bool) {
+	tc := &traceContext{sampled: true}
+	var root, parent bool
+	for _, kv := range strings.Split(s, ";") {
+		k, v, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		switch k {
+		case "Root":
+			f := strings.Split(v, "-")
+			if len(f) != 3 || f[0] != "1" || !decodeHex(tc.traceID[:], f[1]+f[2]) {
+				return nil, false
+			}
+			root = true
+		case "Parent":
+			if !decodeHex(tc.spanID[:], v) {
+				return nil, false
+			}
+			parent = true
+		case "Sampled":
+			tc.sampled = v != "0"
+		}
+	}
+	if !root || !parent || isZero(tc.traceID[:]) || isZero(tc.spanID[:]) {
+		return nil, false
+	}
+	return tc, true
+}
+
+// messageTrace returns trace context of a message, from traceparent message
+// attribute or AWSTraceHeader system attribute.
+func messageTrace(m *sqs.Message) (*traceContext, bool) {
+	if v, ok := m.MessageAttributes[TraceparentAttribute]; ok {
+		if tc, ok := parseTraceparent(aws.StringValue(v.StringValue)); ok {
+			return tc, true
+		}
+	}
+	if v, ok := m.Attributes[sqs.MessageSystemAttributeNameAwstraceHeader]; ok {
+		return parseAWSTraceHeader(aws.StringValue(v))
+	}
+	return nil, false
+}
+
+func newTraceID() (id [16]byte) {
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() (id [8]byte) {
+	rand.Read(id[:])
+	return id
+}
+
+type traceparentKey struct{}
+
+// withTraceparent returns a context which passes traceparent to a command,
+// instead of one in the message.
+func withTraceparent(ctx context.Context, tp string) context.Context {
+	return context.WithValue(ctx, traceparentKey{}, tp)
+}
+
+// traceHeaders returns values of traceparent and AWSTraceHeader to pass to a
+// command for a message.
+func traceHeaders(ctx context.Context, m *sqs.Message) (traceparent, amzn string) {
+	if m == nil {
+		return "", ""
+	}
+	if tp, ok := ctx.Value(traceparentKey{}).(string); ok {
+		traceparent = tp
+	} else if tc, ok := messageTrace(m); ok {
+		traceparent = tc.traceparent(tc.spanID)
+	}
+	amzn = aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameAwstraceHeader])
+	return traceparent, amzn
+}
+
+// traceEnv appends environment variables of trace context to env.
+func traceEnv(ctx context.Context, env []string, m *sqs.Message) []string {
+	tp, amzn := traceHeaders(ctx, m)
+	if tp == "" && amzn == "" {
+		return env
+	}
+	if env == nil {
+		env = os.Environ()
+	}
+	if tp != "" {
+		env = append(env, TraceparentEnvName+"="+tp)
+	}
+	if amzn != "" {
+		env = append(env, AmznTraceIDEnvName+"="+amzn)
+	}
+	return env
+}
+
+// startTrace sets trace context of a message.  A new trace is started when
+// the message has none and spans are recorded.
+func (sn *SQSNotify) startTrace(r *result) {
+	if tc, ok := messageTrace(r.msg); ok {
+		r.tc = tc
+		return
+	}
+	if sn.Tracer != nil {
+		r.tc = &traceContext{traceID: newTraceID(), sampled: true}
+	}
+}
+
+func (sn *SQSNotify) spanAttrs(r *result, op string) []otlpAttr {
+	return []otlpAttr{
+		strAttr("messaging.system", "aws_sqs"),
+		strAttr("messaging.operation.type", op),
+		strAttr("messaging.destination.name", sn.QueueName),
+		strAttr("messaging.message.id", aws.StringValue(r.msg.MessageId)),
+	}
+}
+
+// traceReceive records a receive span of a message.
+func (sn *SQSNotify) traceReceive(r *result, start time.Time) {
+	sn.Tracer.record(&span{
+		name:  SpanReceive,
+		kind:  spanKindConsumer,
+		tc:    r.tc,
+		id:    newSpanID(),
+		start: start,
+		end:   r.recv,
+		attrs: sn.spanAttrs(r, "receive"),
+	})
+}
+
+// traceExec runs fn and records an exec span of a message.  The command
+// receives the exec span as parent by TRACEPARENT.
+func (sn *SQSNotify) traceExec(ctx context.Context, r *result, fn func(context.Context) error) error {
+	if sn.Tracer == nil || r.tc == nil || !r.tc.sampled {
+		return fn(ctx)
+	}
+	id := newSpanID()
+	start := time.Now()
+	err := fn(withTraceparent(ctx, r.tc.traceparent(id)))
+	attrs := append(sn.spanAttrs(r, "process"), strAttr("process.command", sn.CmdName))
+	if code := exitCode(err); !sn.Coprocess && (err == nil || code >= 0) {
+		if err == nil {
+			code = 0
+		}
+		attrs = append(attrs, intAttr("process.exit.code", code))
+	}
+	sn.Tracer.record(&span{
+		name:  SpanExec,
+		kind:  spanKindInternal,
+		tc:    r.tc,
+		id:    id,
+		start: start,
+		end:   time.Now(),
+		err:   err,
+		attrs: attrs,
+	})
+	return err
+}
+
+// traceDelete records delete spans of messages.
+func (sn *SQSNotify) traceDelete(rs []*result, start time.Time, err error) {
+	if sn.Tracer == nil {
+		return
+	}
+	end := time.Now()
+	for _, r := range rs {
+		sn.Tracer.record(&span{
+			name:  SpanDelete,
+			kind:  spanKindClient,
+			tc:    r.tc,
+			id:    newSpanID(),
+			start: start,
+			end:   end,
+			err:   err,
+			attrs: sn.spanAttrs(r, "settle"),
+		})
+	}
+}
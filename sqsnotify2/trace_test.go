@@ -0,0 +1,187 @@
+package sqsnotify2
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/koron/sqs-notify/sqsnotify2/sqstest"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	for _, d := range []struct {
+		s       string
+		ok      bool
+		sampled bool
+	}{
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", true, true},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00", true, false},
+		{"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra", true, true},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra", false, false},
+		{"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", false, false},
+		{"00-00000000000000000000000000000000-b7ad6b7169203331-01", false, false},
+		{"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01", false, false},
+		{"00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01", false, false},
+		{"00-0af7651916cd43dd8448eb211c8031-b7ad6b7169203331-01", false, false},
+		{"", false, false},
+	} {
+		tc, ok := parseTraceparent(d.s)
+		if ok != d.ok {
+			t.Errorf("unexpected result for %q: %t", d.s, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if tc.sampled != d.sampled {
+			t.Errorf("unexpected sampled for %q: %t", d.s, tc.sampled)
+		}
+		if got := tc.traceparent(tc.spanID); got[3:52] != d.s[3:52] {
+			t.Errorf("unexpected traceparent for %q: %s", d.s, got)
+		}
+	}
+}
+
+func TestParseAWSTraceHeader(t *testing.T) {
+	for _, d := range []struct {
+		s    string
+		want string
+	}{
+		{"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1",
+			"00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-01"},
+		{"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0",
+			"00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-00"},
+		{"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8",
+			"00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-01"},
+		{"Root=1-5759e988-bd862e3fe1be46a994272793", ""},
+		{"Root=2-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8", ""},
+		{"Parent=53995c3f42cd8ad8;Sampled=1", ""},
+		{"", ""},
+	} {
+		tc, ok := parseAWSTraceHeader(d.s)
+		got := ""
+		if ok {
+			got = tc.traceparent(tc.spanID)
+		}
+		if got != d.want {
+			t.Errorf("unexpected result for %q:\n got=%q\nwant=%q", d.s, got, d.want)
+		}
+	}
+}
+
+func readSpans(t *testing.T, name string) map[string]*otlpSpan {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatalf("failed to open spans: %v", err)
+	}
+	defer f.Close()
+	spans := map[string]*otlpSpan{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var req otlpRequest
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			t.Fatalf("failed to parse spans: %v", err)
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					spans[s.Name] = s
+				}
+			}
+		}
+	}
+	return spans
+}
+
+func TestRunTrace(t *testing.T) {
+	const (
+		header  = "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"
+		traceID = "5759e988bd862e3fe1be46a994272793"
+	)
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	t.Setenv("SQSNOTIFY2_HELPER_OUTPUT", out)
+	tr, err := NewTracer(filepath.Join(dir, "spans.jsonl"), t.Logf)
+	if err != nil {
+		t.Fatalf("failed to create tracer: %v", err)
+	}
+	api := sqstest.New()
+	sendBodies(t, api)
+	_, err = api.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String("test"),
+		MessageBody: aws.String("trace"),
+		MessageSystemAttributes: map[string]*sqs.MessageSystemAttributeValue{
+			sqs.MessageSystemAttributeNameForSendsAwstraceHeader: {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(header),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	sn := newTestSQSNotify(t, Succeed)
+	sn.Tracer = tr
+	runUntil(t, sn, api, func() bool {
+		return api.Stats("test") == sqstest.Stats{}
+	})
+	tr.Close()
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	tp, amzn, _ := strings.Cut(string(b), "|")
+	if amzn != header {
+		t.Errorf("unexpected %s: %q", AmznTraceIDEnvName, amzn)
+	}
+	spans := readSpans(t, filepath.Join(dir, "spans.jsonl"))
+	for _, name := range []string{SpanReceive, SpanExec, SpanDelete} {
+		s, ok := spans[name]
+		if !ok {
+			t.Fatalf("no %s span: %v", name, spans)
+		}
+		if s.TraceID != traceID || s.ParentSpanID != "53995c3f42cd8ad8" || s.Status.Code != statusOK {
+			t.Errorf("unexpected %s span: %+v", name, s)
+		}
+	}
+	// the command receives the exec span as parent.
+	if want := "00-" + traceID + "-" + spans[SpanExec].SpanID + "-01"; tp != want {
+		t.Errorf("unexpected %s:\n got=%q\nwant=%q", TraceparentEnvName, tp, want)
+	}
+}
+
+func TestTraceEnv(t *testing.T) {
+	m := &sqs.Message{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			TraceparentAttribute: {
+				DataType:    aws.String("String"),
+				StringValue: aws.String("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"),
+			},
+		},
+	}
+	env := traceEnv(context.Background(), []string{}, m)
+	want := []string{TraceparentEnvName + "=00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	if strings.Join(env, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected env: %q", env)
+	}
+	if env := traceEnv(context.Background(), nil, &sqs.Message{}); env != nil {
+		t.Errorf("env should be inherited: %q", env)
+	}
+	var id [8]byte
+	hex.Decode(id[:], []byte("53995c3f42cd8ad8"))
+	tc, _ := parseTraceparent(want[0][len(TraceparentEnvName)+1:])
+	ctx := withTraceparent(context.Background(), tc.traceparent(id))
+	env = traceEnv(ctx, []string{}, m)
+	if len(env) != 1 || env[0] != TraceparentEnvName+"=00-0af7651916cd43dd8448eb211c80319c-53995c3f42cd8ad8-01" {
+		t.Errorf("unexpected env with span: %q", env)
+	}
+}
@@ -0,0 +1,275 @@
+package sqsnotify2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// names of spans.
+const (
+	SpanReceive = "receive"
+	SpanExec    = "exec"
+	SpanDelete  = "delete"
+)
+
+// kinds and status codes of spans in OTLP.
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+	spanKindConsumer = 5
+
+	statusOK    = 1
+	statusError = 2
+)
+
+const (
+	traceFlushInterval = time.Second
+	traceMaxBatch      = 512
+)
+
+// Tracer exports spans in OTLP JSON, to a file as JSON Lines or to a
+// collector by OTLP/HTTP.  Spans are exported in background periodically.
+type Tracer struct {
+	export func([]byte) error
+	closer io.Closer
+	logf   func(string, ...interface{})
+
+	mu    sync.Mutex
+	spans []*otlpSpan
+	kick  chan struct{}
+	done  chan struct{}
+	ended chan struct{}
+}
+
+// NewTracer creates a Tracer.  dest is a path of a file, or a URL of
+// a collector like "http://localhost:4318".  "/v1/traces" is used when the
+// URL has no path.  Errors of exporting are logged by logf.
+func NewTracer(dest string, logf func(string, ...interface{})) (*Tracer, error) {
+	t := &Tracer{
+		logf:  logf,
+		kick:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+		ended: make(chan struct{}),
+	}
+	if strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://") {
+		u, err := url.Parse(dest)
+		if err != nil {
+			return nil, err
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/v1/traces"
+		}
+		t.export = postTraces(u.String())
+	} else {
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		t.export = func(b []byte) error {
+			_, err := f.Write(append(b, '\n'))
+			return err
+		}
+		t.closer = f
+	}
+	go t.loop(traceFlushInterval)
+	return t, nil
+}
+
+func postTraces(u string) func([]byte) error {
+	c := &http.Client{Timeout: 10 * time.Second}
+	return func(b []byte) error {
+		resp, err := c.Post(u, "application/json", bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("collector responded %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+func (t *Tracer) loop(d time.Duration) {
+	defer close(t.ended)
+	tk := time.NewTicker(d)
+	defer tk.Stop()
+	for {
+		select {
+		case <-t.done:
+			t.flush()
+			return
+		case <-tk.C:
+		case <-t.kick:
+		}
+		t.flush()
+	}
+}
+
+func (t *Tracer) add(s *otlpSpan) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	n := len(t.spans)
+	t.mu.Unlock()
+	if n >= traceMaxBatch {
+		select {
+		case t.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// flush exports buffered spans.
+func (t *Tracer) flush() {
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	b, err := json.Marshal(newOTLPRequest(spans))
+	if err == nil {
+		err = t.export(b)
+	}
+	if err != nil && t.logf != nil {
+		t.logf("failed to export %d spans: %s", len(spans), err)
+	}
+}
+
+// Close exports rest of spans, and closes the file.
+func (t *Tracer) Close() error {
+	close(t.done)
+	<-t.ended
+	if t.closer != nil {
+		return t.closer.Close()
+	}
+	return nil
+}
+
+// span is a span of a message.
+type span struct {
+	name  string
+	kind  int
+	tc    *traceContext
+	id    [8]byte
+	start time.Time
+	end   time.Time
+	err   error
+	attrs []otlpAttr
+}
+
+// record records a span of a message.  It is skipped when the trace isn't
+// sampled.
+func (t *Tracer) record(s *span) {
+	if t == nil || s.tc == nil || !s.tc.sampled {
+		return
+	}
+	o := &otlpSpan{
+		TraceID:    hex.EncodeToString(s.tc.traceID[:]),
+		SpanID:     hex.EncodeToString(s.id[:]),
+		Name:       s.name,
+		Kind:       s.kind,
+		Start:      strconv.FormatInt(s.start.UnixNano(), 10),
+		End:        strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes: s.attrs,
+		Status:     otlpStatus{Code: statusOK},
+	}
+	if !isZero(s.tc.spanID[:]) {
+		o.ParentSpanID = hex.EncodeToString(s.tc.spanID[:])
+	}
+	if s.err != nil {
+		o.Status = otlpStatus{Code: statusError, Message: s.err.Error()}
+	}
+	t.add(o)
+}
+
+func strAttr(k, v string) otlpAttr {
+	return otlpAttr{Key: k, Value: otlpValue{StringValue: &v}}
+}
+
+func intAttr(k string, v int) otlpAttr {
+	s := strconv.Itoa(v)
+	return otlpAttr{Key: k, Value: otlpValue{IntValue: &s}}
+}
+
+// types of OTLP JSON, ExportTraceServiceRequest.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope   `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpSpan struct {
+	TraceID      string     `json:"traceId"`
+	SpanID       string     `json:"spanId"`
+	ParentSpanID string     `json:"parentSpanId,omitempty"`
+	Name         string     `json:"name"`
+	Kind         int        `json:"kind"`
+	Start        string     `json:"startTimeUnixNano"`
+	End          string     `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+	Status       otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func newOTLPRequest(spans []*otlpSpan) *otlpRequest {
+	return &otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: []otlpAttr{
+				strAttr("service.name", "sqs-notify2"),
+				strAttr("service.version", Version),
+			}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/koron/sqs-notify/sqsnotify2", Version: Version},
+				Spans: spans,
+			}},
+		}},
+	}
+}
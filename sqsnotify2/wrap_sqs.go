@@ -10,24 +10,15 @@ import (
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
 
-func getQueueURL(api sqsiface.SQSAPI, queueName string, create bool) (*string, error) {
-	rGet, err := api.GetQueueUrl(&sqs.GetQueueUrlInput{
-		QueueName: aws.String(queueName),
-	})
+func getQueueURL(ctx context.Context, api sqsiface.SQSAPI, queueName string, create bool, qo *QueueOptions) (*string, error) {
+	qu, err := GetQueueURL(ctx, api, queueName)
 	if err == nil {
-		return rGet.QueueUrl, nil
+		return qu, nil
 	}
 	if !create || !isQueueDoesNotExist(err) {
 		return nil, err
 	}
-
-	rCreate, err := api.CreateQueue(&sqs.CreateQueueInput{
-		QueueName: aws.String(queueName),
-	})
-	if err != nil {
-		return nil, err
-	}
-	return rCreate.QueueUrl, nil
+	return CreateQueue(ctx, api, queueName, qo)
 }
 
 func isQueueDoesNotExist(err0 error) bool {
@@ -38,11 +29,13 @@ func isQueueDoesNotExist(err0 error) bool {
 	return err.Code() == sqs.ErrCodeQueueDoesNotExist
 }
 
-func receiveMessages(ctx context.Context, api sqsiface.SQSAPI, queueURL *string, max int64, waitTime *int64) ([]*sqs.Message, error) {
+func receiveMessages(ctx context.Context, api sqsiface.SQSAPI, queueURL *string, max int64, waitTime *int64, attrNames, msgAttrNames []*string) ([]*sqs.Message, error) {
 	out, err := api.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
-		QueueUrl:            queueURL,
-		MaxNumberOfMessages: &max,
-		WaitTimeSeconds:     waitTime,
+		QueueUrl:              queueURL,
+		MaxNumberOfMessages:   &max,
+		WaitTimeSeconds:       waitTime,
+		AttributeNames:        attrNames,
+		MessageAttributeNames: msgAttrNames,
 	})
 	if err != nil {
 		return nil, err
@@ -71,3 +64,12 @@ func deleteMessages(ctx context.Context, api sqsiface.SQSAPI, queueURL *string,
 	}
 	return nil
 }
+
+func changeVisibility(ctx context.Context, api sqsiface.SQSAPI, queueURL *string, receiptHandle *string, timeout int64) error {
+	_, err := api.ChangeMessageVisibilityWithContext(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          queueURL,
+		ReceiptHandle:     receiptHandle,
+		VisibilityTimeout: aws.Int64(timeout),
+	})
+	return err
+}